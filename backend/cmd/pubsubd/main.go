@@ -0,0 +1,27 @@
+// Command pubsubd runs the broker stand-in so several backend instances can
+// share a backplane locally without Redis:
+//
+//	go run ./cmd/pubsubd -addr 127.0.0.1:6380
+//	REDIS_ADDR=127.0.0.1:6380 go run .
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"backend/pkg/broker"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6380", "listen address")
+	flag.Parse()
+
+	s, err := broker.ListenStandIn(*addr)
+	if err != nil {
+		slog.Error("pubsubd: listen", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("pub/sub stand-in listening", "addr", s.Addr())
+	select {}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS chat_attachments (
+    id TEXT PRIMARY KEY,
+    uploader_id TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    original_name TEXT,
+    mime_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    message_kind TEXT CHECK(message_kind IN ('dm', 'group')),
+    message_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (uploader_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_attachments_message ON chat_attachments(message_kind, message_id);
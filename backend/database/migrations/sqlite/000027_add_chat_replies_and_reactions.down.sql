@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS chat_reactions;
+ALTER TABLE group_chat DROP COLUMN reply_to;
+ALTER TABLE messages DROP COLUMN reply_to;
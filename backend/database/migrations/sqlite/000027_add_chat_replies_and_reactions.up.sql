@@ -0,0 +1,13 @@
+ALTER TABLE messages ADD COLUMN reply_to INTEGER;
+ALTER TABLE group_chat ADD COLUMN reply_to INTEGER;
+
+CREATE TABLE IF NOT EXISTS chat_reactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_kind TEXT NOT NULL CHECK(message_kind IN ('dm', 'group')),
+    message_id INTEGER NOT NULL,
+    user_id TEXT NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(message_kind, message_id, user_id, emoji),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS group_chat_reads (
+    group_id INTEGER NOT NULL,
+    user_id TEXT NOT NULL,
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id),
+    FOREIGN KEY (group_id) REFERENCES groups(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
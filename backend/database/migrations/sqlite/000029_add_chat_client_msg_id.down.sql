@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_group_chat_client_msg_id;
+DROP INDEX IF EXISTS idx_messages_client_msg_id;
+ALTER TABLE group_chat DROP COLUMN client_msg_id;
+ALTER TABLE messages DROP COLUMN client_msg_id;
@@ -0,0 +1,7 @@
+ALTER TABLE messages ADD COLUMN client_msg_id TEXT;
+ALTER TABLE group_chat ADD COLUMN client_msg_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_msg_id
+    ON messages(sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_group_chat_client_msg_id
+    ON group_chat(sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL;
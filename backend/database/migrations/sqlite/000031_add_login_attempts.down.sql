@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN locked_until;
+DROP INDEX IF EXISTS idx_login_attempts_ip;
+DROP INDEX IF EXISTS idx_login_attempts_user;
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes shared by the HTTP API and the websocket protocol. Features add
+// their own (email_unverified, account_locked, not_member, ...); these are the
+// generic ones every status maps to.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeBadJSON          = "bad_json"
+	CodeValidation       = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeTooLarge         = "body_too_large"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+)
+
+// APIError is the one error shape clients see: as the JSON body of a failed
+// HTTP request and as the code and message of a websocket nack.
+type APIError struct {
+	Status     int // HTTP status; unused on the websocket
+	Code       string
+	Message    string
+	Fields     []FieldError // set with validation_failed
+	RetryAfter time.Duration
+}
+
+// FieldError names one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"` // required, invalid, too_long, ...
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string { return e.Code + ": " + e.Message }
+
+// ErrorBody is how an APIError is written on the wire.
+type ErrorBody struct {
+	OK         bool         `json:"ok"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Fields     []FieldError `json:"fields,omitempty"`
+	RetryAfter int          `json:"retry_after,omitempty"` // seconds
+}
+
+func (e *APIError) MarshalJSON() ([]byte, error) {
+	b := ErrorBody{Code: e.Code, Message: e.Message, Fields: e.Fields}
+	if e.RetryAfter > 0 {
+		b.RetryAfter = retryAfterSeconds(e.RetryAfter)
+	}
+	return json.Marshal(b)
+}
+
+// apiErr builds an error with the generic code for status.
+func apiErr(status int, msg string) *APIError {
+	return &APIError{Status: status, Code: codeForStatus(status), Message: msg}
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMedia
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// writeError sends err as an APIError body. Anything else is logged and
+// reported as a bare 500 so internals don't leak to the client.
+func writeError(w http.ResponseWriter, err error) {
+	var e *APIError
+	if !errors.As(err, &e) {
+		// the access log line carries it along with the request id
+		if !noteError(w, err) {
+			slog.Error("internal error", "err", err)
+		}
+		e = apiErr(http.StatusInternalServerError, "Internal server error")
+	}
+	status := e.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(e.RetryAfter)))
+	}
+	writeJSON(w, status, e)
+}
+
+// validation collects every problem with a request body so the client can
+// show them all at once.
+type validation struct {
+	fields []FieldError
+}
+
+func (v *validation) add(field, code, msg string) {
+	v.fields = append(v.fields, FieldError{Field: field, Code: code, Message: msg})
+}
+
+// required fails field when value is empty; it reports whether value is set.
+func (v *validation) required(field, value, label string) bool {
+	if value == "" {
+		v.add(field, "required", label+" is required")
+		return false
+	}
+	return true
+}
+
+func (v *validation) maxLen(field, value string, n int, label string) {
+	if len([]rune(value)) > n {
+		v.add(field, "too_long", fmt.Sprintf("%s must be at most %d characters", label, n))
+	}
+}
+
+func (v *validation) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "invalid", fmt.Sprintf("%s must be one of %s", field, strings.Join(allowed, ", ")))
+}
+
+// err is nil when every check passed. The message repeats the first problem
+// for clients that only show one line.
+func (v *validation) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &APIError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    CodeValidation,
+		Message: v.fields[0].Message,
+		Fields:  v.fields,
+	}
+}
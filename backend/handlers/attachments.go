@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"backend/pkg/db/sqlite"
+	"backend/pkg/store"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxAttachmentSize        = 25 << 20
+	maxAttachmentsPerMessage = 10
+)
+
+// Attachment is a chat attachment as clients see it, with URL set by withURLs.
+type Attachment = store.Attachment
+
+func attachmentURL(id string) string {
+	return "/api/chat/attachments/" + id
+}
+
+// AttachmentForm is the multipart upload of one chat attachment.
+type AttachmentForm struct {
+	File *multipart.FileHeader `form:"file,required"`
+}
+
+type AttachmentResponse struct {
+	OK         bool       `json:"ok"`
+	Attachment Attachment `json:"attachment"`
+}
+
+// POST /api/chat/attachments (multipart, field "file")
+func UploadChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	// one file plus the multipart envelope; parseForm's own cap is for posts
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	var form AttachmentForm
+	if err := decodeForm(w, r, &form); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	handler := form.File
+	file, err := handler.Open()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	mimeType, err := validateAttachment(file, handler)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := os.MkdirAll(settings.AttachmentsDir, 0o750); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to prepare attachment folder")
+		return
+	}
+
+	id := uuid.New().String()
+	fileName := id + strings.ToLower(filepath.Ext(handler.Filename))
+	dst, err := os.Create(filepath.Join(settings.AttachmentsDir, fileName))
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to save attachment")
+		return
+	}
+
+	size, err := io.Copy(dst, file)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst.Name())
+		reqLog(r).Error("save attachment", "err", err)
+		writeErr(w, http.StatusInternalServerError, "Failed to save attachment")
+		return
+	}
+
+	originalName := filepath.Base(handler.Filename)
+	if _, err := sqlite.DB.Exec(`
+		INSERT INTO chat_attachments (id, uploader_id, file_name, original_name, mime_type, size)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, userID, fileName, originalName, mimeType, size); err != nil {
+		_ = os.Remove(filepath.Join(settings.AttachmentsDir, fileName))
+		writeErr(w, http.StatusInternalServerError, "Failed to save attachment")
+		return
+	}
+	uploadBytes.Add(float64(size), "attachment")
+
+	writeJSON(w, http.StatusCreated, AttachmentResponse{
+		OK: true,
+		Attachment: Attachment{
+			ID:       id,
+			Name:     originalName,
+			MimeType: mimeType,
+			Size:     size,
+			URL:      attachmentURL(id),
+		},
+	})
+}
+
+// GET /api/chat/attachments/{id}
+// only the uploader, the two DM participants or members of the group can fetch
+func (s *Server) GetChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeErr(w, http.StatusBadRequest, "attachment id is required")
+		return
+	}
+
+	var (
+		uploaderID, fileName, mimeType string
+		originalName, kind             sql.NullString
+		messageID                      sql.NullInt64
+	)
+	err := sqlite.DB.QueryRow(`
+		SELECT uploader_id, file_name, original_name, mime_type, message_kind, message_id
+		FROM chat_attachments WHERE id = ?
+	`, id).Scan(&uploaderID, &fileName, &originalName, &mimeType, &kind, &messageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeErr(w, http.StatusNotFound, "Attachment not found")
+		} else {
+			writeErr(w, http.StatusInternalServerError, "Database error")
+		}
+		return
+	}
+
+	allowed, err := s.canAccessAttachment(userID, uploaderID, kind.String, messageID.Int64)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !allowed {
+		// don't reveal that the attachment exists
+		writeErr(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	f, err := os.Open(filepath.Join(settings.AttachmentsDir, fileName))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to read attachment")
+		return
+	}
+
+	disposition := "attachment"
+	if strings.HasPrefix(mimeType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, originalName.String))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", st.ModTime(), f)
+}
+
+func (s *Server) canAccessAttachment(userID, uploaderID, kind string, messageID int64) (bool, error) {
+	if userID == uploaderID {
+		return true, nil
+	}
+
+	switch kind {
+	case "dm":
+		var ok bool
+		err := sqlite.DB.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM messages WHERE id = ? AND (sender_id = ? OR receiver_id = ?)
+			)
+		`, messageID, userID, userID).Scan(&ok)
+		return ok, err
+	case "group":
+		var groupID string
+		err := sqlite.DB.QueryRow(`SELECT group_id FROM group_chat WHERE id = ?`, messageID).Scan(&groupID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return s.isGroupMember(userID, groupID)
+	default:
+		// not sent yet, only the uploader can see it
+		return false, nil
+	}
+}
+
+// checkAttachments makes sure every id was uploaded by the sender, is listed
+// once and is not attached to another message yet. It gives the sender a
+// precise error; the message insert binds the ids again in its transaction,
+// so a send racing for the same upload still fails there.
+func checkAttachments(db *sql.DB, uploaderID string, ids []string) error {
+	if len(ids) > maxAttachmentsPerMessage {
+		return fmt.Errorf("too many attachments (max %d)", maxAttachmentsPerMessage)
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("attachment %s listed twice", id)
+		}
+		seen[id] = true
+		var free bool
+		err := db.QueryRow(`
+			SELECT message_id IS NULL FROM chat_attachments WHERE id = ? AND uploader_id = ?
+		`, id, uploaderID).Scan(&free)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("unknown attachment %s", id)
+		}
+		if err != nil {
+			return err
+		}
+		if !free {
+			return fmt.Errorf("attachment %s already sent", id)
+		}
+	}
+	return nil
+}
+
+// sentAttachments returns the files bound to a freshly stored message.
+func (s *Server) sentAttachments(kind, messageID string, ids []string) ([]Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	byMsg, err := s.Store.Messages.Attachments(context.Background(), kind, []string{messageID})
+	if err != nil {
+		return nil, err
+	}
+	return withURLs(byMsg[messageID]), nil
+}
+
+// withURLs points each attachment at the route that serves it.
+func withURLs(list []Attachment) []Attachment {
+	for i := range list {
+		list[i].URL = attachmentURL(list[i].ID)
+	}
+	return list
+}
+
+// validateAttachment works like validateImage but also accepts PDFs, and
+// returns the sniffed MIME type.
+func validateAttachment(file multipart.File, handler *multipart.FileHeader) (string, error) {
+	if handler.Size > maxAttachmentSize {
+		return "", fmt.Errorf("file size too large - maximum 25MB")
+	}
+
+	allowed := map[string][]string{
+		".jpg":  {"image/jpeg"},
+		".jpeg": {"image/jpeg"},
+		".png":  {"image/png"},
+		".gif":  {"image/gif"},
+		".pdf":  {"application/pdf"},
+	}
+	ext := strings.ToLower(filepath.Ext(handler.Filename))
+	mimeTypes, ok := allowed[ext]
+	if !ok {
+		return "", fmt.Errorf("invalid file type. Only JPG, PNG, GIF and PDF are allowed")
+	}
+
+	buffer := make([]byte, 512)
+	n, err := file.Read(buffer)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read file for MIME type detection")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to reset file pointer")
+	}
+
+	mimeType := http.DetectContentType(buffer[:n])
+	for _, m := range mimeTypes {
+		if m == mimeType {
+			return mimeType, nil
+		}
+	}
+	return "", fmt.Errorf("file extension does not match file content")
+}
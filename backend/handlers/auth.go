@@ -2,8 +2,6 @@ package handlers
 
 import (
 	db "backend/pkg/db/sqlite"
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/mail"
 	"strings"
@@ -12,117 +10,189 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeErr(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	var creds struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		writeErr(w, http.StatusBadRequest, "Bad Request")
+	var creds LoginRequest
+	if err := decodeJSON(w, r, &creds); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	hashedPassword, err := db.GetHashedPassword(creds.Email)
-	if err != nil {
-		writeErr(w, http.StatusUnauthorized, "Invalid email or password")
-		return
-	}
-	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(creds.Password)) != nil {
-		writeErr(w, http.StatusUnauthorized, "Invalid email or password")
+	email := strings.ToLower(strings.TrimSpace(creds.Email))
+	ip := clientIP(r)
+
+	// an IP failing across many accounts is cut off before any lookup
+	if n, err := ipFailures(db.DB, ip); err == nil && n >= loginIPMaxFailures {
+		recordLoginAttempt(db.DB, r, "", email, false, loginThrottled)
+		writeLoginBlocked(w, http.StatusTooManyRequests, "too_many_attempts",
+			"Too many failed sign-in attempts, try again later", loginWindow)
 		return
 	}
 
-	// Get UUID user ID (string)
-	uidStr, err := db.GetUserID(creds.Email)
+	uidStr, err := db.GetUserID(email)
 	if err != nil || uidStr == "" {
+		recordLoginAttempt(db.DB, r, "", email, false, loginUnknownEmail)
 		writeErr(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
-	// Create session with string userID
-	if err := setSession(w, uidStr); err != nil {
-		fmt.Println("LoginHandler: setSession error:", err) // Debugging line
-		writeErr(w, http.StatusInternalServerError, "Failed to create session")
+	if d := lockedFor(db.DB, uidStr); d > 0 {
+		recordLoginAttempt(db.DB, r, uidStr, email, false, loginLocked)
+		writeLoginBlocked(w, http.StatusLocked, "account_locked",
+			"This account is temporarily locked after too many failed sign-in attempts", d)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":      true,
-		"message": "Logged in successfully",
-	})
-}
-
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeErr(w, http.StatusMethodNotAllowed, "Invalid request method")
+	failures, lastFailure, err := accountFailures(db.DB, uidStr)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
-
-	// Parse multipart form data
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB max
-		writeErr(w, http.StatusBadRequest, "Error parsing form data")
+	if wait := time.Until(lastFailure.Add(loginDelay(failures))); wait > 0 {
+		recordLoginAttempt(db.DB, r, uidStr, email, false, loginThrottled)
+		writeLoginBlocked(w, http.StatusTooManyRequests, "too_many_attempts",
+			"Too many failed sign-in attempts, wait before trying again", wait)
 		return
 	}
 
-	// Get form values
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-	firstName := strings.TrimSpace(r.FormValue("firstName"))
-	lastName := strings.TrimSpace(r.FormValue("lastName"))
-	dob := r.FormValue("dateOfBirth")
-	nickname := strings.TrimSpace(r.FormValue("nickname"))
-	aboutMe := r.FormValue("aboutMe")
-	avatarPath := r.FormValue("avatar")
+	hashedPassword, err := db.GetHashedPassword(email)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(creds.Password)) != nil {
+		recordLoginAttempt(db.DB, r, uidStr, email, false, loginBadPassword)
+		if failures+1 >= loginLockAfter {
+			s.lockAccount(uidStr, ip)
+			writeLoginBlocked(w, http.StatusLocked, "account_locked",
+				"This account is temporarily locked after too many failed sign-in attempts", loginLockFor)
+			return
+		}
+		writeErr(w, http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
 
-	if email == "" || password == "" || dob == "" || firstName == "" || lastName == ""{
-		writeErr(w, http.StatusBadRequest, "Email, Password, Date of birth, First name, and Last name are required")
+	// with 2FA on, the password only earns a challenge; /api/login/2fa finishes it
+	if twoFactorEnabled(db.DB, uidStr) {
+		if err := startLoginChallenge(w, uidStr); err != nil {
+			writeErr(w, http.StatusInternalServerError, "Failed to start two-factor login")
+			return
+		}
+		recordLoginAttempt(db.DB, r, uidStr, email, false, login2FARequired)
+		writeError(w, &APIError{Status: http.StatusUnauthorized, Code: "two_factor_required", Message: "Enter the code from your authenticator app"})
 		return
 	}
 
-	// Parse the date of birth
-	dobTime, err := time.Parse("2006-01-02", dob)
-	if err != nil {
-		writeErr(w, http.StatusBadRequest, "Invalid date of birth format. Use YYYY-MM-DD")
+	recordLoginAttempt(db.DB, r, uidStr, email, true, loginOK)
+
+	// Create session with string userID
+	if err := setSession(w, uidStr); err != nil {
+		reqLog(r).Error("login: create session", "err", err)
+		writeErr(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
 
-	// Calculate age
-	now := time.Now()
-	age := now.Year() - dobTime.Year()
-	
-	// Adjust age if birthday hasn't occurred yet this year
-	if now.YearDay() < dobTime.YearDay() {
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Logged in successfully"})
+}
+
+// Profile field limits, in characters.
+const (
+	maxNameLen     = 50
+	maxNicknameLen = 30
+	maxAboutMeLen  = 1000
+)
+
+// ageOn is the age in whole years someone born on dob has reached by now.
+func ageOn(dob, now time.Time) int {
+	age := now.Year() - dob.Year()
+	// birthday hasn't come yet this year
+	if now.Month() < dob.Month() || (now.Month() == dob.Month() && now.Day() < dob.Day()) {
 		age--
 	}
+	return age
+}
 
-	// Check if user is at least 18 years old
-	if age < 18 {
-		writeErr(w, http.StatusBadRequest, "You must be at least 18 years old to register")
-		return
+// conflict reports a unique field that is already in use.
+func conflict(field, msg string) *APIError {
+	return &APIError{
+		Status:  http.StatusConflict,
+		Code:    CodeConflict,
+		Message: msg,
+		Fields:  []FieldError{{Field: field, Code: "taken", Message: msg}},
 	}
+}
 
-	if len(password) < 4 {
-		writeErr(w, http.StatusBadRequest, "Password must be at least 4 characters")
-		return
-	}
+// RegisterForm is the sign-up form; avatar is the path of an image uploaded before.
+type RegisterForm struct {
+	Email       string `form:"email,required"`
+	Password    string `form:"password,required"`
+	FirstName   string `form:"firstName,required"`
+	LastName    string `form:"lastName,required"`
+	DateOfBirth string `form:"dateOfBirth,required"`
+	Nickname    string `form:"nickname"`
+	AboutMe     string `form:"aboutMe"`
+	Avatar      string `form:"avatar"`
+}
 
-	if strings.TrimSpace(password) != password {
-		writeErr(w, http.StatusBadRequest, "Password cannot start or end with spaces")
+type RegisterResponse struct {
+	OK            bool   `json:"ok"`
+	Message       string `json:"message"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	if strings.Contains(password, " ") {
-		writeErr(w, http.StatusBadRequest, "Password cannot contain spaces")
+	// Parse multipart form data
+	if err := parseForm(w, r); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	if _, err := mail.ParseAddress(email); err != nil {
-		writeErr(w, http.StatusBadRequest, "Invalid email format")
+	var form RegisterForm
+	v := &validation{}
+	v.form(r, &form)
+
+	email := form.Email
+	password := form.Password
+	firstName := strings.TrimSpace(form.FirstName)
+	lastName := strings.TrimSpace(form.LastName)
+	dob := form.DateOfBirth
+	nickname := strings.TrimSpace(form.Nickname)
+	aboutMe := form.AboutMe
+	avatarPath := form.Avatar
+
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			v.add("email", "invalid", "Invalid email format")
+		}
+	}
+	if password != "" {
+		if err := Passwords.Check(password); err != nil {
+			v.add("password", "weak", err.Error())
+		}
+	}
+	v.maxLen("firstName", firstName, maxNameLen, "First name")
+	v.maxLen("lastName", lastName, maxNameLen, "Last name")
+	v.maxLen("nickname", nickname, maxNicknameLen, "Nickname")
+	v.maxLen("aboutMe", aboutMe, maxAboutMeLen, "About me")
+	if dob != "" {
+		if dobTime, err := time.Parse("2006-01-02", dob); err != nil {
+			v.add("dateOfBirth", "invalid", "Invalid date of birth format. Use YYYY-MM-DD")
+		} else if ageOn(dobTime, time.Now()) < 18 {
+			v.add("dateOfBirth", "too_young", "You must be at least 18 years old to register")
+		}
+	}
+	if err := v.err(); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -133,7 +203,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if exists {
-		writeErr(w, http.StatusConflict, "Email already registered")
+		writeError(w, conflict("email", "Email already registered"))
 		return
 	}
 
@@ -144,7 +214,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if nicknameExists && nickname!= ""{
-		writeErr(w, http.StatusConflict, "Nickname already taken")
+		writeError(w, conflict("nickname", "Nickname already taken"))
 		return
 	}
 
@@ -167,16 +237,22 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the account works right away but can't message or post until confirmed
+	if err := sendEmailToken(uidStr, emailVerify, strings.ToLower(email)); err != nil {
+		reqLog(r).Error("register: verification email", "err", err)
+	}
+
 	// Create session with string userID
 	if err := setSession(w, uidStr); err != nil {
-		fmt.Println("RegisterHandler: setSession error:", err) // Debugging line
+		reqLog(r).Error("register: create session", "err", err)
 		writeErr(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":      true,
-		"message": "Registered successfully",
+	writeJSON(w, http.StatusOK, RegisterResponse{
+		OK:            true,
+		Message:       "Registered successfully, check your email to confirm your address",
+		EmailVerified: false,
 	})
 }
 
@@ -203,7 +279,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if err == nil && c.Value != "" {
 		if derr := db.DeleteSessionByToken(c.Value); derr != nil {
 			// Log but don't fail the request; logout should be idempotent
-			fmt.Printf("logout: delete session failed: %v\n", derr)
+			reqLog(r).Warn("logout: delete session", "err", derr)
 		}
 	}
 
@@ -216,11 +292,8 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		Secure:   false, // true in HTTPS
+		Secure:   settings.SecureCookies,
 	})
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":      true,
-		"message": "Logged out",
-	})
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Logged out"})
 }
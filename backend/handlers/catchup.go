@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// max messages of each kind replayed per resume frame; the client asks again
+// with the returned ids while has_more is true
+const resumeBatchSize = 500
+
+// ResumeIn is sent by the client right after connecting with the newest
+// message ids it already has. Since applies to each stream whose id is zero.
+type ResumeIn struct {
+	LastDMID           int64  `json:"last_dm_id"`
+	LastGroupMessageID int64  `json:"last_group_message_id"`
+	Since              string `json:"since,omitempty"` // RFC3339
+}
+
+// ResumeDone closes a replay; the client resumes again from these ids while HasMore is set.
+type ResumeDone struct {
+	DMCount            int   `json:"dm_count"`
+	GroupCount         int   `json:"group_count"`
+	LastDMID           int64 `json:"last_dm_id"`
+	LastGroupMessageID int64 `json:"last_group_message_id"`
+	HasMore            bool  `json:"has_more"`
+}
+
+// handleResume replays the DMs and group messages the user missed while
+// disconnected, oldest first, then sends resume.done with the new high-water marks.
+func (s *Server) handleResume(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in ResumeIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if in.Since != "" && (in.LastDMID == 0 || in.LastGroupMessageID == 0) {
+		t, err := time.Parse(time.RFC3339, in.Since)
+		if err != nil {
+			return nil, frameErr("bad_resume", "since must be RFC3339")
+		}
+		since = t
+	}
+	dmSince, groupSince := since, since
+	if in.LastDMID != 0 {
+		dmSince = time.Time{}
+	}
+	if in.LastGroupMessageID != 0 {
+		groupSince = time.Time{}
+	}
+
+	dms, dmMore, err := s.missedDMs(userID, in.LastDMID, dmSince)
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+	groupMsgs, groupMore, err := s.missedGroupMessages(userID, in.LastGroupMessageID, groupSince)
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+
+	// A truncated stream continues in the next batch, so nothing newer than its
+	// last message may be sent from the other stream yet or the order breaks.
+	var cutoff string
+	if dmMore {
+		cutoff = dms[len(dms)-1].TS
+	}
+	if groupMore && (cutoff == "" || groupMsgs[len(groupMsgs)-1].TS < cutoff) {
+		cutoff = groupMsgs[len(groupMsgs)-1].TS
+	}
+	if cutoff != "" {
+		dms = dms[:countUpTo(len(dms), cutoff, func(i int) string { return dms[i].TS })]
+		groupMsgs = groupMsgs[:countUpTo(len(groupMsgs), cutoff, func(i int) string { return groupMsgs[i].TS })]
+	}
+
+	lastDM, lastGroup := in.LastDMID, in.LastGroupMessageID
+
+	// merge both streams by time so the client sees them in the order they happened
+	i, j := 0, 0
+	for i < len(dms) || j < len(groupMsgs) {
+		if j >= len(groupMsgs) || (i < len(dms) && dms[i].TS <= groupMsgs[j].TS) {
+			_ = client.SendJSON(Frame{V: wsProtocolVersion, Type: "dm", Data: dms[i], Replay: true})
+			lastDM, _ = strconv.ParseInt(dms[i].ID, 10, 64)
+			i++
+			continue
+		}
+		_ = client.SendJSON(Frame{V: wsProtocolVersion, Type: "group_message", Data: groupMsgs[j], Replay: true})
+		lastGroup, _ = strconv.ParseInt(groupMsgs[j].ID, 10, 64)
+		j++
+	}
+
+	done := ResumeDone{
+		DMCount:            len(dms),
+		GroupCount:         len(groupMsgs),
+		LastDMID:           lastDM,
+		LastGroupMessageID: lastGroup,
+		HasMore:            cutoff != "",
+	}
+	_ = client.SendJSON(newFrame("resume.done", done))
+	return done, nil
+}
+
+// countUpTo returns how many of the n leading timestamps are not after cutoff.
+func countUpTo(n int, cutoff string, ts func(int) string) int {
+	for i := 0; i < n; i++ {
+		if ts(i) > cutoff {
+			return i
+		}
+	}
+	return n
+}
+
+// missedDMs returns DMs to or from the user newer than afterID (or sent after since).
+func (s *Server) missedDMs(userID string, afterID int64, since time.Time) ([]DMOut, bool, error) {
+	msgs, err := s.Store.Messages.MissedDMs(context.Background(), userID, afterID, since, resumeBatchSize+1)
+	if err != nil {
+		return nil, false, err
+	}
+	more := len(msgs) > resumeBatchSize
+	if more {
+		msgs = msgs[:resumeBatchSize]
+	}
+	out, err := s.dmOuts(context.Background(), msgs)
+	return out, more, err
+}
+
+// missedGroupMessages returns messages newer than afterID (or sent after since)
+// in every group the user is currently an accepted member of.
+func (s *Server) missedGroupMessages(userID string, afterID int64, since time.Time) ([]GroupMsgOut, bool, error) {
+	msgs, err := s.Store.Messages.MissedGroupMessages(context.Background(), userID, afterID, since, resumeBatchSize+1)
+	if err != nil {
+		return nil, false, err
+	}
+	more := len(msgs) > resumeBatchSize
+	if more {
+		msgs = msgs[:resumeBatchSize]
+	}
+	out, err := s.groupMsgOuts(context.Background(), msgs)
+	return out, more, err
+}
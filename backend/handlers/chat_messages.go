@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/pkg/store"
+)
+
+const maxClientMsgIDLen = 64
+
+// dmOuts turns stored DMs into their wire form, with attachments and quoted
+// parents filled in.
+func (s *Server) dmOuts(ctx context.Context, msgs []store.Message) ([]DMOut, error) {
+	extras, err := s.messageExtrasFor(ctx, "dm", msgs)
+	if err != nil {
+		return nil, err
+	}
+	var out []DMOut
+	for _, m := range msgs {
+		dm := DMOut{
+			ID:          m.ID,
+			From:        m.SenderID,
+			To:          m.ReceiverID,
+			Text:        m.Content,
+			TS:          m.SentAt.UTC().Format(time.RFC3339),
+			Attachments: extras.attachments[m.ID],
+			ClientMsgID: m.ClientMsgID,
+		}
+		if m.ReplyTo != "" {
+			dm.ReplyTo = extras.replies[m.ReplyTo]
+		}
+		out = append(out, dm)
+	}
+	return out, nil
+}
+
+// groupMsgOuts is dmOuts for group messages.
+func (s *Server) groupMsgOuts(ctx context.Context, msgs []store.Message) ([]GroupMsgOut, error) {
+	extras, err := s.messageExtrasFor(ctx, "group", msgs)
+	if err != nil {
+		return nil, err
+	}
+	var out []GroupMsgOut
+	for _, m := range msgs {
+		gm := GroupMsgOut{
+			ID:          m.ID,
+			From:        m.SenderID,
+			GroupID:     m.GroupID,
+			Text:        m.Content,
+			TS:          m.SentAt.UTC().Format(time.RFC3339),
+			Attachments: extras.attachments[m.ID],
+			ClientMsgID: m.ClientMsgID,
+		}
+		if m.ReplyTo != "" {
+			gm.ReplyTo = extras.replies[m.ReplyTo]
+		}
+		out = append(out, gm)
+	}
+	return out, nil
+}
+
+// retriedDM answers a send whose client_msg_id is already stored as prev: the
+// ack carries prev, unless the retry names a different recipient.
+func retriedDM(prev *DMOut, in DMIn) (any, error) {
+	if prev.To != in.To {
+		return nil, frameErr("client_msg_id_conflict", "client_msg_id already used for another recipient")
+	}
+	return prev, nil
+}
+
+// retriedGroupMessage is retriedDM for group messages.
+func retriedGroupMessage(prev *GroupMsgOut, in GroupMsgIn) (any, error) {
+	if prev.GroupID != in.GroupID {
+		return nil, frameErr("client_msg_id_conflict", "client_msg_id already used for another group")
+	}
+	return prev, nil
+}
+
+// dmByClientID returns the DM the sender already stored under clientMsgID, or nil.
+func (s *Server) dmByClientID(senderID, clientMsgID string) (*DMOut, error) {
+	m, err := s.Store.Messages.DMByClientID(context.Background(), senderID, clientMsgID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.dmOuts(context.Background(), []store.Message{m})
+	if err != nil {
+		return nil, err
+	}
+	return &out[0], nil
+}
+
+// groupMessageByClientID returns the group message the sender already stored under clientMsgID, or nil.
+func (s *Server) groupMessageByClientID(senderID, clientMsgID string) (*GroupMsgOut, error) {
+	m, err := s.Store.Messages.GroupMessageByClientID(context.Background(), senderID, clientMsgID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.groupMsgOuts(context.Background(), []store.Message{m})
+	if err != nil {
+		return nil, err
+	}
+	return &out[0], nil
+}
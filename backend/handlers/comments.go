@@ -2,14 +2,8 @@ package handlers
 
 import (
 	"database/sql"
-	"encoding/json"
-	"fmt"
-	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -25,29 +19,37 @@ type Comment struct {
 	CreatedAt time.Time `json:"created_at,omitempty"`
 }
 
+// CommentForm adds a comment when content is set and lists the post's
+// comments when it isn't.
+type CommentForm struct {
+	PostID  int                   `form:"post_id,required"`
+	Content string                `form:"content"`
+	Image   *multipart.FileHeader `form:"image"`
+}
+
 func CommentsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-
-		postID, err := strconv.Atoi(strings.TrimSpace(r.FormValue("post_id")))
-		if err != nil || postID <= 0 {
-			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		var form CommentForm
+		if err := decodeForm(w, r, &form); err != nil {
+			writeError(w, err)
 			return
 		}
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
+		postID := form.PostID
+		if postID <= 0 {
+			writeErr(w, http.StatusBadRequest, "Invalid post ID")
 			return
 		}
+		userID := currentUserID(r)
 
-		content := strings.TrimSpace(r.FormValue("content"))
+		content := strings.TrimSpace(form.Content)
 		if content != "" {
-			if err := r.ParseMultipartForm(25 << 20); err != nil { // 25MB
-				writeErr(w, http.StatusBadRequest, "Error parsing form")
-				return
-			}
 			var imagePath string
-			file, handler, err := r.FormFile("image")
-			if err == nil {
+			if handler := form.Image; handler != nil {
+				file, err := handler.Open()
+				if err != nil {
+					writeErr(w, http.StatusBadRequest, "Failed to read image")
+					return
+				}
 				defer file.Close()
 
 				// Validate image
@@ -56,24 +58,9 @@ func CommentsHandler(db *sql.DB) http.HandlerFunc {
 					return
 				}
 
-				uploadDir := filepath.Join(".", "uploads")
-				// Ensure uploads dir exists
-				if mkErr := os.MkdirAll(uploadDir, os.ModePerm); mkErr != nil {
-					writeErr(w, http.StatusInternalServerError, "Failed to prepare upload folder")
-					return
-				}
-
-				// Simple unique filename to avoid collisions
-				filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), path.Base(handler.Filename))
-				filepath := filepath.Join(uploadDir, filename)
-				dst, createErr := os.Create(filepath)
-				if createErr != nil {
-					writeErr(w, http.StatusInternalServerError, "Failed to save image")
-					return
-				}
-				defer dst.Close()
-
-				if _, copyErr := io.Copy(dst, file); copyErr != nil {
+				filename, err := saveUpload(file, handler)
+				if err != nil {
+					reqLog(r).Error("save upload", "err", err)
 					writeErr(w, http.StatusInternalServerError, "Failed to save image")
 					return
 				}
@@ -91,10 +78,10 @@ func CommentsHandler(db *sql.DB) http.HandlerFunc {
 				writeErr(w, http.StatusInternalServerError, "Failed to insert post")
 				return
 			}
-			writeJSON(w, http.StatusCreated, map[string]any{
-				"ok":      true,
-				"message": "Comment created successfully",
-				"id":      postID,
+			writeJSON(w, http.StatusCreated, CreatedResponse{
+				OK:      true,
+				Message: "Comment created successfully",
+				ID:      int64(postID),
 			})
 		} else {
 			rows, err := db.Query(`
@@ -113,12 +100,12 @@ func CommentsHandler(db *sql.DB) http.HandlerFunc {
     ORDER BY c.created_at DESC
 `, postID)
 			if err != nil {
-				http.Error(w, "Failed to query comments", http.StatusInternalServerError)
+				writeErr(w, http.StatusInternalServerError, "Failed to query comments")
 				return
 			}
 			defer rows.Close()
 
-			var Comments []Comment
+			Comments := []Comment{}
 			for rows.Next() {
 				var comment Comment
 				if err := rows.Scan(
@@ -131,14 +118,13 @@ func CommentsHandler(db *sql.DB) http.HandlerFunc {
 					&comment.Image,
 					&comment.CreatedAt,
 				); err != nil {
-					http.Error(w, "Error scanning posts", http.StatusInternalServerError)
+					writeErr(w, http.StatusInternalServerError, "Error scanning posts")
 					return
 				}
 				Comments = append(Comments, comment)
 			}
 
-			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(Comments)
+			writeJSON(w, http.StatusOK, Comments)
 		}
 	}
 }
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Cookie sessions are sent on any request the browser makes, so state-changing
+// requests must prove they came from one of our own pages. Browsers always
+// attach Origin to cross-site POSTs (and Referer as a fallback), which a
+// foreign page can't forge.
+
+var (
+	originsMu      sync.RWMutex
+	allowedOrigins = originSet(settings.AllowedOrigins)
+)
+
+func originSet(origins []string) map[string]bool {
+	m := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			m[strings.ToLower(o)] = true
+		}
+	}
+	return m
+}
+
+// SetAllowedOrigins replaces the frontend origins trusted for CORS, CSRF and
+// WebSocket upgrades, e.g. "https://app.example.com".
+func SetAllowedOrigins(origins []string) {
+	m := originSet(origins)
+	originsMu.Lock()
+	allowedOrigins = m
+	originsMu.Unlock()
+}
+
+// OriginAllowed reports whether origin (scheme://host[:port]) is a trusted frontend.
+func OriginAllowed(origin string) bool {
+	originsMu.RLock()
+	defer originsMu.RUnlock()
+	return allowedOrigins[strings.ToLower(origin)]
+}
+
+// requestScheme is the scheme the browser used to reach us. Nothing in front
+// of the backend sets X-Forwarded-Proto, so behind a TLS-terminating proxy
+// SECURE_COOKIES is what says the site is served over HTTPS.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || settings.SecureCookies {
+		return "https"
+	}
+	return "http"
+}
+
+// sameOrigin accepts an origin that points at this server itself, scheme
+// included: http://host is a different origin from https://host.
+func sameOrigin(r *http.Request, u *url.URL) bool {
+	return strings.EqualFold(u.Scheme, requestScheme(r)) && strings.EqualFold(u.Host, r.Host)
+}
+
+// requestOrigin is the Origin header, or the origin part of Referer when a
+// browser left Origin out. ok is false when neither is present.
+func requestOrigin(r *http.Request) (u *url.URL, ok bool) {
+	raw := r.Header.Get("Origin")
+	if raw == "" {
+		raw = r.Header.Get("Referer")
+	}
+	if raw == "" {
+		return nil, false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// "null" (sandboxed frames, some redirects) lands here too
+		return &url.URL{}, true
+	}
+	return u, true
+}
+
+// trustedRequest applies the origin rules: no Origin or Referer at all means a
+// non-browser client, which can't be the victim of CSRF.
+func trustedRequest(r *http.Request) bool {
+	u, ok := requestOrigin(r)
+	if !ok {
+		return true
+	}
+	if u.Host == "" {
+		return false
+	}
+	return OriginAllowed(u.Scheme+"://"+u.Host) || sameOrigin(r, u)
+}
+
+// CSRFProtect rejects state-changing requests from untrusted origins. Reads
+// and CORS preflights pass through.
+func CSRFProtect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next(w, r)
+			return
+		}
+		if !trustedRequest(r) {
+			writeError(w, &APIError{Status: http.StatusForbidden, Code: "csrf_rejected", Message: "Request origin not allowed"})
+			return
+		}
+		next(w, r)
+	}
+}
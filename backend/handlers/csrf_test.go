@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedRequest(t *testing.T) {
+	SetAllowedOrigins([]string{"http://localhost:3000"})
+	t.Cleanup(func() { SetAllowedOrigins(settings.AllowedOrigins) })
+
+	for _, tc := range []struct {
+		name   string
+		tls    bool
+		secure bool
+		header string
+		value  string
+		want   bool
+	}{
+		{"no origin", false, false, "", "", true},
+		{"allowed frontend", false, false, "Origin", "http://localhost:3000", true},
+		{"frontend over the wrong scheme", false, false, "Origin", "https://localhost:3000", false},
+		{"foreign site", false, false, "Origin", "https://evil.example", false},
+		{"null origin", false, false, "Origin", "null", false},
+		{"same host over http", false, false, "Origin", "http://api.example", true},
+		{"same host, https page to http server", false, false, "Origin", "https://api.example", false},
+		{"same host over tls", true, false, "Origin", "https://api.example", true},
+		{"http page to tls server", true, false, "Origin", "http://api.example", false},
+		{"behind an https proxy", false, true, "Origin", "https://api.example", true},
+		{"http page behind an https proxy", false, true, "Origin", "http://api.example", false},
+		{"referer fallback", false, false, "Referer", "http://api.example/settings", true},
+		{"foreign referer", false, false, "Referer", "http://evil.example/", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := settings.SecureCookies
+			settings.SecureCookies = tc.secure
+			defer func() { settings.SecureCookies = prev }()
+
+			r := httptest.NewRequest(http.MethodPost, "http://api.example/api/posts", nil)
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tc.header != "" {
+				r.Header.Set(tc.header, tc.value)
+			}
+			if got := trustedRequest(r); got != tc.want {
+				t.Errorf("trustedRequest = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	db "backend/pkg/db/sqlite"
+	"backend/pkg/mailer"
+)
+
+const emailTokenTTL = 24 * time.Hour
+
+// Email token purposes: confirming the address an account signed up with,
+// and confirming a new address before it replaces the old one.
+const (
+	emailVerify = "verify"
+	emailChange = "change"
+)
+
+// Mail delivers account email; main may swap in another transport.
+var Mail mailer.Mailer = mailer.Log{}
+
+// mailing tracks sends in flight so shutdown can wait for them.
+var mailing sync.WaitGroup
+
+// sendMail delivers in the background; failures are only logged since the
+// user can always ask for another link.
+func sendMail(m mailer.Message) {
+	mailing.Add(1)
+	go func() {
+		defer mailing.Done()
+		if err := Mail.Send(m); err != nil {
+			slog.Error("send mail", "subject", m.Subject, "to", m.To, "err", err)
+		}
+	}()
+}
+
+// sendEmailToken mails a fresh confirmation link to email and voids any
+// earlier link for the same purpose.
+func sendEmailToken(userID, purpose, email string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec(`
+		UPDATE email_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL`, userID, purpose); err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec(`
+		INSERT INTO email_tokens(user_id, purpose, email, token_hash, expires_at)
+		VALUES(?, ?, ?, ?, datetime('now', ?))`,
+		userID, purpose, email, hashToken(token), sqliteOffset(emailTokenTTL),
+	); err != nil {
+		return err
+	}
+
+	link := frontendURL("/verify-email") + "?token=" + url.QueryEscape(token)
+	msg := mailer.Message{To: email}
+	switch purpose {
+	case emailVerify:
+		msg.Subject = "Confirm your email address"
+		msg.Body = "Welcome! Open this link to confirm your email address:\n" + link + "\n\n" +
+			"Until then you can't send messages or post.\n"
+	case emailChange:
+		msg.Subject = "Confirm your new email address"
+		msg.Body = "Open this link to start using this address for your account:\n" + link + "\n\n" +
+			"If you didn't ask for this, ignore this email; nothing changes.\n"
+	}
+	sendMail(msg)
+	return nil
+}
+
+// emailVerified reports whether the user has confirmed their address.
+func emailVerified(db *sql.DB, userID string) bool {
+	var ok bool
+	if err := db.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?`, userID).Scan(&ok); err != nil {
+		return false
+	}
+	return ok
+}
+
+func writeUnverified(w http.ResponseWriter) {
+	writeError(w, &APIError{Status: http.StatusForbidden, Code: "email_unverified", Message: "Confirm your email address first"})
+}
+
+// RequireVerified rejects the write methods of next for accounts that haven't
+// confirmed their email. Anonymous requests pass so RequireAuth can answer 401.
+func RequireVerified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next(w, r)
+			return
+		}
+		if p, ok := requestPrincipal(r); ok && !emailVerified(db.DB, p.UserID) {
+			writeUnverified(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestEmailChange parks newEmail on the account until the link sent to it is opened.
+func requestEmailChange(userID, newEmail string) error {
+	if _, err := db.DB.Exec(`UPDATE users SET pending_email = ? WHERE id = ?`, newEmail, userID); err != nil {
+		return err
+	}
+	return sendEmailToken(userID, emailChange, newEmail)
+}
+
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailVerifiedResponse names the address that is now confirmed.
+type EmailVerifiedResponse struct {
+	OK      bool   `json:"ok"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+// POST /api/email/verify
+// Redeems a link from either email; it doesn't need a session so it works
+// from whatever browser the mail is opened in.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body EmailTokenRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Token == "" {
+		writeErr(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	var userID, purpose, email string
+	err := db.DB.QueryRow(`
+		UPDATE email_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')
+		RETURNING user_id, purpose, email
+	`, hashToken(body.Token)).Scan(&userID, &purpose, &email)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "Link is invalid or has expired")
+		return
+	}
+
+	switch purpose {
+	case emailVerify:
+		res, err := db.DB.Exec(`
+			UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+			WHERE id = ? AND email = ?`, userID, email)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// the account moved to another address since this was sent
+			writeErr(w, http.StatusBadRequest, "Link is invalid or has expired")
+			return
+		}
+
+	case emailChange:
+		var old string
+		if err := db.DB.QueryRow(`SELECT email FROM users WHERE id = ? AND pending_email = ?`, userID, email).Scan(&old); err != nil {
+			writeErr(w, http.StatusBadRequest, "Link is invalid or has expired")
+			return
+		}
+		if _, err := db.DB.Exec(`
+			UPDATE users SET email = ?, pending_email = NULL, email_verified_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, email, userID); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				writeErr(w, http.StatusConflict, "Email already registered")
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		sendMail(mailer.Message{
+			To:      old,
+			Subject: "Your email address was changed",
+			Body: "The email address on your account is now " + email + ".\n\n" +
+				"If you didn't do this, reset your password right away.\n",
+		})
+	}
+
+	writeJSON(w, http.StatusOK, EmailVerifiedResponse{OK: true, Email: email, Message: "Email address confirmed"})
+}
+
+// POST /api/email/resend
+// Sends the outstanding confirmation link again: for a pending change if
+// there is one, otherwise for the account's own address.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	var (
+		email    string
+		pending  sql.NullString
+		verified bool
+		err      error
+	)
+	if err := db.DB.QueryRow(`
+		SELECT email, pending_email, email_verified_at IS NOT NULL FROM users WHERE id = ?
+	`, userID).Scan(&email, &pending, &verified); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	switch {
+	case pending.Valid:
+		err = sendEmailToken(userID, emailChange, pending.String)
+		email = pending.String
+	case !verified:
+		err = sendEmailToken(userID, emailVerify, email)
+	default:
+		writeErr(w, http.StatusBadRequest, "Email already verified")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to send email")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Confirmation link sent to " + email})
+}
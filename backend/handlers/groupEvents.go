@@ -1,49 +1,99 @@
 package handlers
 
 import (
-	"database/sql"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"backend/pkg/db/sqlite"
+	"backend/pkg/store"
 )
 
+const (
+	maxEventTitleLen       = 100
+	maxEventDescriptionLen = 2000
+)
+
+type (
+	CreateEventForm struct {
+		GroupID     string `form:"group_id,required"`
+		Title       string `form:"event_title,required"`
+		Description string `form:"event_description,required"`
+		DayAndTime  string `form:"event_day_and_time,required"` // 2006-01-02T15:04 in timezone
+		Timezone    string `form:"timezone"`                    // IANA name, the server's own if unknown
+	}
+	EventVoteForm struct {
+		EventID  string `form:"event_id,required"`
+		GroupID  string `form:"group_id,required"`
+		Response string `form:"response,required"` // one of the store.Event* answers
+	}
+	EventsQuery struct {
+		GroupID  string `query:"group_id,required"`
+		Timezone string `query:"timezone"` // IANA name, UTC if missing or unknown
+	}
+)
+
+// Event is a group event as one viewer sees it, in their timezone.
+type Event struct {
+	ID               int     `json:"id"`
+	Title            string  `json:"title"`
+	Description      string  `json:"description"`
+	Datetime         string  `json:"datetime"`
+	CreatedBy        string  `json:"created_by"`
+	CreatorName      *string `json:"creator_name"`
+	GoingCount       int     `json:"going_count"`
+	NotGoingCount    int     `json:"not_going_count"`
+	MightBeLateCount int     `json:"might_be_late_count"`
+	UserResponse     *string `json:"user_response"`
+}
+
+type EventsResponse struct {
+	OK     bool    `json:"ok"`
+	Events []Event `json:"events"`
+}
+
 // create an event in a group
-func CreateEvent(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CreateEvent(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := currentUserID(r)
 
-	if err := r.ParseForm(); err != nil {
-		writeErr(w, http.StatusBadRequest, "Failed to parse form data")
+	if err := parseForm(w, r); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	groupID := r.FormValue("group_id")
-	title := r.FormValue("event_title")
-	description := r.FormValue("event_description")
-	dayAndTime := r.FormValue("event_day_and_time")
-	userTimezone := r.FormValue("timezone")
-	loc, err := time.LoadLocation(userTimezone)
+	var form CreateEventForm
+	v := &validation{}
+	v.form(r, &form)
+	groupID := form.GroupID
+	title := form.Title
+	description := form.Description
+	dayAndTime := form.DayAndTime
+	loc, err := time.LoadLocation(form.Timezone)
 	if err != nil {
 		loc = time.Local // Fallback
 	}
 
-	if groupID == "" || title == "" || description == "" || dayAndTime == "" {
-		writeErr(w, http.StatusBadRequest, "group id, title, description, day and time are required")
+	// Parse the datetime string from the form (in local time)
+	var localTime time.Time
+	v.maxLen("event_title", title, maxEventTitleLen, "Title")
+	v.maxLen("event_description", description, maxEventDescriptionLen, "Description")
+	if strings.TrimSpace(dayAndTime) != "" {
+		if localTime, err = time.ParseInLocation("2006-01-02T15:04", dayAndTime, loc); err != nil {
+			v.add("event_day_and_time", "invalid", "Invalid datetime format")
+		}
+	}
+	if err := v.err(); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	// must be a member
-	isMember, err := isGroupMember(userID, groupID)
+	isMember, err := s.isGroupMember(userID, groupID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
@@ -53,66 +103,41 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the datetime string from the form (in local time)
-	localTime, err := time.ParseInLocation("2006-01-02T15:04", dayAndTime, loc)
-	if err != nil {
-		writeErr(w, http.StatusBadRequest, "Invalid datetime format")
-		return
-	}
-
-	// Convert local time to UTC for storage
-	utcTime := localTime.UTC()
+	ctx := r.Context()
 
-	// create event
-	res, err := sqlite.DB.Exec(`
-		INSERT INTO events (group_id, title, description, datetime, created_by)
-		VALUES (?, ?, ?, ?, ?)
-	`, groupID, title, description, utcTime.Format("2006-01-02 15:04:05"), userID)
+	// stored in UTC, shown back in each viewer's timezone
+	eventID, err := s.Store.Events.Create(ctx, store.Event{
+		GroupID:     groupID,
+		Title:       title,
+		Description: description,
+		Datetime:    localTime.UTC(),
+		CreatedBy:   userID,
+	})
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Failed to create event")
 		return
 	}
 
-	eventID, _ := res.LastInsertId()
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		writeErr(w, http.StatusInternalServerError, "No rows affected - event not created")
-		return
-	}
-
-	// group title
-	var groupTitle string
-	_ = sqlite.DB.QueryRow(`SELECT title FROM groups WHERE id = ?`, groupID).Scan(&groupTitle)
-
-	// creator public fields
-	var fn, ln, nn, av string
-	_ = sqlite.DB.QueryRow(`
-		SELECT first_name, last_name, nickname, avatar
-		FROM users WHERE id = ?
-	`, userID).Scan(&fn, &ln, &nn, &av)
+	g, _ := s.Store.Groups.Get(ctx, groupID)
+	creator, _ := s.Store.Users.Summary(ctx, userID)
 
 	content := map[string]any{
 		"eventId":     eventID,
 		"groupId":     groupID,
-		"groupTitle":  groupTitle,
+		"groupTitle":  g.Title,
 		"title":       title,
 		"description": description,
 		"datetime":    dayAndTime,
 		"createdBy":   userID,
-		"firstName":   fn,
-		"lastName":    ln,
-		"nickname":    nn,
-		"avatar":      av,
+		"firstName":   creator.FirstName,
+		"lastName":    creator.LastName,
+		"nickname":    creator.Nickname,
+		"avatar":      creator.Avatar,
 	}
 
-	rows, err := sqlite.DB.Query(`SELECT user_id FROM group_members WHERE group_id = ?`, groupID)
+	members, err := s.Store.Groups.MemberIDs(ctx, groupID)
 	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var uid string
-			if err := rows.Scan(&uid); err != nil {
-				continue
-			}
+		for _, uid := range members {
 			if uid == userID {
 				continue
 			}
@@ -125,51 +150,39 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 				},
 			})
 
-			_, _ = insertNotification(sqlite.DB, uid, "group_event_created", content)
-			if uc, err := unreadCount(sqlite.DB, uid); err == nil {
-				PushToUser(uid, map[string]any{
-					"type": "badge.unread",
-					"data": map[string]any{"count": uc},
-				})
-			}
+			_, _ = s.insertNotification(ctx, uid, "group_event_created", content)
+			s.pushUnread(ctx, uid)
 		}
 	}
 
-	writeJSON(w, http.StatusCreated, map[string]any{
-		"ok":      true,
-		"message": "Event created successfully",
-	})
+	writeJSON(w, http.StatusCreated, MessageResponse{OK: true, Message: "Event created successfully"})
 }
 
 // make a vote to an event
-func VoteToEvent(w http.ResponseWriter, r *http.Request) {
+func (s *Server) VoteToEvent(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := currentUserID(r)
 
-	if err := r.ParseForm(); err != nil {
-		writeErr(w, http.StatusBadRequest, "Failed to parse form data")
+	var form EventVoteForm
+	if err := decodeForm(w, r, &form); err != nil {
+		writeError(w, err)
 		return
 	}
+	groupID := form.GroupID
+	response := form.Response
+	eventID := form.EventID
 
-	groupID := r.FormValue("group_id")
-	response := r.FormValue("response")
-	eventID := r.FormValue("event_id")
-
-	if groupID == "" || eventID == "" || (response != "I'll be there" && response != "Can't make it" && response != "Might be late") {
+	if response != store.EventGoing && response != store.EventNotGoing && response != store.EventMightBeLate {
 		writeErr(w, http.StatusBadRequest, "Group ID and valid response are required")
 		return
 	}
 
 	// check user is member of this group
-	isMember, err := isGroupMember(userID, groupID)
+	isMember, err := s.isGroupMember(userID, groupID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
@@ -180,81 +193,39 @@ func VoteToEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check there isn't an existing record of this user voting!
-	var existingResponse string
-	err = sqlite.DB.QueryRow(`
-		SELECT response FROM event_responsess 
-		WHERE user_id = ? AND event_id = ?
-	`, userID, eventID).Scan(&existingResponse)
-
-	//if user has already voted, update their vote
-	if err == nil {
-		result, err := sqlite.DB.Exec(`
-		UPDATE event_responsess
-		SET response = ?
-		WHERE user_id = ? AND event_id = ?
-		`, response, userID, eventID)
-
-		if err != nil {
-			fmt.Println("Error updating vote:", err)
-			writeErr(w, http.StatusInternalServerError, "Failed to update vote")
-			return
-		}
-
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			writeErr(w, http.StatusInternalServerError, "Vote not updated")
-			return
-		}
-
-		writeJSON(w, http.StatusOK, map[string]any{
-			"ok":      true,
-			"message": fmt.Sprintf("you successfully updated your vote to: %s", response),
-		})
-		return
-	} else if err != sql.ErrNoRows {
-		writeErr(w, http.StatusInternalServerError, "Database error")
-		return
-	}
-
-	// insert response to db, if no existing vote
-	result, err := sqlite.DB.Exec(`
-		INSERT INTO event_responsess (event_id, user_id, response) VALUES (?,?,?)
-	`, eventID, userID, response)
+	// a second vote replaces the first
+	replaced, err := s.Store.Events.Vote(r.Context(), eventID, userID, response)
 	if err != nil {
+		reqLog(r).Error("event vote", "err", err)
 		writeErr(w, http.StatusInternalServerError, "Failed to vote to event")
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		writeErr(w, http.StatusInternalServerError, "Vote not recorded")
-		return
+	message := fmt.Sprintf("you successfully voted: %s", response)
+	if replaced {
+		message = fmt.Sprintf("you successfully updated your vote to: %s", response)
 	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":      true,
-		"message": fmt.Sprintf("you successfully voted: %s", response),
-	})
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: message})
 }
 
 // get all events associated with a group
-func GetEventsForGroup(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetEventsForGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
+	userID := currentUserID(r)
+
+	var q EventsQuery
+	if err := decodeQuery(r, &q); err != nil {
+		writeError(w, err)
 		return
 	}
-
-	groupID := r.URL.Query().Get("group_id")
+	groupID := q.GroupID
 
 	// check user is member of this group
-	isMember, err := isGroupMember(userID, groupID)
+	isMember, err := s.isGroupMember(userID, groupID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
@@ -265,21 +236,7 @@ func GetEventsForGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get events for this group
-	type Event struct {
-		ID               int     `json:"id"`
-		Title            string  `json:"title"`
-		Description      string  `json:"description"`
-		Datetime         string  `json:"datetime"`
-		CreatedBy        string  `json:"created_by"`
-		CreatorName      *string `json:"creator_name"`
-		GoingCount       int     `json:"going_count"`
-		NotGoingCount    int     `json:"not_going_count"`
-		MightBeLateCount int     `json:"might_be_late_count"`
-		UserResponse     *string `json:"user_response"`
-	}
-
-	timezone := r.URL.Query().Get("timezone")
+	timezone := q.Timezone
 	if timezone == "" {
 		// Default to UTC if no timezone provided
 		timezone = "UTC"
@@ -290,67 +247,32 @@ func GetEventsForGroup(w http.ResponseWriter, r *http.Request) {
 		loc = time.UTC // Fallback to UTC
 	}
 
-	query := `
-        SELECT 
-            e.id, 
-            e.title, 
-            e.description, 
-            e.datetime, 
-            e.created_by,
-            u.first_name || ' ' || u.last_name as creator_name,
-            SUM(CASE WHEN er.response = 'I''ll be there' THEN 1 ELSE 0 END) as going_count,
-            SUM(CASE WHEN er.response = 'Can''t make it' THEN 1 ELSE 0 END) as not_going_count,
-            SUM(CASE WHEN er.response = 'Might be late' THEN 1 ELSE 0 END) as might_be_late_count,
-            (SELECT response FROM event_responsess WHERE event_id = e.id AND user_id = ?) as user_response
-        FROM events e
-        LEFT JOIN users u ON e.created_by = u.id
-        LEFT JOIN event_responsess er ON e.id = er.event_id
-        WHERE e.group_id = ?
-        GROUP BY e.id
-        ORDER BY e.datetime ASC;
-    `
-
-	rows, err := sqlite.DB.Query(query, userID, groupID)
+	rows, err := s.Store.Events.ForGroup(r.Context(), groupID, userID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Failed to fetch events")
 		return
 	}
-	defer rows.Close()
-
-	var events []Event
-	for rows.Next() {
-		var event Event
-		var creatorName sql.NullString
-		var userResponse sql.NullString
-		var dbTime time.Time
-
-		err := rows.Scan(&event.ID, &event.Title, &event.Description, &dbTime, &event.CreatedBy, &creatorName, &event.GoingCount, &event.NotGoingCount, &event.MightBeLateCount, &userResponse)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "Failed to parse event data")
-			return
-		}
 
-		// Convert UTC time from db to user's local time
-		localTime := dbTime.In(loc)
-		event.Datetime = localTime.Format("2006-01-02 15:04")
-
-		if creatorName.Valid {
-			event.CreatorName = &creatorName.String
+	events := []Event{}
+	for _, e := range rows {
+		event := Event{
+			ID:               int(e.ID),
+			Title:            e.Title,
+			Description:      e.Description,
+			Datetime:         e.Datetime.In(loc).Format("2006-01-02 15:04"), // user's local time
+			CreatedBy:        e.CreatedBy,
+			GoingCount:       e.GoingCount,
+			NotGoingCount:    e.NotGoingCount,
+			MightBeLateCount: e.MightBeLateCount,
 		}
-		if userResponse.Valid {
-			event.UserResponse = &userResponse.String
+		if e.CreatorName != "" {
+			event.CreatorName = &e.CreatorName
+		}
+		if e.UserResponse != "" {
+			event.UserResponse = &e.UserResponse
 		}
-
 		events = append(events, event)
 	}
 
-	if err = rows.Err(); err != nil {
-		writeErr(w, http.StatusInternalServerError, "Error reading events")
-		return
-	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":     true,
-		"events": events,
-	})
+	writeJSON(w, http.StatusOK, EventsResponse{OK: true, Events: events})
 }
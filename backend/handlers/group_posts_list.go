@@ -34,31 +34,68 @@ type CommentRow struct {
 	CreatedAt string `json:"created_at"`
 }
 
-func ListGroupPostsHandler(db *sql.DB) http.HandlerFunc {
+type (
+	GroupPostsQuery struct {
+		GroupID int `query:"group_id,required"`
+		Limit   int `query:"limit"` // 1-100, 20 otherwise
+		Offset  int `query:"offset"`
+	}
+	PostCommentsQuery struct {
+		PostID int `query:"post_id,required"`
+		Limit  int `query:"limit"` // 1-100, 20 otherwise
+		Offset int `query:"offset"`
+	}
+	PostCommentForm struct {
+		PostID  int    `form:"post_id,required"`
+		Content string `form:"content,required"`
+	}
+)
+
+type GroupPostsResponse struct {
+	OK    bool       `json:"ok"`
+	Posts []listPost `json:"posts"`
+}
+
+type PostCommentsResponse struct {
+	OK       bool         `json:"ok"`
+	Comments []CommentRow `json:"comments"`
+}
+
+type PostCommentResponse struct {
+	OK        bool  `json:"ok"`
+	NewCount  int   `json:"new_count"`
+	CommentID int64 `json:"comment_id"`
+}
+
+// pageParams clamps limit and offset to a page of at most 100, 20 by default.
+func pageParams(limit, offset int) (int, int) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func (s *Server) ListGroupPostsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
+		userID := currentUserID(r)
 
-		groupIDStr := r.URL.Query().Get("group_id")
-		if groupIDStr == "" {
-			writeErr(w, http.StatusBadRequest, "group_id is required")
-			return
-		}
-		groupID, err := strconv.ParseInt(groupIDStr, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, "invalid group_id")
+		var params GroupPostsQuery
+		if err := decodeQuery(r, &params); err != nil {
+			writeError(w, err)
 			return
 		}
+		groupID := params.GroupID
+		groupIDStr := strconv.Itoa(groupID)
 
 		// must be a member
-		isMember, err := isGroupMember(userID, groupIDStr)
+		isMember, err := s.isGroupMember(userID, groupIDStr)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
@@ -68,18 +105,7 @@ func ListGroupPostsHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		limit := 20
-		offset := 0
-		if v := r.URL.Query().Get("limit"); v != "" {
-			if n, e := strconv.Atoi(v); e == nil && n > 0 && n <= 100 {
-				limit = n
-			}
-		}
-		if v := r.URL.Query().Get("offset"); v != "" {
-			if n, e := strconv.Atoi(v); e == nil && n >= 0 {
-				offset = n
-			}
-		}
+		limit, offset := pageParams(params.Limit, params.Offset)
 		const q = `
 SELECT 
   p.id                             AS post_id,
@@ -107,7 +133,7 @@ LIMIT ? OFFSET ?;
 		}
 		defer rows.Close()
 
-		var out []listPost
+		out := []listPost{}
 		for rows.Next() {
 			var (
 				p            listPost
@@ -132,10 +158,7 @@ LIMIT ? OFFSET ?;
 			return
 		}
 
-		writeJSON(w, http.StatusOK, map[string]any{
-			"ok":    true,
-			"posts": out,
-		})
+		writeJSON(w, http.StatusOK, GroupPostsResponse{OK: true, Posts: out})
 	}
 }
 func ListPostCommentsHandler(db *sql.DB) http.HandlerFunc {
@@ -145,35 +168,13 @@ func ListPostCommentsHandler(db *sql.DB) http.HandlerFunc {
 			writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-
-		postIDStr := r.URL.Query().Get("post_id")
-		if postIDStr == "" {
-			writeErr(w, http.StatusBadRequest, "post_id is required")
-			return
-		}
-		postID, err := strconv.ParseInt(postIDStr, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, "invalid post_id")
+		var params PostCommentsQuery
+		if err := decodeQuery(r, &params); err != nil {
+			writeError(w, err)
 			return
 		}
-
-		limit := 20
-		offset := 0
-		if v := r.URL.Query().Get("limit"); v != "" {
-			if n, e := strconv.Atoi(v); e == nil && n > 0 && n <= 100 {
-				limit = n
-			}
-		}
-		if v := r.URL.Query().Get("offset"); v != "" {
-			if n, e := strconv.Atoi(v); e == nil && n >= 0 {
-				offset = n
-			}
-		}
+		postID := params.PostID
+		limit, offset := pageParams(params.Limit, params.Offset)
 
 		const q = `
 SELECT 
@@ -200,7 +201,7 @@ LIMIT ? OFFSET ?;
 		}
 		defer rows.Close()
 
-		var out []CommentRow
+		out := []CommentRow{}
 		for rows.Next() {
 			var cr CommentRow
 			var created time.Time
@@ -219,10 +220,7 @@ LIMIT ? OFFSET ?;
 			writeErr(w, http.StatusInternalServerError, "rows failed")
 			return
 		}
-		if out == nil {
-			out = []CommentRow{}
-		}
-		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "comments": out})
+		writeJSON(w, http.StatusOK, PostCommentsResponse{OK: true, Comments: out})
 	}
 }
 
@@ -233,32 +231,15 @@ func CreatePostCommentHandler(db *sql.DB) http.HandlerFunc {
 			writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-
-		if err := r.ParseForm(); err != nil {
-			writeErr(w, http.StatusBadRequest, "invalid form")
-			return
-		}
+		userID := currentUserID(r)
 
-		postIDStr := r.FormValue("post_id")
-		content := strings.TrimSpace(r.FormValue("content"))
-		if postIDStr == "" {
-			writeErr(w, http.StatusBadRequest, "post_id is required")
-			return
-		}
-		postID, err := strconv.ParseInt(postIDStr, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, "invalid post_id")
-			return
-		}
-		if content == "" {
-			writeErr(w, http.StatusBadRequest, "content required")
+		var form PostCommentForm
+		if err := decodeForm(w, r, &form); err != nil {
+			writeError(w, err)
 			return
 		}
+		postID := form.PostID
+		content := strings.TrimSpace(form.Content)
 
 		const ins = `INSERT INTO post_Comments (post_id, user_id, content) VALUES (?,?,?);`
 		res, err := db.Exec(ins, postID, userID, content)
@@ -275,10 +256,6 @@ func CreatePostCommentHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		writeJSON(w, http.StatusOK, map[string]any{
-			"ok":         true,
-			"new_count":  newCount,
-			"comment_id": commentID,
-		})
+		writeJSON(w, http.StatusOK, PostCommentResponse{OK: true, NewCount: newCount, CommentID: commentID})
 	}
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/pkg/store"
+)
+
+type GroupTypingIn struct {
+	GroupID string `json:"group_id"`
+}
+
+type GroupTypingData struct {
+	From    string `json:"from"`
+	GroupID string `json:"group_id"`
+	TS      string `json:"ts"`
+}
+
+// handleGroupTyping relays a typing hint to the other online members of the group.
+func (s *Server) handleGroupTyping(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in GroupTypingIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+	if in.GroupID == "" {
+		return nil, nil
+	}
+	isMember, err := s.isGroupMember(userID, in.GroupID)
+	if err != nil || !isMember {
+		return nil, nil
+	}
+	// throttled per group; extra hints are dropped quietly
+	if ok, _ := s.Limits.Allow("group_typing", userID+"|"+in.GroupID); !ok {
+		return nil, nil
+	}
+
+	members, err := s.getGroupMembers(in.GroupID)
+	if err != nil {
+		return nil, nil
+	}
+	frame := newFrame("group_typing", GroupTypingData{
+		From:    userID,
+		GroupID: in.GroupID,
+		TS:      time.Now().Format(time.RFC3339),
+	})
+	for _, memberID := range members {
+		if memberID != userID && s.Hub.isOnline(memberID) {
+			s.Hub.SendToUser(memberID, frame)
+		}
+	}
+	return nil, nil
+}
+
+// markGroupRead moves the user's read marker forward to messageID, or to the
+// newest message in the group when messageID is empty. It never moves backwards.
+func (s *Server) markGroupRead(ctx context.Context, userID, groupID, messageID string) error {
+	err := s.Store.Messages.MarkGroupRead(ctx, groupID, userID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errBadReadMarker
+	}
+	return err
+}
+
+// errBadReadMarker rejects a message_id that isn't a message of the group;
+// a stray value stored as the marker would hide every later message.
+var errBadReadMarker = errors.New("message_id must be a message of this group")
+
+// groupUnreadCount counts messages from other members after the user's read marker.
+func (s *Server) groupUnreadCount(ctx context.Context, userID, groupID string) (int, error) {
+	return s.Store.Messages.GroupUnread(ctx, groupID, userID)
+}
+
+type GroupUnreadData struct {
+	GroupID string `json:"group_id"`
+	Count   int    `json:"count"`
+}
+
+// pushGroupUnread tells all of the user's tabs the new unread count for one group.
+func (s *Server) pushGroupUnread(userID, groupID string) (GroupUnreadData, error) {
+	n, err := s.groupUnreadCount(context.Background(), userID, groupID)
+	if err != nil {
+		return GroupUnreadData{}, err
+	}
+	data := GroupUnreadData{GroupID: groupID, Count: n}
+	s.Hub.SendToUser(userID, newFrame("group_unread", data))
+	return data, nil
+}
+
+type GroupReadIn struct {
+	GroupID   string `json:"group_id"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// handleGroupRead is the WS twin of MarkGroupMessagesRead.
+func (s *Server) handleGroupRead(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in GroupReadIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+	isMember, err := s.isGroupMember(userID, in.GroupID)
+	if err != nil {
+		return nil, frameErr("db_error", "failed to check group membership")
+	}
+	if !isMember {
+		return nil, frameErr("not_member", "You are not a member of this group")
+	}
+	if err := s.markGroupRead(context.Background(), userID, in.GroupID, in.MessageID); errors.Is(err, errBadReadMarker) {
+		return nil, frameErr("bad_group_read", err.Error())
+	} else if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+	out, err := s.pushGroupUnread(userID, in.GroupID)
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+	return out, nil
+}
+
+// GroupMessagesReadForm moves the read marker to message_id, or to the
+// newest message when it is left out.
+type GroupMessagesReadForm struct {
+	GroupID   string `form:"group_id,required"`
+	MessageID string `form:"message_id"`
+}
+
+// UnreadResponse is what is still unread after marking something read.
+type UnreadResponse struct {
+	OK     bool `json:"ok"`
+	Unread int  `json:"unread"`
+}
+
+// POST /api/group/messages/read  (group_id, optional message_id)
+func (s *Server) MarkGroupMessagesRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	var form GroupMessagesReadForm
+	if err := decodeForm(w, r, &form); err != nil {
+		writeError(w, err)
+		return
+	}
+	groupID := form.GroupID
+	messageID := form.MessageID
+
+	isMember, err := s.isGroupMember(userID, groupID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !isMember {
+		writeErr(w, http.StatusForbidden, "Not a member of this group")
+		return
+	}
+
+	if err := s.markGroupRead(r.Context(), userID, groupID, messageID); errors.Is(err, errBadReadMarker) {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to update read state")
+		return
+	}
+	n, err := s.groupUnreadCount(r.Context(), userID, groupID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	PushToUser(userID, newFrame("group_unread", GroupUnreadData{GroupID: groupID, Count: n}))
+	writeJSON(w, http.StatusOK, UnreadResponse{OK: true, Unread: n})
+}
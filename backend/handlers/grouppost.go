@@ -2,13 +2,8 @@ package handlers
 
 import (
 	"database/sql"
-	"fmt"
-	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
-	"time"
 )
 
 type groupPost struct {
@@ -27,30 +22,34 @@ type groupPost struct {
 	CreatedAt    string `json:"created_at,omitempty"`
 }
 
-func CreateGroupPostHandler(db *sql.DB) http.HandlerFunc {
+// GroupPostForm is a post in a group, with an optional image.
+type GroupPostForm struct {
+	GroupID string                `form:"group_id,required"`
+	Content string                `form:"content,required"`
+	Image   *multipart.FileHeader `form:"image"`
+}
+
+func (s *Server) CreateGroupPostHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-		if err := r.ParseMultipartForm(25 << 20); err != nil { // 25MB
-			writeErr(w, http.StatusBadRequest, "Error parsing form")
-			return
-		}
-		content := r.FormValue("content")
-		groupID := r.FormValue("group_id")
-		if content == "" || groupID == "" {
-			writeErr(w, http.StatusBadRequest, "Content and Group ID are required")
+		userID := currentUserID(r)
+		var form GroupPostForm
+		if err := decodeForm(w, r, &form); err != nil {
+			writeError(w, err)
 			return
 		}
+		content := form.Content
+		groupID := form.GroupID
 		var imagePath string
-		file, handler, err := r.FormFile("image")
-		if err == nil {
+		if handler := form.Image; handler != nil {
+			file, err := handler.Open()
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "Failed to read image")
+				return
+			}
 			defer file.Close()
 
 			// Validate image
@@ -59,24 +58,9 @@ func CreateGroupPostHandler(db *sql.DB) http.HandlerFunc {
 				return
 			}
 
-			uploadDir := filepath.Join(".", "uploads")
-			// Ensure uploads dir exists
-			if mkErr := os.MkdirAll(uploadDir, os.ModePerm); mkErr != nil {
-				writeErr(w, http.StatusInternalServerError, "Failed to prepare upload folder")
-				return
-			}
-
-			// Simple unique filename to avoid collisions
-			filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), path.Base(handler.Filename))
-			filepath := filepath.Join(uploadDir, filename)
-			dst, createErr := os.Create(filepath)
-			if createErr != nil {
-				writeErr(w, http.StatusInternalServerError, "Failed to save image")
-				return
-			}
-			defer dst.Close()
-
-			if _, copyErr := io.Copy(dst, file); copyErr != nil {
+			filename, err := saveUpload(file, handler)
+			if err != nil {
+				reqLog(r).Error("save upload", "err", err)
 				writeErr(w, http.StatusInternalServerError, "Failed to save image")
 				return
 			}
@@ -84,7 +68,7 @@ func CreateGroupPostHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// must be a member
-		isMember, err := isGroupMember(userID, groupID)
+		isMember, err := s.isGroupMember(userID, groupID)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
@@ -110,10 +94,6 @@ func CreateGroupPostHandler(db *sql.DB) http.HandlerFunc {
 			writeErr(w, http.StatusInternalServerError, "Failed to get last insert ID")
 			return
 		}
-		writeJSON(w, http.StatusCreated, map[string]any{
-			"ok":      true,
-			"message": "Post created successfully",
-			"id":      postID,
-		})
+		writeJSON(w, http.StatusCreated, CreatedResponse{OK: true, Message: "Post created successfully", ID: postID})
 	}
 }
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadyMissingDir(t *testing.T) {
+	s := useSQLite(t)
+	missing := filepath.Join(t.TempDir(), "uploads")
+	h := &Health{
+		MigrationsPath: "../database/migrations/sqlite",
+		UploadsDir:     missing,
+		AttachmentsDir: t.TempDir(),
+		Server:         s,
+	}
+
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/healthz/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", rec.Code)
+	}
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Checks["uploads"].Status; got != "fail" {
+		t.Errorf("uploads check %q, want fail", got)
+	}
+	for _, name := range []string{"database", "migrations", "attachments"} {
+		if got := report.Checks[name].Status; got != "ok" {
+			t.Errorf("%s check %q, want ok", name, got)
+		}
+	}
+	if strings.Contains(rec.Body.String(), missing) {
+		t.Errorf("the body gives the failure away: %s", rec.Body)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("the probe created %s", missing)
+	}
+}
@@ -1,93 +1,79 @@
 package handlers
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"time"
 )
 
 type historyRow struct {
-	ID   string `json:"id"`
-	From string `json:"from"`
-	To   string `json:"to"`
-	Text string `json:"text"`
-	TS   string `json:"ts"`
+	ID          string            `json:"id"`
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Text        string            `json:"text"`
+	TS          string            `json:"ts"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	ReplyTo     *ReplySnippet     `json:"reply_to,omitempty"`
+	Reactions   []ReactionSummary `json:"reactions,omitempty"`
 }
 
-func HistoryHandler(db *sql.DB, auth func(*http.Request) (string, error)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		me, err := auth(r)
-		if err != nil || me == "" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
+// HistoryQuery names the other side of the conversation.
+type HistoryQuery struct {
+	PeerID string `query:"peer_id,required"`
+}
 
-		peer := r.URL.Query().Get("peer_id")
-		if peer == "" {
-			http.Error(w, "peer_id required", http.StatusBadRequest)
-			return
-		}
-		if peer == me {
-			// disallow self-history. 
-			http.Error(w, "cannot load self history", http.StatusBadRequest)
-			return
-		}
+func (s *Server) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	me := currentUserID(r)
 
-		//  Enforce DM policy BEFORE querying messages.
-		// allowed, err := canDM(me, peer) // allows if either direction follow exists
-		// if err != nil {
-		// 	http.Error(w, "relationship check failed", http.StatusInternalServerError)
-		// 	return
-		// }
-		// if !allowed {
-		// 	http.Error(w, "dm locked by relationship policy", http.StatusForbidden)
-		// 	return
-		// }
+	var q HistoryQuery
+	if err := decodeQuery(r, &q); err != nil {
+		writeError(w, err)
+		return
+	}
+	peer := q.PeerID
+	if peer == me {
+		// disallow self-history.
+		writeErr(w, http.StatusBadRequest, "cannot load self history")
+		return
+	}
 
-		rows, err := db.Query(`
-			SELECT id, sender_id, receiver_id, content, sent_at
-			FROM messages
-			WHERE (sender_id = ? AND receiver_id = ?)
-			   OR (sender_id = ? AND receiver_id = ?)
-			ORDER BY sent_at ASC
-			LIMIT 200
-		`, me, peer, peer, me)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
+	//  Enforce DM policy BEFORE querying messages.
+	// allowed, err := canDM(me, peer) // allows if either direction follow exists
+	// if err != nil {
+	// 	http.Error(w, "relationship check failed", http.StatusInternalServerError)
+	// 	return
+	// }
+	// if !allowed {
+	// 	http.Error(w, "dm locked by relationship policy", http.StatusForbidden)
+	// 	return
+	// }
 
-		out := make([]historyRow, 0, 64)
-		for rows.Next() {
-			var (
-				id             int64
-				from, to, text string
-				ts             time.Time
-			)
-			if err := rows.Scan(&id, &from, &to, &text, &ts); err != nil {
-				// If a single row fails to scan, bail safely.
-				http.Error(w, "decode error", http.StatusInternalServerError)
-				return
-			}
-			out = append(out, historyRow{
-				ID:   fmt.Sprint(id),
-				From: from,
-				To:   to,
-				Text: text,
-				TS:   ts.Format(time.RFC3339),
-			})
-		}
+	msgs, err := s.Store.Messages.DMHistory(r.Context(), me, peer, 200)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
 
-		// rows.Err() check in case of driver-level errors while iterating.
-		if err := rows.Err(); err != nil {
-			http.Error(w, "rows error", http.StatusInternalServerError)
-			return
+	extras, err := s.messageExtrasFor(r.Context(), "dm", msgs)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "extras error")
+		return
+	}
+	out := make([]historyRow, 0, len(msgs))
+	for _, m := range msgs {
+		row := historyRow{
+			ID:          m.ID,
+			From:        m.SenderID,
+			To:          m.ReceiverID,
+			Text:        m.Content,
+			TS:          m.SentAt.Format(time.RFC3339),
+			Attachments: extras.attachments[m.ID],
+			Reactions:   extras.reactions[m.ID],
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out) 
+		if m.ReplyTo != "" {
+			row.ReplyTo = extras.replies[m.ReplyTo]
+		}
+		out = append(out, row)
 	}
+
+	writeJSON(w, http.StatusOK, out)
 }
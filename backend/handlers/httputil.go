@@ -2,7 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxJSONBody = 1 << 20  // JSON request bodies
+	maxFormBody = 30 << 20 // multipart bodies; uploads are checked on their own as well
 )
 
 func writeJSON(w http.ResponseWriter, status int, payload any) {
@@ -11,6 +24,165 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+// MessageResponse is the body of writes that only confirm what they did.
+type MessageResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// CreatedResponse is the body of writes that create something with a numeric id.
+type CreatedResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+	ID      int64  `json:"id"`
+}
+
 func writeErr(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, map[string]any{"ok": false, "message": msg})
+	writeError(w, apiErr(status, msg))
+}
+
+// decodeJSON reads a single JSON object from the body into dst. The body must
+// be sent as application/json, fit in maxJSONBody and only use fields dst
+// knows about. Failures come back as *APIError, ready for writeError.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		return apiErr(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return jsonBodyErr(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &APIError{Status: http.StatusBadRequest, Code: CodeBadJSON, Message: "Body must hold a single JSON object"}
+	}
+	return nil
+}
+
+func jsonBodyErr(err error) *APIError {
+	var (
+		tooBig   *http.MaxBytesError
+		syntax   *json.SyntaxError
+		typeErr  *json.UnmarshalTypeError
+		badField = "json: unknown field "
+	)
+	e := &APIError{Status: http.StatusBadRequest, Code: CodeBadJSON}
+	switch {
+	case errors.As(err, &tooBig):
+		return apiErr(http.StatusRequestEntityTooLarge, "Request body is too large")
+	case errors.Is(err, io.EOF):
+		e.Message = "Request body is empty"
+	case errors.As(err, &syntax), errors.Is(err, io.ErrUnexpectedEOF):
+		e.Message = "Request body is not valid JSON"
+	case errors.As(err, &typeErr):
+		e.Message = "Field " + typeErr.Field + " has the wrong type"
+		e.Fields = []FieldError{{Field: typeErr.Field, Code: "invalid", Message: "expected " + typeErr.Type.String()}}
+	case strings.HasPrefix(err.Error(), badField):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), badField), `"`)
+		e.Message = "Unknown field " + field
+		e.Fields = []FieldError{{Field: field, Code: "unknown", Message: "unknown field"}}
+	default:
+		e.Message = "Request body is not valid JSON"
+	}
+	return e
+}
+
+// parseForm parses an urlencoded or multipart body of at most maxFormBody,
+// plus the query string.
+func parseForm(w http.ResponseWriter, r *http.Request) error {
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	}
+	var err error
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		err = r.ParseMultipartForm(10 << 20) // larger parts spill to temp files
+	} else {
+		err = r.ParseForm()
+	}
+	if err == nil {
+		return nil
+	}
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		return apiErr(http.StatusRequestEntityTooLarge, "Request body is too large")
+	}
+	return apiErr(http.StatusBadRequest, "Malformed form data")
+}
+
+// decodeForm parses the form body like parseForm and fills dst, a pointer to a
+// struct whose fields carry `form:"name[,required]"` tags. The same tags
+// describe the body in the OpenAPI document.
+func decodeForm(w http.ResponseWriter, r *http.Request, dst any) error {
+	if err := parseForm(w, r); err != nil {
+		return err
+	}
+	v := &validation{}
+	v.form(r, dst)
+	return v.err()
+}
+
+// decodeQuery fills dst from the query string by its `query:"name[,required]"` tags.
+func decodeQuery(r *http.Request, dst any) error {
+	v := &validation{}
+	v.values("query", r.URL.Query(), nil, dst)
+	return v.err()
+}
+
+// form is decodeForm for a form parseForm already read, for handlers that
+// go on to check more than the tags say.
+func (v *validation) form(r *http.Request, dst any) {
+	var files map[string][]*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File
+	}
+	v.values("form", r.Form, files, dst)
+}
+
+// values sets the string, int, []string and *multipart.FileHeader fields of
+// dst tagged with key. Required values that are missing or blank and numbers
+// that don't parse are added to v.
+func (v *validation) values(key string, vals url.Values, files map[string][]*multipart.FileHeader, dst any) {
+	rv := reflect.ValueOf(dst).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		name, opts, _ := strings.Cut(rv.Type().Field(i).Tag.Get(key), ",")
+		if name == "" {
+			continue
+		}
+		required := opts == "required"
+		f := rv.Field(i)
+		switch {
+		case f.Type() == fileType:
+			if fhs := files[name]; len(fhs) > 0 {
+				f.Set(reflect.ValueOf(fhs[0]))
+			} else if required {
+				v.add(name, "required", name+" is required")
+			}
+		case f.Kind() == reflect.Slice:
+			f.Set(reflect.ValueOf(vals[name]))
+			if required && len(vals[name]) == 0 {
+				v.add(name, "required", name+" is required")
+			}
+		case f.Kind() == reflect.Int:
+			s := strings.TrimSpace(vals.Get(name))
+			if s == "" {
+				if required {
+					v.add(name, "required", name+" is required")
+				}
+				continue
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				v.add(name, "invalid", name+" must be a whole number")
+				continue
+			}
+			f.SetInt(int64(n))
+		default:
+			s := vals.Get(name)
+			if required && strings.TrimSpace(s) == "" {
+				v.add(name, "required", name+" is required")
+			}
+			f.SetString(s)
+		}
+	}
 }
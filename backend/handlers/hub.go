@@ -1,113 +1,467 @@
 package handlers
 
-import "sync"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/pkg/broker"
+
+	"github.com/google/uuid"
+)
+
+const (
+	hubTopic = "ws.hub"
+	// every instance republishes its online users this often; an instance
+	// not heard from for three intervals is considered gone
+	presenceHeartbeat = 10 * time.Second
+	presenceExpiry    = 3 * presenceHeartbeat
+)
+
+// Presence statuses, from most to least available.
+const (
+	StatusOnline  = "online"
+	StatusIdle    = "idle"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+var statusRank = map[string]int{StatusOnline: 3, StatusIdle: 2, StatusAway: 1}
+
+// betterStatus returns whichever of a and b is more available.
+func betterStatus(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+	return a
+}
 
 type Client interface {
-	SendJSON(v any) error 
+	SendJSON(v any) error
 	Close() error
 	UserID() string
 }
-type Hub struct{
-	mu sync.RWMutex
-	clients  map[string]map[Client]struct{} 
+
+// PresenceScope decides who gets to see a user's presence.
+type PresenceScope interface {
+	// PresenceAudience lists the users allowed to see userID's presence;
+	// empty when userID hides it.
+	PresenceAudience(userID string) []string
+	// LastSeen is userID's last activity as RFC3339, "" if unknown or hidden.
+	LastSeen(userID string) string
+}
+
+// Hub tracks this instance's connections and, through the broker, the users
+// connected to every other instance.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[string]map[Client]string // user id -> connection -> status
+
+	instanceID string
+	broker     broker.Broker
+	remote     map[string]*remoteInstance // instance id -> what it last told us
+	scope      PresenceScope
+
+	stop chan struct{}
+	once sync.Once
+
+	lastBeat atomic.Pointer[heartbeatResult] // nil until the first tick
+}
+
+// heartbeatResult is the outcome of the heartbeat loop's last tick.
+type heartbeatResult struct {
+	at  time.Time
+	err error // publishing to the broker failed
+}
+
+type remoteInstance struct {
+	status   map[string]string // user id -> status on that instance
+	lastSeen time.Time
+}
+
+// hubMessage is what instances exchange on hubTopic.
+type hubMessage struct {
+	Origin   string            `json:"origin"`
+	Kind     string            `json:"kind"` // "user", "all", "presence", "heartbeat" or "sync"
+	UserID   string            `json:"user_id,omitempty"`
+	Status   string            `json:"status,omitempty"`   // presence: the user's status on origin
+	Statuses map[string]string `json:"statuses,omitempty"` // heartbeat: everyone online on origin
+	Frame    json.RawMessage   `json:"frame,omitempty"`
 }
+
+// NewHub returns a single-instance hub backed by an in-memory broker.
 func NewHub() *Hub {
-	return &Hub{
-		clients: make(map[string]map[Client]struct{}),
+	h, _ := NewHubWithBroker(broker.NewMemory())
+	return h
+}
+
+// NewHubWithBroker returns a hub that shares delivery and presence with every
+// other instance subscribed to the same broker.
+func NewHubWithBroker(b broker.Broker) (*Hub, error) {
+	h := &Hub{
+		clients:    make(map[string]map[Client]string),
+		instanceID: uuid.NewString(),
+		broker:     b,
+		remote:     make(map[string]*remoteInstance),
+		stop:       make(chan struct{}),
+	}
+	if err := b.Subscribe(hubTopic, h.onMessage); err != nil {
+		return nil, fmt.Errorf("hub: subscribe: %w", err)
+	}
+	go h.heartbeat()
+	return h, nil
+}
+
+// SetPresenceScope installs the audience rules. Without one, presence
+// changes are not sent to anybody.
+func (h *Hub) SetPresenceScope(s PresenceScope) {
+	h.mu.Lock()
+	h.scope = s
+	h.mu.Unlock()
+}
+
+// Close stops the heartbeat and closes the broker.
+func (h *Hub) Close() error {
+	h.once.Do(func() { close(h.stop) })
+	return h.broker.Close()
+}
+
+func (h *Hub) publish(m hubMessage) error {
+	m.Origin = h.instanceID
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := h.broker.Publish(hubTopic, b); err != nil {
+		slog.Error("hub: publish", "kind", m.Kind, "err", err)
+		return err
+	}
+	return nil
+}
+
+// localStatusLocked is the user's best status over this instance's connections. Caller holds h.mu.
+func (h *Hub) localStatusLocked(userID string) string {
+	status := StatusOffline
+	for _, s := range h.clients[userID] {
+		status = betterStatus(status, s)
+	}
+	return status
+}
+
+// statusLocked is the user's best status over every instance. Caller holds h.mu.
+func (h *Hub) statusLocked(userID string) string {
+	status := h.localStatusLocked(userID)
+	for _, inst := range h.remote {
+		if s, ok := inst.status[userID]; ok {
+			status = betterStatus(status, s)
+		}
 	}
+	return status
+}
+
+// Status returns the user's presence across all instances, ignoring any privacy setting.
+func (h *Hub) Status(userID string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.statusLocked(userID)
 }
-func (h *Hub) isOnline(userID string)bool{
+
+func (h *Hub) isOnline(userID string) bool {
+	return h.Status(userID) != StatusOffline
+}
+
+// localConns snapshots this instance's connections, all of them when userID is empty.
+func (h *Hub) localConns(userID string) []Client {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return len(h.clients[userID]) > 0
+	var conns []Client
+	if userID != "" {
+		for c := range h.clients[userID] {
+			conns = append(conns, c)
+		}
+		return conns
+	}
+	for _, set := range h.clients {
+		for c := range set {
+			conns = append(conns, c)
+		}
+	}
+	return conns
 }
-func (h *Hub) OnlineUsers() []string {
+
+// Counts returns the number of connections on this instance and of users
+// online on any instance.
+func (h *Hub) Counts() (conns, users int) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	out := make([]string, 0, len(h.clients))
+	online := make(map[string]bool, len(h.clients))
 	for uid, set := range h.clients {
-		if len(set) > 0 {
-			out = append(out, uid)
+		conns += len(set)
+		online[uid] = true
+	}
+	for _, inst := range h.remote {
+		for uid, status := range inst.status {
+			if status != StatusOffline {
+				online[uid] = true
+			}
 		}
 	}
-	return out
+	return conns, len(online)
 }
-func (h *Hub) broadcastPresence(userID string, online bool) {
-    
-    h.mu.RLock()
-    var conns []Client
-    for _, set := range h.clients {
-        for c := range set {
-            conns = append(conns, c)
-        }
-    }
-    h.mu.RUnlock()
 
-    payload := map[string]any{
-        "type": "presence",
-        "data": map[string]any{"userId": userID, "online": online},
-    }
+// broadcastPresence tells the user's audience connected to this instance about a status change.
+func (h *Hub) broadcastPresence(userID, status string) {
+	h.mu.RLock()
+	scope := h.scope
+	h.mu.RUnlock()
+	if scope == nil {
+		return
+	}
+	data := PresenceData{UserID: userID, Online: status != StatusOffline, Status: status}
+	if status == StatusOffline {
+		data.LastSeenAt = scope.LastSeen(userID)
+	}
+	h.sendPresence(data, scope.PresenceAudience(userID))
+}
 
-    for _, c := range conns {
-        _ = c.SendJSON(payload)
-    }
+// sendPresence delivers one presence frame to the given users' local connections.
+func (h *Hub) sendPresence(data PresenceData, audience []string) {
+	frame := newFrame("presence", data)
+	for _, uid := range audience {
+		for _, c := range h.localConns(uid) {
+			_ = c.SendJSON(frame)
+		}
+	}
 }
 
-func (h *Hub) Add(c Client) {
+// updateLocal applies fn to the connection map and then announces whatever
+// changed: this instance's status to the other instances, the global status
+// to the user's audience.
+func (h *Hub) updateLocal(userID string, fn func()) {
 	h.mu.Lock()
-	uid := c.UserID()
-	if h.clients[uid] == nil {
-		h.clients[uid] = make(map[Client]struct{})
-	}
-	first := len(h.clients[uid]) == 0 // first connection for this user?
-	h.clients[uid][c] = struct{}{}
+	globalBefore, localBefore := h.statusLocked(userID), h.localStatusLocked(userID)
+	fn()
+	globalAfter, localAfter := h.statusLocked(userID), h.localStatusLocked(userID)
 	h.mu.Unlock()
 
-	if first {
-		h.broadcastPresence(uid, true)
+	if localAfter != localBefore {
+		h.publish(hubMessage{Kind: "presence", UserID: userID, Status: localAfter})
 	}
+	if globalAfter != globalBefore {
+		h.broadcastPresence(userID, globalAfter)
+	}
+}
+
+func (h *Hub) Add(c Client) {
+	uid := c.UserID()
+	h.updateLocal(uid, func() {
+		if h.clients[uid] == nil {
+			h.clients[uid] = make(map[Client]string)
+		}
+		h.clients[uid][c] = StatusOnline
+	})
 }
 
 func (h *Hub) Remove(c Client) {
-	h.mu.Lock()
 	uid := c.UserID()
-	last := false
-	if set, ok := h.clients[uid]; ok {
-		delete(set, c)
-		if len(set) == 0 {
-			delete(h.clients, uid)
-			last = true 
+	h.updateLocal(uid, func() {
+		if set, ok := h.clients[uid]; ok {
+			delete(set, c)
+			if len(set) == 0 {
+				delete(h.clients, uid)
+			}
 		}
-	}
-	h.mu.Unlock()
+	})
+}
 
-	if last {
-		h.broadcastPresence(uid, false)
-	}
+// SetStatus records a connection's activity state (online, idle or away).
+func (h *Hub) SetStatus(c Client, status string) {
+	uid := c.UserID()
+	h.updateLocal(uid, func() {
+		if _, ok := h.clients[uid][c]; ok {
+			h.clients[uid][c] = status
+		}
+	})
 }
+
+// SendToUser delivers payload to every connection of the user on every instance.
 func (h *Hub) SendToUser(userID string, payload any) {
+	// send outside the lock; SendJSON only queues, but Add/Remove shouldn't wait on it either
+	for _, c := range h.localConns(userID) {
+		_ = c.SendJSON(payload)
+	}
+
 	h.mu.RLock()
-	set, ok := h.clients[userID]
-	if !ok || len(set) == 0 {
-		h.mu.RUnlock()
+	elsewhere := false
+	for _, inst := range h.remote {
+		if _, ok := inst.status[userID]; ok {
+			elsewhere = true
+			break
+		}
+	}
+	h.mu.RUnlock()
+	if !elsewhere {
 		return
 	}
-	for c := range set {
+	if frame, err := json.Marshal(payload); err == nil {
+		h.publish(hubMessage{Kind: "user", UserID: userID, Frame: frame})
+	}
+}
+
+// BroadcastAll delivers payload to every connection on every instance.
+func (h *Hub) BroadcastAll(payload any) {
+	for _, c := range h.localConns("") {
 		_ = c.SendJSON(payload)
 	}
-	h.mu.RUnlock()
+	if frame, err := json.Marshal(payload); err == nil {
+		h.publish(hubMessage{Kind: "all", Frame: frame})
+	}
+}
+
+// onMessage applies a message from another instance; our own are ignored
+// because they were already delivered locally.
+func (h *Hub) onMessage(b []byte) {
+	var m hubMessage
+	if err := json.Unmarshal(b, &m); err != nil || m.Origin == h.instanceID {
+		return
+	}
 
+	switch m.Kind {
+	case "user":
+		for _, c := range h.localConns(m.UserID) {
+			_ = c.SendJSON(m.Frame)
+		}
+	case "all":
+		for _, c := range h.localConns("") {
+			_ = c.SendJSON(m.Frame)
+		}
+	case "presence":
+		h.updateRemote(m.Origin, map[string]string{m.UserID: m.Status}, false)
+	case "sync":
+		// a new instance wants to know who is online right away
+		h.publishHeartbeat()
+	case "heartbeat":
+		h.updateRemote(m.Origin, m.Statuses, true)
+	}
 }
-func (h *Hub) BroadcastAll(payload any) {
+
+// updateRemote merges statuses reported by another instance (offline entries
+// remove the user). With replace, the report is that instance's complete list.
+func (h *Hub) updateRemote(origin string, statuses map[string]string, replace bool) {
+	h.mu.Lock()
+	inst, ok := h.remote[origin]
+	if !ok {
+		inst = &remoteInstance{status: make(map[string]string)}
+		h.remote[origin] = inst
+	}
+	inst.lastSeen = time.Now()
+
+	touched := make(map[string]string) // user id -> global status before
+	for uid := range statuses {
+		touched[uid] = h.statusLocked(uid)
+	}
+	if replace {
+		for uid := range inst.status {
+			if _, ok := touched[uid]; !ok {
+				touched[uid] = h.statusLocked(uid)
+			}
+		}
+		inst.status = make(map[string]string, len(statuses))
+	}
+	for uid, s := range statuses {
+		if s == StatusOffline || s == "" {
+			delete(inst.status, uid)
+		} else {
+			inst.status[uid] = s
+		}
+	}
+
+	changed := make(map[string]string)
+	for uid, before := range touched {
+		if after := h.statusLocked(uid); after != before {
+			changed[uid] = after
+		}
+	}
+	h.mu.Unlock()
+
+	for uid, status := range changed {
+		h.broadcastPresence(uid, status)
+	}
+}
+
+// heartbeat republishes our online users so late joiners learn them and
+// drops instances that stopped talking (crashed without saying goodbye).
+func (h *Hub) heartbeat() {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+	h.beat(h.publishHeartbeat())
+	h.publish(hubMessage{Kind: "sync"})
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.beat(h.publishHeartbeat())
+			h.expireInstances()
+		}
+	}
+}
+
+func (h *Hub) beat(err error) {
+	h.lastBeat.Store(&heartbeatResult{at: time.Now(), err: err})
+}
+
+// CheckHeartbeat reports whether the heartbeat loop is running and could
+// reach the broker on its last tick.
+func (h *Hub) CheckHeartbeat() error {
+	last := h.lastBeat.Load()
+	switch {
+	case last == nil:
+		return errors.New("heartbeat not started")
+	case time.Since(last.at) > 2*presenceHeartbeat:
+		return fmt.Errorf("last heartbeat %s ago", time.Since(last.at).Round(time.Second))
+	case last.err != nil:
+		return fmt.Errorf("broker: %w", last.err)
+	}
+	return nil
+}
+
+func (h *Hub) publishHeartbeat() error {
 	h.mu.RLock()
-	var conns []Client
-	for _, set := range h.clients {
-		for c := range set {
-			conns = append(conns, c)
+	statuses := make(map[string]string, len(h.clients))
+	for uid := range h.clients {
+		if s := h.localStatusLocked(uid); s != StatusOffline {
+			statuses[uid] = s
 		}
 	}
 	h.mu.RUnlock()
-	for _, c := range conns {
-		_ = c.SendJSON(payload)
+	return h.publish(hubMessage{Kind: "heartbeat", Statuses: statuses})
+}
+
+func (h *Hub) expireInstances() {
+	h.mu.Lock()
+	changed := make(map[string]string)
+	for id, inst := range h.remote {
+		if time.Since(inst.lastSeen) < presenceExpiry {
+			continue
+		}
+		before := make(map[string]string, len(inst.status))
+		for uid := range inst.status {
+			before[uid] = h.statusLocked(uid)
+		}
+		delete(h.remote, id)
+		for uid, b := range before {
+			if after := h.statusLocked(uid); after != b {
+				changed[uid] = after
+			}
+		}
+	}
+	h.mu.Unlock()
+	for uid, status := range changed {
+		h.broadcastPresence(uid, status)
 	}
-}
\ No newline at end of file
+}
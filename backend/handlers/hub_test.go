@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/pkg/broker"
+)
+
+// recClient is a Client that keeps every frame sent to it.
+type recClient struct {
+	uid    string
+	mu     sync.Mutex
+	frames []Frame
+}
+
+func (c *recClient) UserID() string { return c.uid }
+func (c *recClient) Close() error   { return nil }
+
+func (c *recClient) SendJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var f Frame
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.frames = append(c.frames, f)
+	c.mu.Unlock()
+	return nil
+}
+
+// got reports whether a frame of type typ with data equal to want arrived.
+func (c *recClient) got(typ string, want any) bool {
+	// compare as decoded JSON, whose object keys marshal sorted like f.Data's
+	var w any
+	wb, _ := json.Marshal(want)
+	_ = json.Unmarshal(wb, &w)
+	wb, _ = json.Marshal(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range c.frames {
+		if b, _ := json.Marshal(f.Data); f.Type == typ && string(b) == string(wb) {
+			return true
+		}
+	}
+	return false
+}
+
+// mutualScope lets every user see everyone's presence.
+type mutualScope []string
+
+func (s mutualScope) PresenceAudience(string) []string { return s }
+func (s mutualScope) LastSeen(string) string           { return "" }
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// linked waits until a message broadcast from one hub reaches a client of the
+// other, i.e. both are subscribed on the broker.
+func linked(t *testing.T, from *Hub, to *recClient) {
+	t.Helper()
+	n := 0
+	eventually(t, "hubs to see each other", func() bool {
+		n++
+		from.BroadcastAll(newFrame("probe", strconv.Itoa(n)))
+		time.Sleep(20 * time.Millisecond)
+		return to.got("probe", strconv.Itoa(n))
+	})
+}
+
+func TestHubsShareDeliveryAndPresenceThroughStandIn(t *testing.T) {
+	sin, err := broker.ListenStandIn("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := sin.Addr()
+	t.Cleanup(func() { sin.Close() })
+
+	scope := mutualScope{"alice", "bob"}
+	newHub := func() *Hub {
+		b, err := broker.DialRedis(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := NewHubWithBroker(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.SetPresenceScope(scope)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	hubA, hubB := newHub(), newHub()
+
+	bob := &recClient{uid: "bob"}
+	hubB.Add(bob)
+	probeA := &recClient{uid: "probe"}
+	hubA.Add(probeA)
+	linked(t, hubA, bob)
+	linked(t, hubB, probeA)
+
+	// presence of a user on A reaches B and B's audience
+	alice := &recClient{uid: "alice"}
+	hubA.Add(alice)
+	online := PresenceData{UserID: "alice", Online: true, Status: StatusOnline}
+	eventually(t, "alice's presence on B", func() bool {
+		return hubB.Status("alice") == StatusOnline && bob.got("presence", online)
+	})
+
+	// a frame for alice sent on B is delivered by A
+	hubB.SendToUser("alice", newFrame("dm", "hello"))
+	eventually(t, "the dm to reach alice", func() bool { return alice.got("dm", "hello") })
+
+	// restart the stand-in; both hubs redial and carry on
+	sin.Close()
+	if sin, err = broker.ListenStandIn(addr); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	eventually(t, "delivery after the restart", func() bool {
+		n++
+		hubB.SendToUser("alice", newFrame("dm", "again "+strconv.Itoa(n)))
+		time.Sleep(20 * time.Millisecond)
+		return alice.got("dm", "again "+strconv.Itoa(n))
+	})
+	linked(t, hubA, bob)
+
+	hubA.Remove(alice)
+	offline := PresenceData{UserID: "alice", Status: StatusOffline}
+	eventually(t, "alice to go offline on B", func() bool {
+		return hubB.Status("alice") == StatusOffline && bob.got("presence", offline)
+	})
+}
@@ -3,24 +3,29 @@ package handlers
 import (
 	"database/sql"
 	"net/http"
-	"strconv"
 )
 
+// LikeForm toggles the user's like of a post.
+type LikeForm struct {
+	PostID int `form:"post_id,required"`
+}
+
 func LikesHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		postID, err := strconv.Atoi(r.FormValue("post_id"))
-		if err != nil || postID <= 0 {
-			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		var form LikeForm
+		if err := decodeForm(w, r, &form); err != nil {
+			writeError(w, err)
 			return
 		}
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
+		postID := form.PostID
+		if postID <= 0 {
+			writeErr(w, http.StatusBadRequest, "Invalid post ID")
 			return
 		}
+		userID := currentUserID(r)
 
 		var existingLikeCount int
-		err = db.QueryRow("SELECT COUNT(*) FROM likes WHERE user_id = ? AND post_id = ? AND is_like = 1", userID, postID).Scan(&existingLikeCount)
+		err := db.QueryRow("SELECT COUNT(*) FROM likes WHERE user_id = ? AND post_id = ? AND is_like = 1", userID, postID).Scan(&existingLikeCount)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "Failed to check existing likes")
 			return
@@ -33,7 +38,7 @@ func LikesHandler(db *sql.DB) http.HandlerFunc {
 				writeErr(w, http.StatusInternalServerError, "Failed to remove like")
 				return
 			}
-			writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+			writeJSON(w, http.StatusOK, MessageResponse{OK: true})
 			return
 		}
 
@@ -43,6 +48,6 @@ func LikesHandler(db *sql.DB) http.HandlerFunc {
 			writeErr(w, http.StatusInternalServerError, "Failed to insert like")
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+		writeJSON(w, http.StatusOK, MessageResponse{OK: true})
 	}
 }
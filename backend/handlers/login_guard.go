@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/pkg/db/sqlite"
+)
+
+// Failed logins are counted over a sliding window. An account gets a growing
+// delay between guesses after a few failures and is locked after more; an IP
+// guessing across many accounts is throttled on its own count.
+const (
+	loginWindow        = 15 * time.Minute
+	loginDelayAfter    = 3 // account failures before delays start
+	loginMaxDelay      = time.Minute
+	loginLockAfter     = 10 // account failures that lock it
+	loginLockFor       = 15 * time.Minute
+	loginIPMaxFailures = 30
+)
+
+// Reasons stored with each attempt.
+const (
+	loginOK           = "ok"
+	loginBadPassword  = "bad_password"
+	loginBadCode      = "bad_code" // wrong second factor
+	login2FARequired  = "2fa_required"
+	loginUnknownEmail = "unknown_email"
+	loginLocked       = "locked"
+	loginThrottled    = "throttled"
+)
+
+func recordLoginAttempt(db *sql.DB, r *http.Request, userID, email string, success bool, reason string) {
+	_, _ = db.Exec(`
+		INSERT INTO login_attempts(user_id, email, ip, user_agent, success, reason)
+		VALUES(?,?,?,?,?,?)`,
+		nullableID(userID), email, clientIP(r), r.UserAgent(), success, reason,
+	)
+}
+
+// accountFailures counts wrong passwords and second-factor codes for the user
+// inside the window and since their last successful login, and when the
+// latest one happened.
+func accountFailures(db *sql.DB, userID string) (int, time.Time, error) {
+	var (
+		n    int
+		last sql.NullInt64
+	)
+	err := db.QueryRow(`
+		SELECT COUNT(*), CAST(strftime('%s', MAX(created_at)) AS INTEGER)
+		FROM login_attempts
+		WHERE user_id = ? AND reason IN (?, ?)
+		  AND created_at > datetime('now', ?)
+		  AND id > COALESCE((SELECT MAX(id) FROM login_attempts WHERE user_id = ? AND success = 1), 0)
+	`, userID, loginBadPassword, loginBadCode, sqliteOffset(-loginWindow), userID).Scan(&n, &last)
+	if err != nil || !last.Valid {
+		return n, time.Time{}, err
+	}
+	return n, time.Unix(last.Int64, 0), nil
+}
+
+// ipFailures counts failed guesses from the IP inside the window, whatever account they targeted.
+func ipFailures(db *sql.DB, ip string) (int, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM login_attempts
+		WHERE ip = ? AND reason IN (?, ?) AND created_at > datetime('now', ?)
+	`, ip, loginBadPassword, loginUnknownEmail, sqliteOffset(-loginWindow)).Scan(&n)
+	return n, err
+}
+
+// loginDelay is how long after the latest failure the next guess is allowed:
+// 1s, 2s, 4s... from the loginDelayAfter-th failure on, capped at loginMaxDelay.
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	d := time.Duration(math.Pow(2, float64(failures-loginDelayAfter))) * time.Second
+	if d > loginMaxDelay || d <= 0 {
+		return loginMaxDelay
+	}
+	return d
+}
+
+// lockedFor reports how much longer the account stays locked.
+func lockedFor(db *sql.DB, userID string) time.Duration {
+	var secs sql.NullInt64
+	err := db.QueryRow(`
+		SELECT CAST(strftime('%s', locked_until) AS INTEGER) - CAST(strftime('%s', 'now') AS INTEGER)
+		FROM users WHERE id = ? AND locked_until > datetime('now')
+	`, userID).Scan(&secs)
+	if err != nil || !secs.Valid || secs.Int64 <= 0 {
+		return 0
+	}
+	return time.Duration(secs.Int64) * time.Second
+}
+
+// lockAccount locks the user out and tells them why.
+func (s *Server) lockAccount(userID, ip string) {
+	if _, err := s.DB.Exec(`UPDATE users SET locked_until = datetime('now', ?) WHERE id = ?`,
+		sqliteOffset(loginLockFor), userID); err != nil {
+		return
+	}
+	content := map[string]any{
+		"ip":           ip,
+		"failures":     loginLockAfter,
+		"locked_until": time.Now().Add(loginLockFor).UTC().Format(time.RFC3339),
+	}
+	nid, err := s.insertNotification(context.Background(), userID, "account_locked", content)
+	if err != nil {
+		return
+	}
+	PushToUser(userID, newFrame("notification.created", map[string]any{
+		"id":      nid,
+		"type":    "account_locked",
+		"content": content,
+	}))
+	if uc, err := s.unreadCount(context.Background(), userID); err == nil {
+		PushToUser(userID, newFrame("badge.unread", CountData{Count: uc}))
+	}
+}
+
+// sqliteOffset formats d as a datetime() modifier such as "-900 seconds".
+func sqliteOffset(d time.Duration) string {
+	return strconv.Itoa(int(d.Seconds())) + " seconds"
+}
+
+func writeLoginBlocked(w http.ResponseWriter, status int, code, msg string, retryAfter time.Duration) {
+	writeError(w, &APIError{Status: status, Code: code, Message: msg, RetryAfter: retryAfter})
+}
+
+type LoginAttempt struct {
+	TS        time.Time `json:"ts"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+}
+
+type LoginAttemptsResponse struct {
+	OK       bool           `json:"ok"`
+	Attempts []LoginAttempt `json:"attempts"`
+}
+
+// GET /api/users/login-attempts
+// The current user's most recent sign-in attempts, newest first.
+func GetLoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	rows, err := sqlite.DB.Query(`
+		SELECT created_at, ip, user_agent, success, reason
+		FROM login_attempts
+		WHERE user_id = ?
+		ORDER BY id DESC
+		LIMIT 50
+	`, userID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.TS, &a.IP, &a.UserAgent, &a.Success, &a.Reason); err != nil {
+			writeErr(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LoginAttemptsResponse{OK: true, Attempts: attempts})
+}
@@ -2,63 +2,96 @@ package handlers
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"net/http"
+	"net/mail"
 	"strings"
 
 	db "backend/pkg/db/sqlite"
+	"backend/pkg/store"
 )
 
+type UpdateMeRequest struct {
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Nickname  string `json:"nickname"`
+	AboutMe   string `json:"aboutMe"`
+	Avatar    string `json:"avatar"`
+	DOB       string `json:"dob,omitempty"`
+}
+
+// MeResponse is the signed-in user's own profile.
+type MeResponse struct {
+	OK            bool   `json:"ok"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	FirstName     string `json:"firstName"`
+	LastName      string `json:"lastName"`
+	Nickname      string `json:"nickname"`
+	AboutMe       string `json:"aboutMe"`
+	Avatar        string `json:"avatar"`
+	DOB           string `json:"dob"`
+	IsPublic      bool   `json:"is_public"`
+	HidePresence  bool   `json:"hide_presence"`
+	EmailVerified bool   `json:"email_verified"`
+	PendingEmail  string `json:"pending_email"`
+}
+
+// Profile is another user's profile. Email, aboutMe and dob are left out
+// of a private profile the caller does not follow.
+type Profile struct {
+	ID        string `json:"id"`
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Nickname  string `json:"nickname"`
+	AboutMe   string `json:"aboutMe,omitempty"`
+	Avatar    string `json:"avatar"`
+	DOB       string `json:"dob,omitempty"`
+	IsPublic  bool   `json:"is_public"`
+}
+
+func loadMe(uid string) (MeResponse, error) {
+	me := MeResponse{OK: true}
+	err := db.DB.QueryRow(`
+		SELECT id,
+		       email,
+		       first_name,
+		       last_name,
+		       COALESCE(nickname, ''),
+		       COALESCE(about_me, ''),
+		       COALESCE(avatar, ''),
+		       date(dob),
+		       is_public,
+		       hide_presence,
+		       email_verified_at IS NOT NULL,
+		       COALESCE(pending_email, '')
+		FROM users
+		WHERE id = ?
+	`, uid).Scan(
+		&me.ID,
+		&me.Email,
+		&me.FirstName,
+		&me.LastName,
+		&me.Nickname,
+		&me.AboutMe,
+		&me.Avatar,
+		&me.DOB,
+		&me.IsPublic,
+		&me.HidePresence,
+		&me.EmailVerified,
+		&me.PendingEmail,
+	)
+	return me, err
+}
+
 func MeHandler(w http.ResponseWriter, r *http.Request) {
-	uid, err := GetUserIDFromRequest(r)
-	if err != nil || uid == "" {
-		// Keep old shape for callers that expect `id` and `nickname`
-		writeJSON(w, http.StatusUnauthorized, map[string]any{
-			"ok":       false,
-			"id":       "",
-			"nickname": "",
-		})
-		return
-	}
+	uid := currentUserID(r)
 
 	switch r.Method {
 	case http.MethodGet:
-		// 🔹 Return FULL current user profile
-		var user struct {
-			ID        string `json:"id"`
-			Email     string `json:"email"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Nickname  string `json:"nickname"`
-			AboutMe   string `json:"aboutMe"`
-			Avatar    string `json:"avatar"`
-			DOB       string `json:"dob"`
-			IsPublic  bool   `json:"is_public"`
-		}
-
-		err := db.DB.QueryRow(`
-			SELECT id,
-			       email,
-			       first_name,
-			       last_name,
-			       COALESCE(nickname, ''),
-			       COALESCE(about_me, ''),
-			       COALESCE(avatar, ''),
-			       date(dob),
-			       is_public
-			FROM users
-			WHERE id = ?
-		`, uid).Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Nickname,
-			&user.AboutMe,
-			&user.Avatar,
-			&user.DOB,
-			&user.IsPublic,
-		)
+		me, err := loadMe(uid)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				writeErr(w, http.StatusNotFound, "User not found")
@@ -67,36 +100,15 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-
-		// Keep `ok`, `id`, `nickname` for old callers
-		writeJSON(w, http.StatusOK, map[string]any{
-			"ok":        true,
-			"id":        user.ID,
-			"email":     user.Email,
-			"firstName": user.FirstName,
-			"lastName":  user.LastName,
-			"nickname":  user.Nickname,
-			"aboutMe":   user.AboutMe,
-			"avatar":    user.Avatar,
-			"dob":       user.DOB,
-			"is_public": user.IsPublic,
-		})
+		writeJSON(w, http.StatusOK, me)
 		return
 
 	case http.MethodPost:
 		// 🔹 Update current user from JSON body (used by your edit page)
-		var payload struct {
-			Email     string `json:"email"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Nickname  string `json:"nickname"`
-			AboutMe   string `json:"aboutMe"`
-			Avatar    string `json:"avatar"`
-			DOB       string `json:"dob"`
-		}
+		var payload UpdateMeRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			writeErr(w, http.StatusBadRequest, "Invalid JSON body")
+		if err := decodeJSON(w, r, &payload); err != nil {
+			writeError(w, err)
 			return
 		}
 
@@ -105,10 +117,36 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// a new email only takes effect once the link sent to it is opened
+		var current string
+		if err := db.DB.QueryRow(`SELECT email FROM users WHERE id = ?`, uid).Scan(&current); err != nil {
+			writeErr(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		newEmail := strings.ToLower(strings.TrimSpace(payload.Email))
+		if newEmail != current {
+			if _, err := mail.ParseAddress(newEmail); err != nil {
+				writeErr(w, http.StatusBadRequest, "Invalid email format")
+				return
+			}
+			exists, err := db.CheckEmailExists(newEmail)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if exists {
+				writeErr(w, http.StatusConflict, "Email already registered")
+				return
+			}
+			if err := requestEmailChange(uid, newEmail); err != nil {
+				writeErr(w, http.StatusInternalServerError, "Failed to send confirmation email")
+				return
+			}
+		}
+
 		_, err := db.DB.Exec(`
 			UPDATE users
-			SET email = ?,
-			    first_name = ?,
+			SET first_name = ?,
 			    last_name = ?,
 			    dob = ?,
 			    nickname = ?,
@@ -116,7 +154,6 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 			    avatar = ?
 			WHERE id = ?
 		`,
-			payload.Email,
 			payload.FirstName,
 			payload.LastName,
 			payload.DOB,
@@ -131,58 +168,12 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// After update, return the fresh data (same shape as GET)
-		var user struct {
-			ID        string `json:"id"`
-			Email     string `json:"email"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Nickname  string `json:"nickname"`
-			AboutMe   string `json:"aboutMe"`
-			Avatar    string `json:"avatar"`
-			DOB       string `json:"dob"`
-			IsPublic  bool   `json:"is_public"`
-		}
-
-		err = db.DB.QueryRow(`
-			SELECT id,
-			       email,
-			       first_name,
-			       last_name,
-			       COALESCE(nickname, ''),
-			       COALESCE(about_me, ''),
-			       COALESCE(avatar, ''),
-			       date(dob),
-			       is_public
-			FROM users
-			WHERE id = ?
-		`, uid).Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Nickname,
-			&user.AboutMe,
-			&user.Avatar,
-			&user.DOB,
-			&user.IsPublic,
-		)
+		me, err := loadMe(uid)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-
-		writeJSON(w, http.StatusOK, map[string]any{
-			"ok":        true,
-			"id":        user.ID,
-			"email":     user.Email,
-			"firstName": user.FirstName,
-			"lastName":  user.LastName,
-			"nickname":  user.Nickname,
-			"aboutMe":   user.AboutMe,
-			"avatar":    user.Avatar,
-			"dob":       user.DOB,
-			"is_public": user.IsPublic,
-		})
+		writeJSON(w, http.StatusOK, me)
 		return
 
 	default:
@@ -192,38 +183,25 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
-func GetUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := currentUserID(r)
 
-	// extract user id from url path
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
-		writeErr(w, http.StatusBadRequest, "Invalid user ID format")
-		return
-	}
-	profileUserID := pathParts[3]
+	profileUserID := r.PathValue("id")
 
 
-	var isPublic bool
-	err = db.DB.QueryRow(`
-		SELECT is_public FROM users WHERE id = ?
-	`, profileUserID).Scan(&isPublic)
-	
+	ctx := r.Context()
+	isPublic, err := s.Store.Users.IsPublic(ctx, profileUserID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "User not found")
+		return
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			writeErr(w, http.StatusNotFound, "User not found")
-		} else {
-			writeErr(w, http.StatusInternalServerError, "Database error")
-		}
+		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
@@ -231,70 +209,42 @@ func GetUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	returnLimitedData := false
 	if !isPublic && userID != profileUserID {
 		// Check if the requesting user follows the profile user
-		var followsMe bool
-		err = db.DB.QueryRow(`
-			SELECT COUNT(*) > 0 
-			FROM followers 
-			WHERE follower_id = ? 
-			AND following_id = ? 
-			AND status = 'accepted'
-		`, userID, profileUserID).Scan(&followsMe)
-		
+		followsUser, err := s.Store.Follows.IsFollowing(ctx, userID, profileUserID)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
 		}
 		
-		if !followsMe {
+		if !followsUser {
 			returnLimitedData = true
 		}
 	}
 
 	// Fetch user data based on access level
 	if returnLimitedData {
-		var limitedUser struct {
-			ID        string `json:"id"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Nickname  string `json:"nickname"`
-			Avatar    string `json:"avatar"`
-			IsPublic  bool   `json:"is_public"`
+		u, err := s.Store.Users.Summary(ctx, profileUserID)
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "User not found")
+			return
 		}
-
-		err = db.DB.QueryRow(`
-			SELECT id, first_name, last_name, nickname, avatar, is_public
-			FROM users 
-			WHERE id = ?
-		`, profileUserID).Scan(
-			&limitedUser.ID, &limitedUser.FirstName, &limitedUser.LastName, 
-			&limitedUser.Nickname, &limitedUser.Avatar, &limitedUser.IsPublic,
-		)
-
 		if err != nil {
-			if err == sql.ErrNoRows {
-				writeErr(w, http.StatusNotFound, "User not found")
-			} else {
-				writeErr(w, http.StatusInternalServerError, "Database error")
-			}
+			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
 		}
 
-		writeJSON(w, http.StatusOK, limitedUser)
+		writeJSON(w, http.StatusOK, Profile{
+			ID:        u.ID,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Nickname:  u.Nickname,
+			Avatar:    u.Avatar,
+			IsPublic:  isPublic,
+		})
 		return
 	}
 
 	// Return full profile data for public accounts, owners, or followers
-	var user struct {
-		ID        string `json:"id"`
-		Email     string `json:"email"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Nickname  string `json:"nickname"`
-		AboutMe   string `json:"aboutMe"`
-		Avatar    string `json:"avatar"`
-		DOB       string `json:"dob"`
-		IsPublic  bool   `json:"is_public"`
-	}
+	var user Profile
 
 	err = db.DB.QueryRow(`
 		SELECT id, email, first_name, last_name, nickname, about_me, avatar, dob, is_public
@@ -322,24 +272,8 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// extract user id from url path
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
-		writeErr(w, http.StatusBadRequest, "Invalid user ID format")
-		return
-	}
-	userId := pathParts[3]
-	var user struct {
-		ID        string `json:"id"`
-		Email     string `json:"email"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Nickname  string `json:"nickname"`
-		AboutMe   string `json:"aboutMe"`
-		Avatar    string `json:"avatar"`
-		DOB       string `json:"dob"`
-		IsPublic  bool   `json:"is_public"`
-	}
+	userId := r.PathValue("id")
+	var user Profile
 
 	err := db.DB.QueryRow(
 		`
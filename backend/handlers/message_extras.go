@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+
+	"backend/pkg/store"
+)
+
+// messageExtras holds everything hanging off a page of chat messages,
+// each map keyed by message id (replies by parent id).
+type messageExtras struct {
+	attachments map[string][]Attachment
+	replies     map[string]*ReplySnippet
+	reactions   map[string][]ReactionSummary
+}
+
+// messageExtrasFor fetches the attachments, quoted parents and aggregated
+// reactions of msgs in three queries instead of one per message.
+func (s *Server) messageExtrasFor(ctx context.Context, kind string, msgs []store.Message) (messageExtras, error) {
+	ids := make([]string, len(msgs))
+	var parentIDs []string
+	for k, m := range msgs {
+		ids[k] = m.ID
+		if m.ReplyTo != "" {
+			parentIDs = append(parentIDs, m.ReplyTo)
+		}
+	}
+
+	var x messageExtras
+	attachments, err := s.Store.Messages.Attachments(ctx, kind, ids)
+	if err != nil {
+		return x, err
+	}
+	x.attachments = make(map[string][]Attachment, len(attachments))
+	for id, list := range attachments {
+		x.attachments[id] = withURLs(list)
+	}
+	parents, err := s.Store.Messages.Parents(ctx, kind, parentIDs)
+	if err != nil {
+		return x, err
+	}
+	x.replies = make(map[string]*ReplySnippet, len(parents))
+	for id, m := range parents {
+		x.replies[id] = replySnippetOf(m)
+	}
+	if x.reactions, err = s.Store.Messages.Reactions(ctx, kind, ids); err != nil {
+		return x, err
+	}
+	return x, nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"backend/pkg/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total",
+		"HTTP requests by route pattern and status.", "method", "route", "status")
+	httpDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency by route pattern; websocket upgrades are left out.",
+		metrics.DefBuckets, "method", "route")
+
+	wsFrames = metrics.NewCounterVec("ws_frames_total",
+		"Websocket frames by direction (in, out) and frame type.", "direction", "type")
+
+	notificationsCreated = metrics.NewCounterVec("notifications_created_total",
+		"Notifications stored, by type.", "type")
+
+	uploadBytes = metrics.NewCounterVec("upload_bytes_total",
+		"Bytes of uploaded files saved, by kind (image, attachment).", "kind")
+)
+
+// RegisterHubMetrics exposes h's connection and presence counts. Call it once,
+// for the hub the websocket server uses.
+func RegisterHubMetrics(h *Hub) {
+	metrics.NewGaugeFunc("ws_connections", "Open websocket connections on this instance.", func() float64 {
+		conns, _ := h.Counts()
+		return float64(conns)
+	})
+	metrics.NewGaugeFunc("ws_online_users", "Users connected to any instance.", func() float64 {
+		_, users := h.Counts()
+		return float64(users)
+	})
+}
+
+// observeRequest records one finished request. Requests no route matched
+// share one label, and odd methods another, so scanners can't blow up the
+// series count.
+func observeRequest(method, route string, status int, seconds float64) {
+	if route == "" {
+		route = "unmatched"
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+	httpRequests.Inc(method, route, strconv.Itoa(status))
+	if status != http.StatusSwitchingProtocols {
+		httpDuration.Observe(seconds, method, route)
+	}
+}
+
+// outFrameType names a frame queued by SendJSON; payloads relayed from other
+// instances arrive already encoded.
+func outFrameType(v any, b []byte) string {
+	if f, ok := v.(Frame); ok {
+		return f.Type
+	}
+	var head struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(b, &head) != nil || head.Type == "" {
+		return "other"
+	}
+	return head.Type
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"backend/pkg/router"
+)
+
+// TagAuth marks routes that sit behind RequireAuth, so the OpenAPI document
+// can list the session cookie as their security requirement.
+const TagAuth = "auth"
+
+// endpointDoc describes one route for the OpenAPI document. Request and
+// response shapes are the types the handlers decode and encode, turned into
+// schemas the same way the websocket schema is built from the frame types.
+type endpointDoc struct {
+	Summary  string
+	Query    any         // struct decoded by decodeQuery, `query:"name[,required]"` tags
+	Body     any         // JSON request body
+	Form     any         // struct decoded by decodeForm, `form:"name[,required]"` tags
+	Optional bool        // Body or Form may be omitted
+	Response any         // JSON success body; nil is any object
+	Status   int         // success status, 200 if zero
+	Also     map[int]any // other statuses the route answers with, and their bodies
+	Produces string      // success content type when it isn't JSON
+}
+
+// endpointDocs covers every route main registers, keyed like the mux
+// patterns. LoadOpenAPI fails when the two disagree.
+var endpointDocs = map[string]endpointDoc{
+	"GET /ws":                 {Summary: "Upgrade to the websocket protocol described at /api/ws/schema.json", Status: http.StatusSwitchingProtocols},
+	"GET /healthz":            {Summary: "Liveness probe, same as /healthz/live", Response: LiveStatus{}},
+	"GET /healthz/live":       {Summary: "Liveness probe: the process serves HTTP", Response: LiveStatus{}},
+	"GET /healthz/ready":      {Summary: "Readiness probe with a result per check; 503 when one fails", Response: HealthReport{}, Also: map[int]any{http.StatusServiceUnavailable: HealthReport{}}},
+	"GET /metrics":            {Summary: "Metrics in the Prometheus text format", Produces: "text/plain"},
+	"GET /uploads/":           {Summary: "Download an uploaded image", Produces: "application/octet-stream"},
+	"GET /api/openapi.json":   {Summary: "This document"},
+	"GET /api/ws/stats":       {Summary: "Websocket connection counters", Response: WSStats{}},
+	"GET /api/ws/schema.json": {Summary: "JSON Schema of the websocket frames"},
+
+	"POST /api/logout":          {Summary: "End the current session", Response: MessageResponse{}},
+	"POST /api/register":        {Summary: "Create an account and send the verification email", Form: RegisterForm{}, Response: RegisterResponse{}},
+	"POST /api/login":           {Summary: "Log in; may ask for a second factor", Body: LoginRequest{}, Response: MessageResponse{}},
+	"POST /api/login/2fa":       {Summary: "Finish a login with an authenticator or recovery code", Body: TwoFactorCodeRequest{}, Response: MessageResponse{}},
+	"POST /api/password/forgot": {Summary: "Email a password reset link", Body: ForgotPasswordRequest{}, Response: MessageResponse{}},
+	"POST /api/password/reset":  {Summary: "Set a new password with a reset token", Body: ResetPasswordRequest{}, Response: MessageResponse{}},
+	"POST /api/email/verify":    {Summary: "Redeem an email verification or email change token", Body: EmailTokenRequest{}, Response: EmailVerifiedResponse{}},
+	"POST /api/email/resend":    {Summary: "Send the verification email again", Response: MessageResponse{}},
+	"GET /api/me":               {Summary: "The current user's profile", Response: MeResponse{}},
+	"POST /api/me":              {Summary: "Update the current user's profile", Body: UpdateMeRequest{}, Response: MeResponse{}},
+
+	"POST /api/users/change-password":    {Summary: "Change the password", Body: ChangePasswordRequest{}, Response: MessageResponse{}},
+	"POST /api/users/2fa/enroll":         {Summary: "Start two-factor enrollment with a fresh secret", Body: PasswordRequest{}, Response: EnrollResponse{}},
+	"POST /api/users/2fa/activate":       {Summary: "Turn two-factor login on with a first code", Body: TwoFactorCodeRequest{}, Response: RecoveryCodesResponse{}},
+	"POST /api/users/2fa/disable":        {Summary: "Turn two-factor login off", Body: DisableTwoFactorRequest{}, Response: MessageResponse{}},
+	"POST /api/users/2fa/recovery-codes": {Summary: "Replace the recovery codes", Body: TwoFactorCodeRequest{}, Response: RecoveryCodesResponse{}},
+	"GET /api/users/2fa":                 {Summary: "Two-factor status", Response: TwoFactorStatus{}},
+	"GET /api/users/login-attempts":      {Summary: "Recent login attempts on the account", Response: LoginAttemptsResponse{}},
+
+	"GET /api/messages":              {Summary: "Direct message history with a peer", Query: HistoryQuery{}, Response: []historyRow{}},
+	"POST /api/chat/attachments":     {Summary: "Upload a chat attachment", Form: AttachmentForm{}, Response: AttachmentResponse{}, Status: http.StatusCreated},
+	"GET /api/chat/attachments/{id}": {Summary: "Download a chat attachment", Produces: "application/octet-stream"},
+
+	"GET /api/posts":                {Summary: "Feed of posts visible to the current user", Response: []Post{}},
+	"POST /api/posts":               {Summary: "Create a post", Form: CreatePostForm{}, Response: CreatedResponse{}, Status: http.StatusCreated},
+	"POST /api/comments":            {Summary: "Comment on a post, or list its comments when content is empty", Form: CommentForm{}, Response: CreatedResponse{}, Status: http.StatusCreated, Also: map[int]any{http.StatusOK: []Comment{}}},
+	"POST /api/likes":               {Summary: "Like or unlike a post", Form: LikeForm{}, Response: MessageResponse{}},
+	"GET /api/relationships":        {Summary: "Followers and followings of the current user", Response: RelationshipsResponse{}},
+	"GET /api/relationshipsForPost": {Summary: "Users a custom-privacy post can be shared with", Response: PostAudienceResponse{}},
+
+	"GET /api/users":                         {Summary: "All users", Response: []UserSummary{}},
+	"GET /api/users/{id}/user":               {Summary: "A user's profile, without the privacy check", Response: Profile{}},
+	"GET /api/users/{id}/follow-counts":      {Summary: "Follower and following counts", Response: FollowCounts{}},
+	"GET /api/users/{id}/privacy-status":     {Summary: "Whether a profile is private", Response: PrivacyStatus{}},
+	"POST /api/users/toggle-privacy":         {Summary: "Switch the profile between public and private", Response: PrivacyToggled{}},
+	"POST /api/users/toggle-presence":        {Summary: "Hide or show online presence", Response: PresenceSetting{}},
+	"POST /api/users/follow":                 {Summary: "Follow a user, or request to when the profile is private", Body: FollowRequest{}, Response: FollowResponse{}},
+	"POST /api/users/unfollow":               {Summary: "Unfollow a user", Body: FollowRequest{}, Response: MessageResponse{}},
+	"GET /api/users/follow-status":           {Summary: "Follow status towards a user", Query: FollowStatusQuery{}, Response: StatusResponse{}},
+	"GET /api/users/pending-requests":        {Summary: "Follow requests waiting for an answer", Response: PendingFollowsResponse{}},
+	"POST /api/users/respond-follow-request": {Summary: "Accept or decline a follow request", Body: RespondFollowRequest{}, Response: FollowResponse{}},
+	"GET /api/users/{id}":                    {Summary: "A user's profile, limited when private", Response: Profile{}},
+	"GET /api/users/{id}/posts":              {Summary: "Posts by a user", Response: []Post{}},
+	"GET /api/users/{id}/followers":          {Summary: "A user's followers", Response: FollowersResponse{}},
+	"GET /api/users/{id}/followings":         {Summary: "Users a user follows", Response: FollowingsResponse{}},
+
+	"GET /api/group/groups":                 {Summary: "All groups", Response: GroupsResponse{}},
+	"GET /api/group/initial-invite":         {Summary: "Users that can be invited to a new group", Response: UsersResponse{}},
+	"POST /api/group/create-group":          {Summary: "Create a group", Form: CreateGroupForm{}, Response: CreateGroupResponse{}, Status: http.StatusCreated},
+	"GET /api/group/panelItems":             {Summary: "Groups and chats for the side panel", Response: PanelItemsResponse{}},
+	"POST /api/group/respond-group-invite":  {Summary: "Accept or decline a group invite", Form: RespondInviteForm{}, Response: MessageResponse{}},
+	"DELETE /api/group/leave-group":         {Summary: "Leave a group", Query: OptionalGroupQuery{}, Body: GroupIDRequest{}, Optional: true, Response: MessageResponse{}},
+	"DELETE /api/group/delete-group":        {Summary: "Delete a group you created", Query: OptionalGroupQuery{}, Body: GroupIDRequest{}, Optional: true, Response: MessageResponse{}},
+	"GET /api/group/invite-users-list":      {Summary: "Users that can be invited to a group", Query: GroupQuery{}, Response: UsersResponse{}},
+	"POST /api/group/invite-users":          {Summary: "Invite users to a group", Form: InviteForm{}, Response: MessageResponse{}},
+	"POST /api/group/request-to-join":       {Summary: "Ask to join a group", Form: GroupForm{}, Response: MessageResponse{}},
+	"POST /api/group/respond-group-request": {Summary: "Accept or decline a request to join", Form: RespondJoinForm{}, Response: MessageResponse{}},
+	"GET /api/group/check-join-status":      {Summary: "The current user's membership of a group", Query: GroupQuery{}, Response: StatusResponse{}},
+	"GET /api/group/messages":               {Summary: "Group chat history", Query: GroupQuery{}, Response: GroupMessagesResponse{}},
+	"POST /api/group/messages/read":         {Summary: "Mark group messages read, up to a message when one is given", Form: GroupMessagesReadForm{}, Response: UnreadResponse{}},
+	"GET /api/group/members":                {Summary: "Members of a group", Query: GroupQuery{}, Response: MembersResponse{}},
+
+	"GET /api/group/events":        {Summary: "Events of a group", Query: EventsQuery{}, Response: EventsResponse{}},
+	"POST /api/group/create-event": {Summary: "Create a group event", Form: CreateEventForm{}, Response: MessageResponse{}, Status: http.StatusCreated},
+	"POST /api/group/event-vote":   {Summary: "Answer an event", Form: EventVoteForm{}, Response: MessageResponse{}},
+
+	"GET /api/group/posts":          {Summary: "Posts in a group", Query: GroupPostsQuery{}, Response: GroupPostsResponse{}},
+	"POST /api/group/posts/create":  {Summary: "Post in a group", Form: GroupPostForm{}, Response: CreatedResponse{}, Status: http.StatusCreated},
+	"GET /api/group/post/comments":  {Summary: "Comments on a group post", Query: PostCommentsQuery{}, Response: PostCommentsResponse{}},
+	"POST /api/group/post/comments": {Summary: "Comment on a group post", Form: PostCommentForm{}, Response: PostCommentResponse{}},
+
+	"GET /api/notifications/unread-count":     {Summary: "Unread notification count", Response: CountResponse{}},
+	"POST /api/notifications/read-by-message": {Summary: "Mark the notifications of a message read", Body: ReadByMessageRequest{}, Response: UnreadResponse{}},
+	"POST /api/notifications/{id}/read":       {Summary: "Mark a notification read", Response: UnreadResponse{}},
+}
+
+var openAPIJSON []byte
+
+// LoadOpenAPI builds the OpenAPI document for routes and keeps it for
+// OpenAPIHandler. It fails when a route has no entry in endpointDocs or an
+// entry no longer matches a route, so the document can't drift from main;
+// the document is then not served.
+func LoadOpenAPI(routes []router.Route) error {
+	doc, err := openAPI(routes)
+	if err != nil {
+		return err
+	}
+	openAPIJSON, err = json.MarshalIndent(doc, "", "  ")
+	return err
+}
+
+// GET /api/openapi.json
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if openAPIJSON == nil {
+		writeErr(w, http.StatusServiceUnavailable, "OpenAPI document not loaded")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPIJSON)
+}
+
+func openAPI(routes []router.Route) (map[string]any, error) {
+	var missing, stale []string
+	seen := map[string]bool{}
+	for _, rt := range routes {
+		key := rt.Method + " " + rt.Pattern
+		seen[key] = true
+		if _, ok := endpointDocs[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	for key := range endpointDocs {
+		if !seen[key] {
+			stale = append(stale, key)
+		}
+	}
+	if len(missing) > 0 || len(stale) > 0 {
+		sort.Strings(missing)
+		sort.Strings(stale)
+		return nil, fmt.Errorf("openapi: routes without docs %v, docs without routes %v", missing, stale)
+	}
+
+	g := schemaGen{defs: map[string]any{}, refPrefix: "#/components/schemas/"}
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": g.schemaFor(reflect.TypeOf(ErrorBody{}))},
+		},
+	}
+
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		d := endpointDocs[rt.Method+" "+rt.Pattern]
+		path, params := openAPIPath(rt.Pattern)
+		params = append(params, paramsFor(&g, "query", d.Query)...)
+
+		op := map[string]any{
+			"summary":   d.Summary,
+			"tags":      []string{openAPITag(rt.Pattern)},
+			"responses": map[string]any{"default": errorResponse},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if body := requestBody(&g, d); body != nil {
+			op["requestBody"] = body
+		}
+		if d.Status == 0 {
+			d.Status = http.StatusOK
+		}
+		responses := op["responses"].(map[string]any)
+		responses[fmt.Sprint(d.Status)] = successResponse(&g, d)
+		for status, body := range d.Also {
+			responses[fmt.Sprint(status)] = successResponse(&g, endpointDoc{Status: status, Response: body})
+		}
+		for _, tag := range rt.Tags {
+			if tag == TagAuth {
+				op["security"] = []any{map[string]any{"sessionCookie": []string{}}}
+			}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Social network API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.defs,
+			"securitySchemes": map[string]any{
+				"sessionCookie": map[string]any{"type": "apiKey", "in": "cookie", "name": "session_token"},
+			},
+		},
+	}, nil
+}
+
+// openAPIPath turns a mux pattern into an OpenAPI path and its path
+// parameters; a subtree pattern like /uploads/ becomes /uploads/{path}.
+func openAPIPath(pattern string) (string, []any) {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "{path...}"
+	}
+	var params []any
+	segs := strings.Split(pattern, "/")
+	for i, s := range segs {
+		if !strings.HasPrefix(s, "{") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.Trim(s, "{}"), "...")
+		segs[i] = "{" + name + "}"
+		params = append(params, map[string]any{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	return strings.Join(segs, "/"), params
+}
+
+// openAPITag groups operations by the first segment after /api.
+func openAPITag(pattern string) string {
+	rest, ok := strings.CutPrefix(pattern, "/api/")
+	if !ok {
+		return "server"
+	}
+	tag, _, _ := strings.Cut(rest, "/")
+	return tag
+}
+
+// paramsFor lists the fields of a struct tagged with `in` ("query" or
+// "form") as OpenAPI parameters.
+func paramsFor(g *schemaGen, in string, v any) []any {
+	if v == nil {
+		return nil
+	}
+	var params []any
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get(in), ",")
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       in,
+			"required": opts == "required",
+			"schema":   g.schemaFor(f.Type),
+		})
+	}
+	return params
+}
+
+func requestBody(g *schemaGen, d endpointDoc) map[string]any {
+	content := map[string]any{}
+	switch {
+	case d.Body != nil:
+		content["application/json"] = map[string]any{"schema": g.schemaFor(reflect.TypeOf(d.Body))}
+	case d.Form != nil:
+		props := map[string]any{}
+		required := []string{}
+		upload := false
+		for _, p := range paramsFor(g, "form", d.Form) {
+			p := p.(map[string]any)
+			name := p["name"].(string)
+			props[name] = p["schema"]
+			if p["required"].(bool) {
+				required = append(required, name)
+			}
+			if p["schema"].(map[string]any)["format"] == "binary" {
+				upload = true
+			}
+		}
+		schema := map[string]any{"schema": map[string]any{"type": "object", "properties": props, "required": required}}
+		content["multipart/form-data"] = schema
+		if !upload {
+			content["application/x-www-form-urlencoded"] = schema
+		}
+	default:
+		return nil
+	}
+	return map[string]any{"required": !d.Optional, "content": content}
+}
+
+func successResponse(g *schemaGen, d endpointDoc) map[string]any {
+	resp := map[string]any{"description": http.StatusText(d.Status)}
+	switch {
+	case d.Status == http.StatusSwitchingProtocols:
+	case d.Produces == "":
+		schema := map[string]any{"type": "object"}
+		if d.Response != nil {
+			schema = g.schemaFor(reflect.TypeOf(d.Response))
+		}
+		resp["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+	default:
+		resp["content"] = map[string]any{d.Produces: map[string]any{"schema": map[string]any{"type": "string"}}}
+	}
+	return resp
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/pkg/router"
+)
+
+// docRoutes registers one route per endpointDocs entry, the ones under /api
+// behind the auth tag.
+func docRoutes(skip string) *router.Router {
+	rt := router.New()
+	authed := rt.Tagged(TagAuth)
+	noop := func(http.ResponseWriter, *http.Request) {}
+	for key := range endpointDocs {
+		if key == skip {
+			continue
+		}
+		method, pattern, _ := strings.Cut(key, " ")
+		r := rt
+		if strings.HasPrefix(pattern, "/api/") {
+			r = authed
+		}
+		r.Handle(method, pattern, noop)
+	}
+	return rt
+}
+
+func TestOpenAPIOperations(t *testing.T) {
+	rt := docRoutes("")
+	doc, err := openAPI(rt.Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := doc["paths"].(map[string]map[string]any)
+	for _, r := range rt.Routes() {
+		path, _ := openAPIPath(r.Pattern)
+		op, ok := paths[path][strings.ToLower(r.Method)].(map[string]any)
+		if !ok {
+			t.Errorf("no operation for %s %s", r.Method, r.Pattern)
+			continue
+		}
+		if _, secured := op["security"]; secured != strings.HasPrefix(r.Pattern, "/api/") {
+			t.Errorf("%s %s: security %v", r.Method, r.Pattern, op["security"])
+		}
+	}
+
+	op := paths["/api/users/{id}"]["get"].(map[string]any)
+	params := op["parameters"].([]any)
+	if name := params[0].(map[string]any)["name"]; name != "id" {
+		t.Errorf("path parameter %v, want id", name)
+	}
+	if _, ok := paths["/uploads/{path}"]["get"]; !ok {
+		t.Error("subtree pattern /uploads/ not documented as /uploads/{path}")
+	}
+}
+
+func TestOpenAPIRejectsDrift(t *testing.T) {
+	rt := docRoutes("GET /api/me")
+	if _, err := openAPI(rt.Routes()); err == nil || !strings.Contains(err.Error(), "GET /api/me") {
+		t.Errorf("stale doc entry: got %v", err)
+	}
+
+	rt = docRoutes("")
+	rt.Get("/api/undocumented", func(http.ResponseWriter, *http.Request) {})
+	if _, err := openAPI(rt.Routes()); err == nil || !strings.Contains(err.Error(), "GET /api/undocumented") {
+		t.Errorf("undocumented route: got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy is what register, change-password and reset all enforce.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireLetter bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// bcrypt ignores everything past 72 bytes, so longer passwords would be
+// silently truncated.
+const maxPasswordBytes = 72
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8, RequireLetter: true, RequireDigit: true}
+}
+
+// Passwords is the policy in force; main may replace it at startup.
+var Passwords = DefaultPasswordPolicy()
+
+// Check returns a message suitable for the user when pw is not acceptable.
+func (p PasswordPolicy) Check(pw string) error {
+	if len([]rune(pw)) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", p.MinLength)
+	}
+	if len(pw) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordBytes)
+	}
+	if strings.ContainsFunc(pw, unicode.IsSpace) {
+		return errors.New("Password cannot contain spaces")
+	}
+	var letter, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireLetter && !letter:
+		return errors.New("Password must contain a letter")
+	case p.RequireDigit && !digit:
+		return errors.New("Password must contain a digit")
+	case p.RequireSymbol && !symbol:
+		return errors.New("Password must contain a symbol")
+	}
+	return nil
+}
+
+// ParsePasswordPolicy reads a policy like "min=10,letter,digit,symbol"; rules
+// not listed are off.
+func ParsePasswordPolicy(s string) (PasswordPolicy, error) {
+	var p PasswordPolicy
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case part == "letter":
+			p.RequireLetter = true
+		case part == "digit":
+			p.RequireDigit = true
+		case part == "symbol":
+			p.RequireSymbol = true
+		case strings.HasPrefix(part, "min="):
+			n, err := strconv.Atoi(strings.TrimPrefix(part, "min="))
+			if err != nil || n < 1 {
+				return p, fmt.Errorf("password policy: %q: bad length", part)
+			}
+			p.MinLength = n
+		default:
+			return p, fmt.Errorf("password policy: unknown rule %q", part)
+		}
+	}
+	if p.MinLength == 0 {
+		p.MinLength = DefaultPasswordPolicy().MinLength
+	}
+	return p, nil
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	db "backend/pkg/db/sqlite"
+	"backend/pkg/mailer"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+func hashPassword(pw string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// POST /api/users/change-password
+// Requires the current password; every other session is signed out and this
+// one gets a fresh token.
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	var body ChangePasswordRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	current, err := db.GetHashedPasswordByID(userID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(current), []byte(body.CurrentPassword)) != nil {
+		writeErr(w, http.StatusForbidden, "Current password is incorrect")
+		return
+	}
+	if body.NewPassword == body.CurrentPassword {
+		writeErr(w, http.StatusBadRequest, "New password must be different from the current one")
+		return
+	}
+	if err := Passwords.Check(body.NewPassword); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := setPassword(userID, body.NewPassword); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+	if err := setSession(w, userID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Password changed"})
+}
+
+// setPassword stores the new hash, signs the user out everywhere and voids
+// any reset links still outstanding.
+func setPassword(userID, pw string) error {
+	hashed, err := hashPassword(pw)
+	if err != nil {
+		return err
+	}
+	if err := db.UpdatePasswordHash(userID, hashed); err != nil {
+		return err
+	}
+	if err := db.DeleteUserSessions(userID); err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL`, userID)
+	return err
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// POST /api/password/forgot
+// Always answers the same way so it can't be used to probe for accounts.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body ForgotPasswordRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if strings.TrimSpace(body.Email) == "" {
+		writeErr(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+
+	if userID, err := db.GetUserID(email); err == nil && userID != "" {
+		if err := sendPasswordReset(userID, email); err != nil {
+			reqLog(r).Error("password reset email", "err", err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "If that email is registered, a reset link is on its way"})
+}
+
+func sendPasswordReset(userID, email string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	// only the newest link works
+	if _, err := db.DB.Exec(`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL`, userID); err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec(`
+		INSERT INTO password_resets(user_id, token_hash, expires_at)
+		VALUES(?, ?, datetime('now', ?))`,
+		userID, hashToken(token), sqliteOffset(passwordResetTTL),
+	); err != nil {
+		return err
+	}
+
+	link := frontendURL("/reset-password") + "?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password for this account.\n\n" +
+			"Open this link within " + passwordResetTTL.String() + " to choose a new one:\n" + link + "\n\n" +
+			"If it wasn't you, ignore this email; your password stays the same.\n",
+	}
+	// sent in the background so the response time doesn't reveal the account exists
+	sendMail(msg)
+	return nil
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// POST /api/password/reset
+// Spends a reset token; it works once and only before it expires.
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body ResetPasswordRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Token == "" {
+		writeErr(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+	if err := Passwords.Check(body.NewPassword); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// claiming the token and checking it are one statement, so two requests can't both use it
+	var userID string
+	err := db.DB.QueryRow(`
+		UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')
+		RETURNING user_id
+	`, hashToken(body.Token)).Scan(&userID)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "Reset link is invalid or has expired")
+		return
+	}
+
+	if err := setPassword(userID, body.NewPassword); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+	// whoever holds the mailbox owns the account; lift any brute-force lock
+	_, _ = db.DB.Exec(`UPDATE users SET locked_until = NULL WHERE id = ?`, userID)
+
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Password has been reset, you can sign in now"})
+}
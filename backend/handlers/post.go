@@ -1,497 +1,226 @@
 package handlers
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path"
 	"path/filepath"
 	"strings"
-	"time"
-	"mime/multipart"
-	"backend/pkg/db/sqlite"
+
+	"backend/pkg/store"
 )
 
-type Post struct {
-	UserID         string    `json:"user_id"`
-	PostID         int64     `json:"post_id,omitempty"`
-	Nickname       string    `json:"nickname"`
-	FirstName      string    `json:"firstName,omitempty"`
-	LastName       string    `json:"lastName,omitempty"`
-	Avatar         string    `json:"avatar,omitempty"`
-	Content        string    `json:"content"`
-	Image          string    `json:"image"`
-	Privacy        string    `json:"privacy"`
-	CreatedAt      time.Time `json:"created_at,omitempty"`
-	CommentCount   int       `json:"comment_count,omitempty"`
-	LikeCount      int       `json:"like_count,omitempty"`
-	IsLiked        bool      `json:"is_liked,omitempty"`        // Indicates if the current user liked this post
-	FollowingLikes []string  `json:"following_likes,omitempty"` // Indicates if the current user follows likes on this post
-}
+// Post is a post as the feed and profile pages list it.
+type Post = store.Post
 
-func CreatePostHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Require login (reads session cookie -> user id)
-		userID, err := GetUserIDFromRequest(r)
-		if err != nil || userID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
+const maxPostLen = 5000
 
-		if err := r.ParseMultipartForm(25 << 20); err != nil { // 25MB
-			writeErr(w, http.StatusBadRequest, "Error parsing form")
-			return
-		}
+// CreatePostForm is a new post. It needs content, an image or both; privacy
+// is public unless given, and custom needs at least one of custom_users[].
+type CreatePostForm struct {
+	Content     string                `form:"content"`
+	Privacy     string                `form:"privacy"`
+	Image       *multipart.FileHeader `form:"image"`
+	CustomUsers []string              `form:"custom_users[]"`
+}
 
-		content := r.FormValue("content")
-		privacy := r.FormValue("privacy")
-		if privacy == "" {
-			privacy = "public"
-		}
+func (s *Server) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
+	// Require login (reads session cookie -> user id)
+	userID := currentUserID(r)
 
-		// Optional image upload
-		var imagePath string
-		file, handler, err := r.FormFile("image")
-		if err == nil {
-			defer file.Close()
-
-			// Validate image
-            if err := validateImage(file, handler); err != nil {
-                writeErr(w, http.StatusBadRequest, err.Error())
-                return
-            }
-
-			uploadDir := filepath.Join(".", "uploads")
-			// Ensure uploads dir exists
-			if mkErr := os.MkdirAll(uploadDir, os.ModePerm); mkErr != nil {
-				writeErr(w, http.StatusInternalServerError, "Failed to prepare upload folder")
-				return
-			}
-
-			// Simple unique filename to avoid collisions
-			filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), path.Base(handler.Filename))
-			filepath := filepath.Join(uploadDir, filename)
-			dst, createErr := os.Create(filepath)
-			if createErr != nil {
-				writeErr(w, http.StatusInternalServerError, "Failed to save image")
-				return
-			}
-			defer dst.Close()
-
-			if _, copyErr := io.Copy(dst, file); copyErr != nil {
-				writeErr(w, http.StatusInternalServerError, "Failed to save image")
-				return
-			}
-			imagePath = filename
-		}
+	if err := parseForm(w, r); err != nil {
+		writeError(w, err)
+		return
+	}
+	var form CreatePostForm
+	v := &validation{}
+	v.form(r, &form)
+
+	content := form.Content
+	privacy := form.Privacy
+	if privacy == "" {
+		privacy = "public"
+	}
 
-		// Insert post (server-side userID from session)
-		stmt, err := db.Prepare(`INSERT INTO posts (user_id, content, image, privacy) VALUES (?, ?, ?, ?)`)
+	// Optional image upload
+	var file multipart.File
+	handler := form.Image
+	if handler != nil {
+		f, err := handler.Open()
 		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "Failed to prepare insert")
+			writeErr(w, http.StatusBadRequest, "Failed to read image")
 			return
 		}
-		defer stmt.Close()
+		defer f.Close()
+		file = f
+	}
 
-		result, err := stmt.Exec(userID, content, imagePath, privacy)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "Failed to insert post")
-			return
+	if strings.TrimSpace(content) == "" && handler == nil {
+		v.add("content", "required", "Write something or add an image")
+	}
+	v.maxLen("content", content, maxPostLen, "Content")
+	v.oneOf("privacy", privacy, "public", "followers", "custom")
+	if privacy == "custom" && len(form.CustomUsers) == 0 {
+		v.add("custom_users[]", "required", "Choose who can see this post")
+	}
+	if handler != nil {
+		if err := validateImage(file, handler); err != nil {
+			v.add("image", "invalid", err.Error())
 		}
+	}
+	if err := v.err(); err != nil {
+		writeError(w, err)
+		return
+	}
 
-		postID, err := result.LastInsertId()
+	var imagePath string
+	if handler != nil {
+		filename, err := saveUpload(file, handler)
 		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "Failed to get last insert ID")
+			reqLog(r).Error("save upload", "err", err)
+			writeErr(w, http.StatusInternalServerError, "Failed to save image")
 			return
 		}
+		imagePath = filename
+	}
 
-		// Custom visibility
-		if privacy == "custom" && r.MultipartForm != nil {
-			customUsers := r.MultipartForm.Value["custom_users[]"]
-			for _, targetUserID := range customUsers {
-				if _, err := db.Exec(
-					`INSERT INTO post_visibility (post_id, user_id) VALUES (?, ?)`,
-					postID, targetUserID,
-				); err != nil {
-					writeErr(w, http.StatusInternalServerError, "Failed to insert custom visibility")
-					return
-				}
-			}
-		}
-
-		writeJSON(w, http.StatusCreated, map[string]any{
-			"ok":      true,
-			"message": "Post created successfully",
-			"id":      postID,
-		})
+	// Insert post (server-side userID from session)
+	postID, err := s.Store.Posts.Create(r.Context(), store.NewPost{
+		UserID:  userID,
+		Content: content,
+		Image:   imagePath,
+		Privacy: privacy,
+		Viewers: form.CustomUsers,
+	})
+	if err != nil {
+		reqLog(r).Error("create post", "err", err)
+		writeErr(w, http.StatusInternalServerError, "Failed to insert post")
+		return
 	}
-}
 
-func GetAllUsersHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT id, nickname, first_name, last_name, avatar FROM users`)
-		if err != nil {
-			http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-
-		type UserSummary struct {
-			ID        string `json:"id"`
-			Nickname  string `json:"nickname"`
-			FirstName string `json:"firstName"`
-			LastName  string `json:"lastName"`
-			Avatar    string `json:"avatar"`
-		}
+	writeJSON(w, http.StatusCreated, CreatedResponse{OK: true, Message: "Post created successfully", ID: postID})
+}
 
-		var users []UserSummary
-		for rows.Next() {
-			var u UserSummary
-			if err := rows.Scan(&u.ID, &u.Nickname, &u.FirstName, &u.LastName, &u.Avatar); err != nil {
-				continue
-			}
-			users = append(users, u)
-		}
+// UserSummary is a user as lists of people show them.
+type UserSummary = store.UserSummary
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(users)
+func (s *Server) GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := s.Store.Users.List(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to fetch users")
+		return
 	}
-}
 
-func GetAllPostsHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		currentUserID, err := GetUserIDFromRequest(r)
-		if err != nil || currentUserID == "" {
-			writeErr(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-
-		rows, err := db.Query(`
-SELECT 
-    p.user_id,
-    p.id,
-    u.nickname,
-    u.first_name,
-    u.last_name,
-    u.avatar,
-    p.content,
-    p.image,
-    p.privacy,
-    p.created_at,
-    (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comment_count,
-    (SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id) AS like_count,
-    (SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id AND l.user_id = ?) AS is_liked,
-    (
-      SELECT GROUP_CONCAT(
-               CASE 
-                   WHEN u2.nickname != '' THEN u2.nickname 
-                   ELSE u2.first_name || ' ' || u2.last_name 
-               END, ', '
-             )
-      FROM likes l2
-      JOIN users u2 ON u2.id = l2.user_id
-      JOIN followers f ON f.following_id = u2.id
-      WHERE l2.post_id = p.id
-        AND f.follower_id = ?
-        AND f.status = 'accepted'
-    ) AS followed_likers
-FROM posts p
-JOIN users u ON u.id = p.user_id
-WHERE
-    -- Public posts: show to everyone
-    p.privacy = 'public'
-    
-    OR
-    
-    -- Follower-only posts: show if current user follows the post author
-    (
-        p.privacy = 'followers' 
-        AND EXISTS (
-            SELECT 1 FROM followers f
-            WHERE f.following_id = p.user_id 
-            AND f.follower_id = ?
-            AND f.status = 'accepted'
-        )
-    )
-    
-    OR
-    
-    -- Custom posts: show if current user is in the visibility list
-    (
-        p.privacy = 'custom'
-        AND EXISTS (
-            SELECT 1 FROM post_visibility pv
-            WHERE pv.post_id = p.id 
-            AND pv.user_id = ?
-        )
-    )
-    
-    OR
-    
-    -- Always show user's own posts
-    p.user_id = ?
-    
-ORDER BY p.created_at DESC;
-`, currentUserID, // is_liked
-			currentUserID, // followed_likers
-			currentUserID, // followers check
-			currentUserID, // custom visibility check
-			currentUserID) // own posts check
-		if err != nil {
-			http.Error(w, "Failed to query posts", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-
-		var posts []Post
-		for rows.Next() {
-			var post Post
-			var followedLikers sql.NullString
-			if err := rows.Scan(
-				&post.UserID,
-				&post.PostID,
-				&post.Nickname,
-				&post.FirstName,
-				&post.LastName,
-				&post.Avatar,
-				&post.Content,
-				&post.Image,
-				&post.Privacy,
-				&post.CreatedAt,
-				&post.CommentCount,
-				&post.LikeCount,
-				&post.IsLiked,
-				&followedLikers,
-			); err != nil {
-				http.Error(w, "Error scanning posts", http.StatusInternalServerError)
-				return
-			}
-			if followedLikers.Valid && followedLikers.String != "" {
-				post.FollowingLikes = strings.Split(followedLikers.String, ", ")
-			} else {
-				post.FollowingLikes = []string{}
-			}
-			posts = append(posts, post)
-		}
+	writeJSON(w, http.StatusOK, users)
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(posts)
+func (s *Server) GetAllPostsHandler(w http.ResponseWriter, r *http.Request) {
+	posts, err := s.Store.Posts.Feed(r.Context(), store.FeedQuery{ViewerID: currentUserID(r)})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to query posts")
+		return
 	}
+
+	writeJSON(w, http.StatusOK, posts)
 }
 
-func GetUserPostsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetUserPostsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	ctx := r.Context()
+	userID := currentUserID(r)
+	profileUserID := r.PathValue("id")
 
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 5 {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+	// Check user privacy setting
+	isPublic, err := s.Store.Users.IsPublic(ctx, profileUserID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "User not found")
 		return
 	}
-	profileUserID := pathParts[3]
-
-	// Check if user is viewing their own profile
-	isOwnProfile := userID == profileUserID
-
-	// Check user privacy setting
-	var isPublic bool
-	err = sqlite.DB.QueryRow(`
-		SELECT is_public FROM users WHERE id = ?
-	`, profileUserID).Scan(&isPublic)
-
 	if err != nil {
-		if err == sql.ErrNoRows {
-			writeErr(w, http.StatusNotFound, "User not found")
-		} else {
-			writeErr(w, http.StatusInternalServerError, "Database error")
-		}
+		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	// Check if the requesting user follows the profile user (if needed)
-	var followsUser bool
-	if !isPublic && !isOwnProfile {
-		err = sqlite.DB.QueryRow(`
-			SELECT EXISTS (
-			SELECT 1
-			FROM followers 
-			WHERE follower_id = ? 
-			AND following_id = ? 
-			AND status = 'accepted'
-			) 
-			
-		`, userID, profileUserID).Scan(&followsUser)
-
+	// A private profile only shows its posts to accepted followers
+	if !isPublic && userID != profileUserID {
+		followsUser, err := s.Store.Follows.IsFollowing(ctx, userID, profileUserID)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-
 		if !followsUser {
-			writeJSON(w, http.StatusForbidden, map[string]any{
-				"ok":      false,
-				"message": "must follow user to view their posts",
-			})
+			writeErr(w, http.StatusForbidden, "must follow user to view their posts")
 			return
 		}
 	}
 
-	// Query db for user's posts with privacy checks
-	rows, err := sqlite.DB.Query(`
-    SELECT 
-        p.user_id,
-        p.id,
-        u.nickname,
-        u.first_name,
-        u.last_name,
-        u.avatar,
-        p.content,
-        p.image,
-        p.privacy,
-        p.created_at,
-        (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comment_count,
-        (SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id) AS like_count,
-        (SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id AND l.user_id = ?) AS is_liked
-    FROM posts p
-    JOIN users u ON u.id = p.user_id
-    WHERE p.user_id = ?
-    AND (
-        -- Show all posts if viewing own profile
-        ? = 1
-        
-        OR
-        
-        -- Public posts: show to everyone
-        p.privacy = 'public'
-        
-        OR
-        
-        -- Follower-only posts: show if current user follows the post author
-        (
-            p.privacy = 'followers' 
-            AND EXISTS (
-                SELECT 1 FROM followers f
-                WHERE f.following_id = p.user_id 
-                AND f.follower_id = ?
-                AND f.status = 'accepted'
-            )
-        )
-        
-        OR
-        
-        -- Custom posts: show if current user is in the visibility list
-        (
-            p.privacy = 'custom'
-            AND EXISTS (
-                SELECT 1 FROM post_visibility pv
-                WHERE pv.post_id = p.id 
-                AND pv.user_id = ?
-            )
-        )
-    )
-    ORDER BY p.created_at DESC
-`, userID, // is_liked parameter (1st)
-		profileUserID, // posts by this user (2nd)
-		isOwnProfile,  // 1 if viewing own profile, 0 otherwise (3rd)
-		userID,        // follower check (4th) - This was missing!
-		userID)        // custom visibility check (5th)
+	// the same visibility rules as the feed, narrowed to this profile
+	posts, err := s.Store.Posts.Feed(ctx, store.FeedQuery{ViewerID: userID, AuthorID: profileUserID})
 	if err != nil {
-		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
+		writeErr(w, http.StatusInternalServerError, "Failed to fetch posts")
 		return
 	}
-	defer rows.Close()
-
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(
-			&post.UserID,
-			&post.PostID,
-			&post.Nickname,
-			&post.FirstName,
-			&post.LastName,
-			&post.Avatar,
-			&post.Content,
-			&post.Image,
-			&post.Privacy,
-			&post.CreatedAt,
-			&post.CommentCount,
-			&post.LikeCount,
-			&post.IsLiked,
-		); err != nil {
-			http.Error(w, "Error scanning posts", http.StatusInternalServerError)
-			return
-		}
-		posts = append(posts, post)
-	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	writeJSON(w, http.StatusOK, posts)
 }
 
 func validateImage(file multipart.File, handler *multipart.FileHeader) error {
-    // Check file size (25MB max)
-    if handler.Size > 25<<20 {
-        return fmt.Errorf("file size too large - maximum 25MB")
-    }
-
-    // Check file extension
-    ext := strings.ToLower(filepath.Ext(handler.Filename))
-    allowedExtensions := map[string]bool{
-        ".jpg":  true,
-        ".jpeg": true,
-        ".png":  true,
-        ".gif":  true,
-    }
-    if !allowedExtensions[ext] {
-        return fmt.Errorf("invalid file type. Only JPG, PNG, and GIF are allowed")
-    }
-
-    // Check MIME type by reading the first 512 bytes
-    buffer := make([]byte, 512)
-    _, err := file.Read(buffer)
-    if err != nil {
-        return fmt.Errorf("failed to read file for MIME type detection")
-    }
-
-    // Reset file pointer after reading
-    _, err = file.Seek(0, 0)
-    if err != nil {
-        return fmt.Errorf("failed to reset file pointer")
-    }
-
-    mimeType := http.DetectContentType(buffer)
-    allowedMimeTypes := map[string]bool{
-        "image/jpeg":      true,
-        "image/png":       true,
-        "image/gif":       true,
-    }
-
-    if !allowedMimeTypes[mimeType] {
-        return fmt.Errorf("invalid file format. Only JPEG, PNG, and GIF images are allowed")
-    }
-
-    // Additional check: ensure extension matches MIME type
-    if (ext == ".jpg" || ext == ".jpeg") && mimeType != "image/jpeg" {
-        return fmt.Errorf("file extension does not match file content")
-    }
-    if ext == ".png" && mimeType != "image/png" {
-        return fmt.Errorf("file extension does not match file content")
-    }
-    if ext == ".gif" && mimeType != "image/gif" {
-        return fmt.Errorf("file extension does not match file content")
-    }
-
-    return nil
+	// Check file size (25MB max)
+	if handler.Size > 25<<20 {
+		return fmt.Errorf("file size too large - maximum 25MB")
+	}
+
+	// Check file extension
+	ext := strings.ToLower(filepath.Ext(handler.Filename))
+	allowedExtensions := map[string]bool{
+		".jpg":  true,
+		".jpeg": true,
+		".png":  true,
+		".gif":  true,
+	}
+	if !allowedExtensions[ext] {
+		return fmt.Errorf("invalid file type. Only JPG, PNG, and GIF are allowed")
+	}
+
+	// Check MIME type by reading the first 512 bytes
+	buffer := make([]byte, 512)
+	_, err := file.Read(buffer)
+	if err != nil {
+		return fmt.Errorf("failed to read file for MIME type detection")
+	}
+
+	// Reset file pointer after reading
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to reset file pointer")
+	}
+
+	mimeType := http.DetectContentType(buffer)
+	allowedMimeTypes := map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+	}
+
+	if !allowedMimeTypes[mimeType] {
+		return fmt.Errorf("invalid file format. Only JPEG, PNG, and GIF images are allowed")
+	}
+
+	// Additional check: ensure extension matches MIME type
+	if (ext == ".jpg" || ext == ".jpeg") && mimeType != "image/jpeg" {
+		return fmt.Errorf("file extension does not match file content")
+	}
+	if ext == ".png" && mimeType != "image/png" {
+		return fmt.Errorf("file extension does not match file content")
+	}
+	if ext == ".gif" && mimeType != "image/gif" {
+		return fmt.Errorf("file extension does not match file content")
+	}
+
+	return nil
 }
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend/pkg/db/sqlite"
+)
+
+type ActivityIn struct {
+	State string `json:"state"` // "active", "idle" or "away"
+}
+
+var activityStatus = map[string]string{
+	"active": StatusOnline,
+	"idle":   StatusIdle,
+	"away":   StatusAway,
+}
+
+// handleActivity records whether this tab is being used; the user's status
+// is the most active of all their connections.
+func (s *Server) handleActivity(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in ActivityIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+	status, ok := activityStatus[in.State]
+	if !ok {
+		return nil, frameErr("bad_activity", "state must be active, idle or away")
+	}
+	s.Hub.SetStatus(client, status)
+	return nil, nil
+}
+
+// presenceContact is someone whose presence a user may see: they follow each
+// other in either direction (accepted) or share a group.
+type presenceContact struct {
+	ID       string
+	Hidden   bool
+	LastSeen sql.NullTime
+}
+
+func presenceContacts(db *sql.DB, userID string) ([]presenceContact, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.hide_presence, u.last_seen_at
+		FROM users u
+		WHERE u.id != ? AND u.id IN (
+			SELECT follower_id FROM followers WHERE following_id = ? AND status = 'accepted'
+			UNION
+			SELECT following_id FROM followers WHERE follower_id = ? AND status = 'accepted'
+			UNION
+			SELECT other.user_id
+			FROM group_members me
+			JOIN group_members other ON other.group_id = me.group_id AND other.status = 'accepted'
+			WHERE me.user_id = ? AND me.status = 'accepted'
+		)
+	`, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []presenceContact
+	for rows.Next() {
+		var c presenceContact
+		if err := rows.Scan(&c.ID, &c.Hidden, &c.LastSeen); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func presenceHidden(db *sql.DB, userID string) bool {
+	var hidden bool
+	if err := db.QueryRow(`SELECT hide_presence FROM users WHERE id = ?`, userID).Scan(&hidden); err != nil {
+		return true // unknown user: show nothing
+	}
+	return hidden
+}
+
+// PresenceAudience implements PresenceScope.
+func (s *Server) PresenceAudience(userID string) []string {
+	if presenceHidden(s.DB, userID) {
+		return nil
+	}
+	contacts, err := presenceContacts(s.DB, userID)
+	if err != nil {
+		return nil
+	}
+	ids := make([]string, len(contacts))
+	for i, c := range contacts {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// LastSeen implements PresenceScope.
+func (s *Server) LastSeen(userID string) string {
+	var (
+		hidden bool
+		seen   sql.NullTime
+	)
+	if err := s.DB.QueryRow(`SELECT hide_presence, last_seen_at FROM users WHERE id = ?`, userID).Scan(&hidden, &seen); err != nil {
+		return ""
+	}
+	return formatLastSeen(hidden, seen)
+}
+
+func formatLastSeen(hidden bool, seen sql.NullTime) string {
+	if hidden || !seen.Valid {
+		return ""
+	}
+	return seen.Time.UTC().Format(time.RFC3339)
+}
+
+func touchLastSeen(db *sql.DB, userID string) {
+	_, _ = db.Exec(`UPDATE users SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?`, userID)
+}
+
+// presenceSnapshot lists the user's contacts with their current status;
+// contacts hiding their presence are left out.
+func (s *Server) presenceSnapshot(userID string) PresenceSnapshotData {
+	snap := PresenceSnapshotData{Online: []string{}, Contacts: []PresenceData{}}
+	contacts, err := presenceContacts(s.DB, userID)
+	if err != nil {
+		return snap
+	}
+	for _, c := range contacts {
+		if c.Hidden {
+			continue
+		}
+		status := s.Hub.Status(c.ID)
+		p := PresenceData{UserID: c.ID, Online: status != StatusOffline, Status: status}
+		if p.Online {
+			snap.Online = append(snap.Online, c.ID)
+		} else {
+			p.LastSeenAt = formatLastSeen(false, c.LastSeen)
+		}
+		snap.Contacts = append(snap.Contacts, p)
+	}
+	return snap
+}
+
+// PresenceSetting is whether the user now hides their presence.
+type PresenceSetting struct {
+	OK           bool `json:"ok"`
+	HidePresence bool `json:"hide_presence"`
+}
+
+// POST /api/users/toggle-presence
+func ToggleHidePresenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := currentUserID(r)
+
+	// contacts are read before the flip so hiding can still reach them
+	contacts, err := presenceContacts(sqlite.DB, userID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	var hide bool
+	if err := sqlite.DB.QueryRow(`
+		UPDATE users SET hide_presence = NOT hide_presence WHERE id = ? RETURNING hide_presence
+	`, userID).Scan(&hide); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if WS != nil && WS.Hub != nil {
+		status := WS.Hub.Status(userID)
+		if hide {
+			// to everyone else they simply went offline
+			status = StatusOffline
+		}
+		data := PresenceData{UserID: userID, Online: status != StatusOffline, Status: status}
+		if !hide && status == StatusOffline {
+			data.LastSeenAt = WS.LastSeen(userID)
+		}
+		frame := newFrame("presence", data)
+		for _, c := range contacts {
+			WS.Hub.SendToUser(c.ID, frame)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, PresenceSetting{OK: true, HidePresence: hide})
+}
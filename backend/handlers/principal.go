@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+// Principal is the signed-in user a request acts for.
+type Principal struct {
+	UserID string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal put there by RequireAuth or OptionalAuth.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// requestPrincipal is the principal already resolved for r, or else the one
+// its session cookie names. ok is false for anonymous requests.
+func requestPrincipal(r *http.Request) (Principal, bool) {
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		return p, true
+	}
+	uid, err := GetUserIDFromRequest(r)
+	if err != nil || uid == "" {
+		return Principal{}, false
+	}
+	return Principal{UserID: uid}, true
+}
+
+// currentUserID is the signed-in user's id; "" outside RequireAuth for
+// anonymous requests.
+func currentUserID(r *http.Request) string {
+	p, _ := PrincipalFrom(r.Context())
+	return p.UserID
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	writeErr(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+// RequireAuth answers 401 unless the request has a valid session, and hands
+// next the principal in the request context.
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requestPrincipal(r)
+		if !ok {
+			writeUnauthorized(w)
+			return
+		}
+		noteUser(r, p.UserID)
+		next(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	}
+}
+
+// OptionalAuth resolves the session when there is one and lets anonymous
+// requests through unchanged.
+func OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			if p, ok := requestPrincipal(r); ok {
+				noteUser(r, p.UserID)
+				r = r.WithContext(WithPrincipal(r.Context(), p))
+			}
+		}
+		next(w, r)
+	}
+}
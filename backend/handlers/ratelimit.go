@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"backend/pkg/ratelimit"
+)
+
+// DefaultRateLimits are the per-action limits; HTTP actions are keyed by
+// user id, or by client IP when the request isn't logged in. WS actions are
+// the frame types.
+func DefaultRateLimits() map[string]ratelimit.Limit {
+	return map[string]ratelimit.Limit{
+		// HTTP
+		"register":   ratelimit.Every(5, time.Hour),
+		"login":      ratelimit.Every(10, 5*time.Minute),
+		"post":       ratelimit.Every(10, time.Minute),
+		"comment":    ratelimit.Every(30, time.Minute),
+		"like":       ratelimit.Every(60, time.Minute),
+		"attachment": ratelimit.Every(30, time.Minute),
+		"password":   ratelimit.Every(10, time.Hour),
+		"reset":      ratelimit.Every(5, time.Hour),
+		"email":      ratelimit.Every(5, time.Hour),
+
+		// WebSocket
+		"dm":            ratelimit.Limit{N: 20, Per: 10 * time.Second, Burst: 30},
+		"group_message": ratelimit.Limit{N: 20, Per: 10 * time.Second, Burst: 30},
+		"typing":        ratelimit.Every(10, 5*time.Second),
+		"group_typing":  ratelimit.Every(1, 2*time.Second), // keyed by user and group
+		"reaction":      ratelimit.Every(30, 10*time.Second),
+		"activity":      ratelimit.Every(10, 10*time.Second),
+		"resume":        ratelimit.Every(20, time.Minute),
+	}
+}
+
+// retryAfterSeconds rounds up so clients never retry too early.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	writeError(w, &APIError{
+		Status:     http.StatusTooManyRequests,
+		Code:       CodeRateLimited,
+		Message:    "Too many requests, slow down",
+		RetryAfter: retryAfter,
+	})
+}
+
+// RateLimited limits the write methods of next under action. Reads and CORS
+// preflights pass through.
+func RateLimited(l *ratelimit.Limiter, action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next(w, r)
+			return
+		}
+		key := "ip:" + clientIP(r)
+		if p, ok := requestPrincipal(r); ok {
+			key = "user:" + p.UserID
+		}
+		if ok, wait := l.Allow(action, key); !ok {
+			writeRateLimited(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP is the peer address; we don't trust X-Forwarded-For since nothing
+// in front of the backend sets it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"unicode"
+	"unicode/utf8"
+
+	"backend/pkg/store"
+)
+
+type ReactionIn struct {
+	Kind      string `json:"kind"` // "dm" or "group"
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReactionSummary is one emoji on a message with everyone who used it.
+type ReactionSummary = store.ReactionSummary
+
+// ReactionEvent is pushed to everyone in the conversation when a reaction is toggled.
+type ReactionEvent struct {
+	Kind      string            `json:"kind"`
+	MessageID string            `json:"message_id"`
+	GroupID   string            `json:"group_id"`
+	UserID    string            `json:"user_id"`
+	Emoji     string            `json:"emoji"`
+	Added     bool              `json:"added"`
+	Reactions []ReactionSummary `json:"reactions"`
+}
+
+// validEmoji is a loose check: short, no whitespace and no plain ASCII letters,
+// so reactions can't be used as a second text channel.
+func validEmoji(s string) bool {
+	if s == "" || len(s) > 32 || utf8.RuneCountInString(s) > 10 {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || (r < unicode.MaxASCII && unicode.IsLetter(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionAudience returns the users allowed to see the message (both DM
+// participants or the accepted group members) and the group id for group messages.
+func (s *Server) reactionAudience(kind, messageID string) (audience []string, groupID string, err error) {
+	switch kind {
+	case "dm":
+		var from, to string
+		if err := s.DB.QueryRow(`SELECT sender_id, receiver_id FROM messages WHERE id = ?`, messageID).Scan(&from, &to); err != nil {
+			return nil, "", err
+		}
+		return []string{from, to}, "", nil
+	case "group":
+		if err := s.DB.QueryRow(`SELECT group_id FROM group_chat WHERE id = ?`, messageID).Scan(&groupID); err != nil {
+			return nil, "", err
+		}
+		members, err := s.getGroupMembers(groupID)
+		return members, groupID, err
+	default:
+		return nil, "", fmt.Errorf("unknown message kind %q", kind)
+	}
+}
+
+// handleReaction toggles an emoji on a DM or group message and pushes the new
+// totals to everyone in the conversation.
+func (s *Server) handleReaction(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in ReactionIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+	if (in.Kind != "dm" && in.Kind != "group") || in.MessageID == "" || !validEmoji(in.Emoji) {
+		return nil, frameErr("bad_reaction", "kind, message_id and a single emoji are required")
+	}
+
+	audience, groupID, err := s.reactionAudience(in.Kind, in.MessageID)
+	if err == sql.ErrNoRows || (err == nil && !slices.Contains(audience, userID)) {
+		return nil, frameErr("not_found", "message not found")
+	}
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+
+	added, err := s.Store.Messages.ToggleReaction(context.Background(), in.Kind, in.MessageID, userID, in.Emoji)
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+	byMsg, err := s.Store.Messages.Reactions(context.Background(), in.Kind, []string{in.MessageID})
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
+
+	out := ReactionEvent{
+		Kind:      in.Kind,
+		MessageID: in.MessageID,
+		GroupID:   groupID,
+		UserID:    userID,
+		Emoji:     in.Emoji,
+		Added:     added,
+		Reactions: byMsg[in.MessageID],
+	}
+	frame := newFrame("reaction", out)
+	for _, uid := range audience {
+		s.Hub.SendToUser(uid, frame)
+	}
+	return out, nil
+}
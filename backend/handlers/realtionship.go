@@ -5,17 +5,32 @@ import (
 	"net/http"
 )
 
+// Relationship is how the caller and one other user follow each other.
+type Relationship struct {
+	IFollow   bool `json:"iFollow"`
+	FollowsMe bool `json:"followsMe"`
+}
+
+// RelationshipsResponse maps user ids to the relationship with each.
+type RelationshipsResponse struct {
+	OK            bool                    `json:"ok"`
+	Relationships map[string]Relationship `json:"relationships"`
+}
+
+// PostAudienceResponse maps the caller's followers, who can be picked as a
+// post's custom audience.
+type PostAudienceResponse struct {
+	OK                   bool                    `json:"ok"`
+	RelationshipsForPost map[string]Relationship `json:"relationshipsForPost"`
+}
+
 func GetRelationships(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
         writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
         return
     }
 
-    me, err := GetUserIDFromRequest(r)
-    if err != nil || me == "" {
-        writeErr(w, http.StatusUnauthorized, "Unauthorized")
-        return
-    }
+    me := currentUserID(r)
 
     rows, err := sqlite.DB.Query(`
         SELECT following_id AS uid, 1 AS iFollow, 0 AS followsMe
@@ -32,26 +47,22 @@ func GetRelationships(w http.ResponseWriter, r *http.Request) {
     }
     defer rows.Close()
 
-    rels := map[string]map[string]bool{}
+    rels := map[string]Relationship{}
     for rows.Next() {
         var uid string
         var iFollow, followsMe int
         rows.Scan(&uid, &iFollow, &followsMe)
-        if _, ok := rels[uid]; !ok {
-            rels[uid] = map[string]bool{"iFollow": false, "followsMe": false}
-        }
+        rel := rels[uid]
         if iFollow == 1 {
-            rels[uid]["iFollow"] = true
+            rel.IFollow = true
         }
         if followsMe == 1 {
-            rels[uid]["followsMe"] = true
+            rel.FollowsMe = true
         }
+        rels[uid] = rel
     }
 
-    writeJSON(w, http.StatusOK, map[string]any{
-        "ok":            true,
-        "relationships": rels,
-    })
+    writeJSON(w, http.StatusOK, RelationshipsResponse{OK: true, Relationships: rels})
 }
 func GetRelationshipsForPost(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
@@ -59,11 +70,7 @@ func GetRelationshipsForPost(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    me, err := GetUserIDFromRequest(r)
-    if err != nil || me == "" {
-        writeErr(w, http.StatusUnauthorized, "Unauthorized")
-        return
-    }
+    me := currentUserID(r)
 
     // Only get followers (users who follow 'me')
     rows, err := sqlite.DB.Query(`
@@ -77,19 +84,13 @@ func GetRelationshipsForPost(w http.ResponseWriter, r *http.Request) {
     }
     defer rows.Close()
 
-    rels := map[string]map[string]bool{}
+    rels := map[string]Relationship{}
     for rows.Next() {
         var uid string
         var iFollow, followsMe int
         rows.Scan(&uid, &iFollow, &followsMe)
-        rels[uid] = map[string]bool{
-            "iFollow": false,
-            "followsMe": followsMe == 1,
-        }
+        rels[uid] = Relationship{FollowsMe: followsMe == 1}
     }
 
-    writeJSON(w, http.StatusOK, map[string]any{
-        "ok":            true,
-        "relationshipsForPost": rels,
-    })
+    writeJSON(w, http.StatusOK, PostAudienceResponse{OK: true, RelationshipsForPost: rels})
 }
\ No newline at end of file
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"backend/pkg/store"
+)
+
+// ReplySnippet is the quoted parent shown above a reply.
+type ReplySnippet struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	Text string `json:"text"`
+}
+
+const replySnippetLen = 120
+
+func snippet(text string) string {
+	runes := []rune(text)
+	if len(runes) <= replySnippetLen {
+		return text
+	}
+	return string(runes[:replySnippetLen]) + "…"
+}
+
+// chatTable maps a message kind to the table holding it.
+func chatTable(kind string) (string, error) {
+	switch kind {
+	case "dm":
+		return "messages", nil
+	case "group":
+		return "group_chat", nil
+	default:
+		return "", fmt.Errorf("unknown message kind %q", kind)
+	}
+}
+
+// checkReplyTo makes sure the parent message lives in the same conversation:
+// between userID and scope for DMs, or inside group scope for group chat.
+func checkReplyTo(db *sql.DB, kind, replyTo, userID, scope string) error {
+	if replyTo == "" {
+		return nil
+	}
+
+	var ok bool
+	var err error
+	switch kind {
+	case "dm":
+		err = db.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM messages
+				WHERE id = ?
+				  AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))
+			)
+		`, replyTo, userID, scope, scope, userID).Scan(&ok)
+	case "group":
+		err = db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM group_chat WHERE id = ? AND group_id = ?)
+		`, replyTo, scope).Scan(&ok)
+	default:
+		return fmt.Errorf("unknown message kind %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("reply_to is not a message in this conversation")
+	}
+	return nil
+}
+
+// replySnippetOf quotes m above a reply to it.
+func replySnippetOf(m store.Message) *ReplySnippet {
+	return &ReplySnippet{ID: m.ID, From: m.SenderID, Text: snippet(m.Content)}
+}
+
+// replySnippet is the single-message version used when sending.
+func (s *Server) replySnippet(kind, parentID string) *ReplySnippet {
+	if parentID == "" {
+		return nil
+	}
+	byID, err := s.Store.Messages.Parents(context.Background(), kind, []string{parentID})
+	if err != nil {
+		return nil
+	}
+	m, ok := byID[parentID]
+	if !ok {
+		return nil
+	}
+	return replySnippetOf(m)
+}
+
+func nullableID(id string) any {
+	if id == "" {
+		return nil
+	}
+	return id
+}
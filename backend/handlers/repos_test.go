@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"backend/pkg/store"
+	"backend/pkg/store/memstore"
+)
+
+// useMemstore returns a fresh in-memory store and a server backed by it.
+func useMemstore(t *testing.T) (*memstore.DB, *Server) {
+	t.Helper()
+	db := memstore.New()
+	return db, &Server{Hub: NewHub(), Store: db.Store()}
+}
+
+// serve calls h as userID, the way RequireAuth would.
+func serve(h http.HandlerFunc, userID, method, target string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	r = r.WithContext(WithPrincipal(r.Context(), Principal{UserID: userID}))
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func postForm(h http.HandlerFunc, userID string, form url.Values) *httptest.ResponseRecorder {
+	return serve(h, userID, http.MethodPost, "/", strings.NewReader(form.Encode()), "application/x-www-form-urlencoded")
+}
+
+func postJSON(h http.HandlerFunc, userID string, v any) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(v)
+	return serve(h, userID, http.MethodPost, "/", strings.NewReader(string(b)), "application/json")
+}
+
+func get(h http.HandlerFunc, userID, target string) *httptest.ResponseRecorder {
+	return serve(h, userID, http.MethodGet, target, nil, "")
+}
+
+func wantStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body)
+	}
+}
+
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return v
+}
+
+func notificationTypes(db *memstore.DB, userID string) []string {
+	var types []string
+	for _, n := range db.NotificationsFor(userID) {
+		types = append(types, n.Type)
+	}
+	return types
+}
+
+func TestFeedFollowsPrivacy(t *testing.T) {
+	db, s := useMemstore(t)
+	alice := db.AddUser(store.UserSummary{FirstName: "Alice"}, true)
+	bob := db.AddUser(store.UserSummary{FirstName: "Bob"}, true)
+	carol := db.AddUser(store.UserSummary{FirstName: "Carol"}, true)
+
+	for _, f := range []url.Values{
+		{"content": {"for everyone"}},
+		{"content": {"for followers"}, "privacy": {"followers"}},
+		{"content": {"for carol"}, "privacy": {"custom"}, "custom_users[]": {carol}},
+	} {
+		wantStatus(t, postForm(s.CreatePostHandler, alice, f), http.StatusCreated)
+	}
+	wantStatus(t, postForm(s.CreatePostHandler, alice, url.Values{"content": {" "}}), http.StatusUnprocessableEntity)
+
+	feed := func(viewer string) string {
+		var contents []string
+		for _, p := range decodeBody[[]Post](t, get(s.GetAllPostsHandler, viewer, "/api/posts")) {
+			contents = append(contents, p.Content)
+		}
+		return strings.Join(contents, ", ")
+	}
+	if got := feed(bob); got != "for everyone" {
+		t.Errorf("bob sees %q", got)
+	}
+	if got := feed(carol); got != "for carol, for everyone" {
+		t.Errorf("carol sees %q", got)
+	}
+
+	wantStatus(t, postJSON(s.FollowUser, bob, FollowRequest{FollowingID: alice}), http.StatusOK)
+	if got := feed(bob); got != "for followers, for everyone" {
+		t.Errorf("bob sees %q after following", got)
+	}
+	if got := feed(alice); got != "for carol, for followers, for everyone" {
+		t.Errorf("alice sees %q of her own posts", got)
+	}
+}
+
+func TestFollowPrivateAccount(t *testing.T) {
+	db, s := useMemstore(t)
+	alice := db.AddUser(store.UserSummary{FirstName: "Alice"}, true)
+	dave := db.AddUser(store.UserSummary{FirstName: "Dave"}, false)
+
+	status := func() string {
+		w := get(s.GetFollowStatus, alice, "/api/users/follow-status?following_id="+dave)
+		wantStatus(t, w, http.StatusOK)
+		return decodeBody[map[string]any](t, w)["status"].(string)
+	}
+	if got := status(); got != "not_following" {
+		t.Fatalf("status %q before following", got)
+	}
+
+	w := postJSON(s.FollowUser, alice, FollowRequest{FollowingID: dave})
+	wantStatus(t, w, http.StatusOK)
+	if got := decodeBody[map[string]any](t, w)["status"]; got != store.FollowPending {
+		t.Fatalf("follow of a private account is %v", got)
+	}
+	if got := fmt.Sprint(notificationTypes(db, dave)); got != "[follow_request]" {
+		t.Errorf("dave's notifications %s", got)
+	}
+	wantStatus(t, postJSON(s.FollowUser, alice, FollowRequest{FollowingID: dave}), http.StatusConflict)
+	wantStatus(t, postJSON(s.FollowUser, alice, FollowRequest{FollowingID: alice}), http.StatusBadRequest)
+	wantStatus(t, postJSON(s.FollowUser, alice, FollowRequest{FollowingID: "nobody"}), http.StatusNotFound)
+
+	// only the account owner can answer
+	wantStatus(t, postJSON(s.RespondToFollowRequest, alice, RespondFollowRequest{FollowerID: dave, Action: "accept"}), http.StatusNotFound)
+	wantStatus(t, postJSON(s.RespondToFollowRequest, dave, RespondFollowRequest{FollowerID: alice, Action: "accept"}), http.StatusOK)
+	if got := status(); got != store.FollowAccepted {
+		t.Errorf("status %q after accepting", got)
+	}
+	if got := fmt.Sprint(notificationTypes(db, alice)); got != "[follow_request.update]" {
+		t.Errorf("alice's notifications %s", got)
+	}
+}
+
+func TestGroupInviteAndEvents(t *testing.T) {
+	db, s := useMemstore(t)
+	alice := db.AddUser(store.UserSummary{FirstName: "Alice"}, true)
+	bob := db.AddUser(store.UserSummary{FirstName: "Bob"}, true)
+	carol := db.AddUser(store.UserSummary{FirstName: "Carol"}, true)
+
+	w := postForm(s.CreateGroup, alice, url.Values{
+		"title": {"Hikers"}, "description": {"Weekend walks"}, "members": {`["` + bob + `"]`},
+	})
+	wantStatus(t, w, http.StatusCreated)
+	groupID := fmt.Sprint(decodeBody[map[string]any](t, w)["groupID"])
+	if got := fmt.Sprint(notificationTypes(db, bob)); got != "[group_invite]" {
+		t.Errorf("bob's notifications %s", got)
+	}
+
+	event := url.Values{
+		"group_id":           {groupID},
+		"event_title":        {"Ridge walk"},
+		"event_description":  {"Bring water"},
+		"event_day_and_time": {"2030-05-04T09:30"},
+		"timezone":           {"Europe/Paris"},
+	}
+	// an invite isn't membership yet
+	wantStatus(t, postForm(s.CreateEvent, bob, event), http.StatusForbidden)
+	wantStatus(t, postForm(s.RespondToInvite, carol, url.Values{"group_id": {groupID}, "response": {"accept"}}), http.StatusNotFound)
+	wantStatus(t, postForm(s.RespondToInvite, bob, url.Values{"group_id": {groupID}, "response": {"accept"}}), http.StatusOK)
+	if got := fmt.Sprint(notificationTypes(db, alice)); got != "[group_invite.accepted]" {
+		t.Errorf("alice's notifications %s", got)
+	}
+
+	wantStatus(t, postForm(s.CreateEvent, bob, event), http.StatusCreated)
+	wantStatus(t, postForm(s.CreateEvent, carol, event), http.StatusForbidden)
+	if got := fmt.Sprint(notificationTypes(db, alice)); got != "[group_invite.accepted group_event_created]" {
+		t.Errorf("alice's notifications %s", got)
+	}
+
+	type eventRow struct {
+		ID            int     `json:"id"`
+		Title         string  `json:"title"`
+		Datetime      string  `json:"datetime"`
+		GoingCount    int     `json:"going_count"`
+		NotGoingCount int     `json:"not_going_count"`
+		UserResponse  *string `json:"user_response"`
+	}
+	events := func(userID, timezone string) []eventRow {
+		w := get(s.GetEventsForGroup, userID, "/api/group/events?group_id="+groupID+"&timezone="+timezone)
+		wantStatus(t, w, http.StatusOK)
+		return decodeBody[struct{ Events []eventRow }](t, w).Events
+	}
+	evs := events(alice, "UTC")
+	if len(evs) != 1 || evs[0].Title != "Ridge walk" || evs[0].Datetime != "2030-05-04 07:30" || evs[0].UserResponse != nil {
+		t.Fatalf("events %+v", evs)
+	}
+	if got := events(alice, "Europe/Paris")[0].Datetime; got != "2030-05-04 09:30" {
+		t.Errorf("datetime in Paris %q", got)
+	}
+	wantStatus(t, get(s.GetEventsForGroup, carol, "/api/group/events?group_id="+groupID), http.StatusForbidden)
+
+	vote := func(userID, response string) string {
+		w := postForm(s.VoteToEvent, userID, url.Values{
+			"group_id": {groupID}, "event_id": {fmt.Sprint(evs[0].ID)}, "response": {response},
+		})
+		wantStatus(t, w, http.StatusOK)
+		return decodeBody[map[string]any](t, w)["message"].(string)
+	}
+	if got := vote(alice, "I'll be there"); got != "you successfully voted: I'll be there" {
+		t.Errorf("first vote: %q", got)
+	}
+	if got := vote(alice, "Can't make it"); got != "you successfully updated your vote to: Can't make it" {
+		t.Errorf("second vote: %q", got)
+	}
+	vote(bob, "I'll be there")
+	ev := events(alice, "UTC")[0]
+	if ev.GoingCount != 1 || ev.NotGoingCount != 1 || ev.UserResponse == nil || *ev.UserResponse != "Can't make it" {
+		t.Errorf("after voting %+v", ev)
+	}
+}
+
+func TestMarkGroupRead(t *testing.T) {
+	db, s := useMemstore(t)
+	alice := db.AddUser(store.UserSummary{FirstName: "Alice"}, true)
+	bob := db.AddUser(store.UserSummary{FirstName: "Bob"}, true)
+
+	w := postForm(s.CreateGroup, alice, url.Values{
+		"title": {"Hikers"}, "description": {"Weekend walks"}, "members": {`["` + bob + `"]`},
+	})
+	wantStatus(t, w, http.StatusCreated)
+	groupID := fmt.Sprint(decodeBody[map[string]any](t, w)["groupID"])
+	wantStatus(t, postForm(s.RespondToInvite, bob, url.Values{"group_id": {groupID}, "response": {"accept"}}), http.StatusOK)
+	for _, text := range []string{"one", "two"} {
+		if _, _, err := s.Store.Messages.CreateGroupMessage(context.Background(), store.Message{SenderID: alice, GroupID: groupID, Content: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(messageID string) *httptest.ResponseRecorder {
+		return postForm(s.MarkGroupMessagesRead, bob, url.Values{"group_id": {groupID}, "message_id": {messageID}})
+	}
+	for _, bad := range []string{"abc", "0", "-3", "99"} {
+		wantStatus(t, read(bad), http.StatusBadRequest)
+	}
+	unread := func(w *httptest.ResponseRecorder) float64 {
+		wantStatus(t, w, http.StatusOK)
+		return decodeBody[map[string]any](t, w)["unread"].(float64)
+	}
+	if n := unread(read("1")); n != 1 {
+		t.Errorf("%v unread after reading the first message", n)
+	}
+	if n := unread(read("")); n != 0 {
+		t.Errorf("%v unread after reading everything", n)
+	}
+	if n := unread(read("1")); n != 0 {
+		t.Errorf("marker moved back: %v unread", n)
+	}
+}
+
+func TestHistoryExtras(t *testing.T) {
+	db, s := useMemstore(t)
+	ctx := context.Background()
+	alice := db.AddUser(store.UserSummary{FirstName: "Alice"}, true)
+	bob := db.AddUser(store.UserSummary{FirstName: "Bob"}, true)
+
+	first, _, err := s.Store.Messages.CreateDM(ctx, store.Message{SenderID: alice, ReceiverID: bob, Content: "lunch?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := db.AddUpload(bob, store.Attachment{Name: "menu.pdf", MimeType: "application/pdf", Size: 1024})
+	reply := store.Message{SenderID: bob, ReceiverID: alice, Content: "here's the menu", ReplyTo: first, Attachments: []string{upload}}
+	if _, _, err := s.Store.Messages.CreateDM(ctx, reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Store.Messages.CreateDM(ctx, reply); !errors.Is(err, store.ErrAttachmentUnavailable) {
+		t.Errorf("sending the upload twice: %v", err)
+	}
+	for _, r := range []struct{ userID, emoji string }{{bob, "👍"}, {alice, "🎉"}, {alice, "👍"}, {alice, "🎉"}} {
+		if _, err := s.Store.Messages.ToggleReaction(ctx, "dm", first, r.userID, r.emoji); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := get(s.HistoryHandler, alice, "/api/messages?peer_id="+bob)
+	wantStatus(t, w, http.StatusOK)
+	rows := decodeBody[[]historyRow](t, w)
+	if len(rows) != 2 {
+		t.Fatalf("%d messages", len(rows))
+	}
+	if got := fmt.Sprint(rows[0].Reactions); got != "[{👍 2 ["+bob+" "+alice+"]}]" {
+		t.Errorf("reactions %s", got)
+	}
+	if got := rows[1].ReplyTo; got == nil || *got != (ReplySnippet{ID: first, From: alice, Text: "lunch?"}) {
+		t.Errorf("reply_to %+v", got)
+	}
+	if got := rows[1].Attachments; len(got) != 1 || got[0].Name != "menu.pdf" || got[0].URL != attachmentURL(upload) {
+		t.Errorf("attachments %+v", got)
+	}
+
+	wantStatus(t, get(s.HistoryHandler, alice, "/api/messages"), http.StatusUnprocessableEntity)
+	wantStatus(t, get(s.HistoryHandler, alice, "/api/messages?peer_id="+alice), http.StatusBadRequest)
+}
+
+func TestGroupMessagesExtras(t *testing.T) {
+	db, s := useMemstore(t)
+	ctx := context.Background()
+	alice := db.AddUser(store.UserSummary{FirstName: "Alice", Nickname: "al"}, true)
+	bob := db.AddUser(store.UserSummary{FirstName: "Bob"}, true)
+	carol := db.AddUser(store.UserSummary{FirstName: "Carol"}, true)
+
+	id, _, err := s.Store.Groups.Create(ctx, store.Group{Title: "Hikers", CreatorID: alice}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupID := fmt.Sprint(id)
+	if err := s.Store.Groups.AddMember(ctx, groupID, bob, store.MemberAccepted); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("é", replySnippetLen+5)
+	parent, _, err := s.Store.Messages.CreateGroupMessage(ctx, store.Message{SenderID: alice, GroupID: groupID, Content: long})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _, err := s.Store.Messages.CreateGroupMessage(ctx, store.Message{SenderID: bob, GroupID: groupID, Content: "same", ReplyTo: parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Store.Messages.ToggleReaction(ctx, "group", reply, alice, "❤️"); err != nil {
+		t.Fatal(err)
+	}
+	// the same id in the other kind is a different message
+	if _, err := s.Store.Messages.ToggleReaction(ctx, "dm", parent, bob, "👎"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := get(s.GetGroupMessages, bob, "/api/group/messages?group_id="+groupID)
+	wantStatus(t, w, http.StatusOK)
+	msgs := decodeBody[GroupMessagesResponse](t, w).Messages
+	if len(msgs) != 2 {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	if msgs[0].FirstName != "Alice" || msgs[0].Nickname != "al" || msgs[0].Reactions != nil {
+		t.Errorf("first message %+v", msgs[0])
+	}
+	want := ReplySnippet{ID: parent, From: alice, Text: strings.Repeat("é", replySnippetLen) + "…"}
+	if got := msgs[1].ReplyTo; got == nil || *got != want {
+		t.Errorf("reply_to %+v", got)
+	}
+	if got := fmt.Sprint(msgs[1].Reactions); got != "[{❤️ 1 ["+alice+"]}]" {
+		t.Errorf("reactions %s", got)
+	}
+
+	wantStatus(t, get(s.GetGroupMessages, carol, "/api/group/messages?group_id="+groupID), http.StatusForbidden)
+}
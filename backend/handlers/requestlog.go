@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"backend/pkg/logging"
+)
+
+// accessRecorder captures what the access log reports about a response. It
+// sits under every handler, websockets included, so it has to pass Hijack
+// and Flush through to the real writer.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	userID string // filled in once the session is resolved
+	err    error  // internal error behind a 500, from writeError
+}
+
+func (rec *accessRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *accessRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (rec *accessRecorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
+
+// probeRoutes are polled by orchestrators and scrapers every few seconds;
+// their access lines are only logged at debug level.
+var probeRoutes = map[string]bool{
+	"GET /healthz":       true,
+	"GET /healthz/live":  true,
+	"GET /healthz/ready": true,
+	"GET /metrics":       true,
+}
+
+type accessKey struct{}
+
+// recorderFrom finds the access recorder for a request, if it's logged.
+func recorderFrom(r *http.Request) *accessRecorder {
+	rec, _ := r.Context().Value(accessKey{}).(*accessRecorder)
+	return rec
+}
+
+// noteUser puts the signed-in user on the access log entry.
+func noteUser(r *http.Request, userID string) {
+	if rec := recorderFrom(r); rec != nil {
+		rec.userID = userID
+	}
+}
+
+// noteError keeps the cause of a 500 for the access log line.
+func noteError(w http.ResponseWriter, err error) bool {
+	for {
+		switch v := w.(type) {
+		case *accessRecorder:
+			v.err = err
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// reqLog is the logger for anything logged while serving r.
+func reqLog(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
+
+// AccessLog gives each request an id, echoed in X-Request-ID (a sane id sent
+// by the client is kept), and logs one line per request once it's done.
+// Websocket connections are logged when they close.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+
+		rec := &accessRecorder{ResponseWriter: w}
+		ctx := logging.WithRequestID(r.Context(), id)
+		r = r.WithContext(context.WithValue(ctx, accessKey{}, rec))
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		observeRequest(r.Method, r.Pattern, rec.status, elapsed.Seconds())
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case probeRoutes[r.Pattern]:
+			level = slog.LevelDebug
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", r.Pattern),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", elapsed),
+			slog.String("ip", clientIP(r)),
+		}
+		if rec.userID != "" {
+			attrs = append(attrs, slog.String("user_id", rec.userID))
+		}
+		if rec.err != nil {
+			attrs = append(attrs, slog.String("error", rec.err.Error()))
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
@@ -1,102 +1,86 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"net"
 	"net/http"
-	"net/url"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	"backend/pkg/db/sqlite"
+	"backend/pkg/ratelimit"
+	"backend/pkg/store"
 
 	"github.com/gorilla/websocket"
 )
 
 type GroupMsgIn struct {
-	GroupID string `json:"group_id"`
-	Text    string `json:"text"`
+	GroupID     string   `json:"group_id"`
+	Text        string   `json:"text"`
+	Attachments []string `json:"attachments,omitempty"` // ids from /api/chat/attachments
+	ReplyTo     string   `json:"reply_to,omitempty"`
+	ClientMsgID string   `json:"client_msg_id,omitempty"` // idempotency key, unique per sender
 }
 
 type GroupMsgOut struct {
-	ID      string `json:"id"`
-	From    string `json:"from"`
-	GroupID string `json:"group_id"`
-	Text    string `json:"text"`
-	TS      string `json:"ts"` // RFC3339
+	ID          string        `json:"id"`
+	From        string        `json:"from"`
+	GroupID     string        `json:"group_id"`
+	Text        string        `json:"text"`
+	TS          string        `json:"ts"` // RFC3339
+	Attachments []Attachment  `json:"attachments,omitempty"`
+	ReplyTo     *ReplySnippet `json:"reply_to,omitempty"`
+	ClientMsgID string        `json:"client_msg_id,omitempty"`
 }
 
 type Server struct {
 	Hub               *Hub
 	DB                *sql.DB
+	Store             *store.Store // SQLite in main, memstore in handler tests
 	UserIDFromRequest func(*http.Request) (string, error)
-}
-type wsConn struct {
-	conn    *websocket.Conn
-	userID  string
-	srv     *Server
-	writeMu sync.Mutex
-}
+	Limits            *ratelimit.Limiter // nil disables rate limiting
 
-func (c *wsConn) UserID() string { return c.userID }
-func (c *wsConn) SendJSON(v any) error {
-	c.writeMu.Lock()
-	defer c.writeMu.Unlock()
-	return c.conn.WriteJSON(v)
+	closing atomic.Bool // set by Shutdown; new upgrades are refused
 }
-func (c *wsConn) Close() error { return c.conn.Close() }
 
+// upgrader only accepts handshakes from the trusted frontends (or this server
+// itself); the socket is authenticated by cookie, so this is its CSRF check.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			return true
-		}
-		u, err := url.Parse(origin)
-		if err != nil {
-			return false
-		}
-
-		hostOnly := r.Host
-		if h, _, err := net.SplitHostPort(r.Host); err == nil {
-			hostOnly = h
-		}
-		originHost := u.Hostname()
-		return originHost == hostOnly
-	},
-}
-
-type Envelope struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+	CheckOrigin:     trustedRequest,
 }
 
 type DMIn struct {
-	To   string `json:"to"`
-	Text string `json:"text"`
+	To          string   `json:"to"`
+	Text        string   `json:"text"`
+	Attachments []string `json:"attachments,omitempty"` // ids from /api/chat/attachments
+	ReplyTo     string   `json:"reply_to,omitempty"`
+	ClientMsgID string   `json:"client_msg_id,omitempty"` // idempotency key, unique per sender
 }
 
 type DMOut struct {
-	ID   string `json:"id"`
-	From string `json:"from"`
-	To   string `json:"to"`
-	Text string `json:"text"`
-	TS   string `json:"ts"` // RFC3339
-}
-
-func errPayload(code, msg string) map[string]any {
-	return map[string]any{"type": "error", "data": map[string]string{"code": code, "message": msg}}
+	ID          string        `json:"id"`
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Text        string        `json:"text"`
+	TS          string        `json:"ts"` // RFC3339
+	Attachments []Attachment  `json:"attachments,omitempty"`
+	ReplyTo     *ReplySnippet `json:"reply_to,omitempty"`
+	ClientMsgID string        `json:"client_msg_id,omitempty"`
 }
 
 func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.UserIDFromRequest(r)
 	if err != nil || userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeErr(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	noteUser(r, userID)
+	if s.closing.Load() {
+		w.Header().Set("Retry-After", "5")
+		writeErr(w, http.StatusServiceUnavailable, "server shutting down")
 		return
 	}
 
@@ -106,259 +90,279 @@ func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := &wsConn{conn: conn, userID: userID, srv: s}
+	log := reqLog(r).With("user_id", userID)
+	client := newWSConn(conn, userID, s, log)
+	log.Info("ws: connected")
+	wsStats.accepted.Add(1)
+	wsStats.connected.Add(1)
+	go client.writePump()
+	client.prepareRead()
+
+	touchLastSeen(s.DB, userID)
 	s.Hub.Add(client)
 	defer func() {
+		touchLastSeen(s.DB, userID)
 		s.Hub.Remove(client)
 		_ = client.Close()
+		wsStats.connected.Add(-1)
+		log.Info("ws: disconnected")
 	}()
 
 	// hello
-	_ = client.SendJSON(map[string]any{"type": "hello", "data": map[string]any{"userId": userID}})
-	_ = client.SendJSON(map[string]any{
-		"type": "presence_snapshot",
-		"data": map[string]any{"online": s.Hub.OnlineUsers()},
-	})
-	var pendingCount int
-	_ = s.DB.QueryRow(`
-    SELECT COUNT(*) FROM followers
-    WHERE following_id = ? AND status = 'pending'
-`, userID).Scan(&pendingCount)
-
-	s.Hub.SendToUser(userID, map[string]any{
-		"type": "badge.follow_requests",
-		"data": map[string]any{"count": pendingCount},
-	})
+	_ = client.SendJSON(newFrame("hello", HelloData{UserID: userID, Version: wsProtocolVersion}))
+	_ = client.SendJSON(newFrame("presence_snapshot", s.presenceSnapshot(userID)))
+	pendingCount, _ := s.Store.Follows.PendingCount(r.Context(), userID)
+
+	s.Hub.SendToUser(userID, newFrame("badge.follow_requests", CountData{Count: pendingCount}))
 	// Main read loop
 	for {
-		var env Envelope
-		if err := conn.ReadJSON(&env); err != nil {
+		env, err := client.readEnvelope()
+		if err != nil {
+			var fe *FrameError
+			if errors.As(err, &fe) {
+				// malformed frame, the connection itself is fine
+				wsFrames.Inc("in", "invalid")
+				_ = client.SendJSON(errPayload(fe.Code, fe.Message))
+				continue
+			}
 			// client closed or read error -> exit
 			return
 		}
+		s.dispatch(client, userID, env)
+	}
+}
 
-		switch env.Type {
-		case "dm":
-			var in DMIn
-			if err := json.Unmarshal(env.Data, &in); err != nil {
-				_ = client.SendJSON(errPayload("bad_json", err.Error()))
-				continue
-			}
-			if in.To == "" || in.Text == "" {
-				_ = client.SendJSON(errPayload("bad_dm", "missing to/text"))
-				continue
-			}
-			if in.To == userID {
-				_ = client.SendJSON(errPayload("bad_dm", "cannot DM yourself"))
-				continue
-			}
-
-			// check follow relationship before sending
-			allowed, err := canDM(userID, in.To)
-			if err != nil {
-				_ = client.SendJSON(errPayload("db_error", "failed to check relationship"))
-				continue
-			}
-			if !allowed {
-				_ = client.SendJSON(errPayload("dm_denied", "Direct messages require a follow relationship"))
-				continue
-			}
+func (s *Server) handleDM(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in DMIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+	if in.To == "" || (in.Text == "" && len(in.Attachments) == 0) {
+		return nil, frameErr("bad_dm", "missing to/text")
+	}
+	if in.To == userID {
+		return nil, frameErr("bad_dm", "cannot DM yourself")
+	}
+	if len(in.ClientMsgID) > maxClientMsgIDLen {
+		return nil, frameErr("bad_dm", "client_msg_id too long")
+	}
 
-			// Only now do we insert and broadcast
-			msgID, sentAt, err := insertMessage(s.DB, userID, in.To, in.Text)
-			if err != nil {
-				_ = client.SendJSON(errPayload("db_error", err.Error()))
-				continue
-			}
+	// check follow relationship before sending
+	allowed, err := s.canDM(userID, in.To)
+	if err != nil {
+		return nil, frameErr("db_error", "failed to check relationship")
+	}
+	if !allowed {
+		return nil, frameErr("dm_denied", "Direct messages require a follow relationship")
+	}
 
-			out := DMOut{
-				ID:   msgID,
-				From: userID,
-				To:   in.To,
-				Text: in.Text,
-				TS:   sentAt.Format(time.RFC3339),
-			}
+	// a retried send: ack with what we stored the first time, don't deliver again
+	if prev, err := s.dmByClientID(userID, in.ClientMsgID); err != nil {
+		return nil, frameErr("db_error", err.Error())
+	} else if prev != nil {
+		return retriedDM(prev, in)
+	}
 
-			_ = client.SendJSON(map[string]any{"type": "dm", "data": out})      // sender echo
-			s.Hub.SendToUser(userID, map[string]any{"type": "dm", "data": out}) // other tabs
-			s.Hub.SendToUser(in.To, map[string]any{"type": "dm", "data": out})  // recipient
-			nid, _ := insertNotification(s.DB, in.To, "dm", map[string]any{
-				"from":      out.From,
-				"text":      out.Text,
-				"messageId": out.ID,
-				"ts":        out.TS,
-			})
-			uc, _ := unreadCount(s.DB, in.To)
-			s.Hub.SendToUser(in.To, map[string]any{
-				"type": "notification.created",
-				"data": map[string]any{
-					"id":   nid,
-					"type": "dm",
-					"content": map[string]any{
-						"from":      out.From,
-						"text":      out.Text,
-						"messageId": out.ID,
-						"ts":        out.TS,
-					},
-				},
-			})
-			s.Hub.SendToUser(in.To, map[string]any{
-				"type": "badge.unread",
-				"data": map[string]any{"count": uc},
-			})
-		case "typing":
-			var in struct {
-				To string `json:"to"`
-			}
-			if err := json.Unmarshal(env.Data, &in); err != nil {
-				_ = client.SendJSON(errPayload("bad_json", err.Error()))
-				continue
-			}
-			if in.To == "" || in.To == userID {
-				// ignore bad/self targets
-				continue
-			}
-			allowed, _ := canDM(userID, in.To)
-			if !allowed {
-				continue
-			}
-			// forward to the recipient (all their tabs)
-			s.Hub.SendToUser(in.To, map[string]any{
-				"type": "typing",
-				"data": map[string]any{
-					"from": userID,
-					"to":   in.To, // <-- include 'to' so the client can verify it's for them
-					"ts":   time.Now().Format(time.RFC3339),
-				},
-			})
-
-		case "group_message":
-			var in GroupMsgIn
-
-			if err := json.Unmarshal(env.Data, &in); err != nil {
-				_ = client.SendJSON(errPayload("bad_json", err.Error()))
-				continue
-			}
+	if err := checkAttachments(s.DB, userID, in.Attachments); err != nil {
+		return nil, frameErr("bad_attachment", err.Error())
+	}
+	if err := checkReplyTo(s.DB, "dm", in.ReplyTo, userID, in.To); err != nil {
+		return nil, frameErr("bad_reply", err.Error())
+	}
 
-			if in.GroupID == "" || in.Text == "" {
-				_ = client.SendJSON(errPayload("bad_group_message", "missing group_id/text"))
-				continue
-			}
+	// Only now do we insert and broadcast
+	msgID, sentAt, err := s.insertMessage(userID, in)
+	if errors.Is(err, store.ErrAttachmentUnavailable) {
+		return nil, frameErr("bad_attachment", "attachment already sent")
+	}
+	if err != nil {
+		// lost a race with a concurrent retry of the same client_msg_id
+		if prev, _ := s.dmByClientID(userID, in.ClientMsgID); prev != nil {
+			return retriedDM(prev, in)
+		}
+		return nil, frameErr("db_error", err.Error())
+	}
+	attachments, err := s.sentAttachments("dm", msgID, in.Attachments)
+	if err != nil {
+		return nil, frameErr("db_error", err.Error())
+	}
 
-			// check user is member of group
-			isMember, err := isGroupMember(userID, in.GroupID)
-			if err != nil {
-				_ = client.SendJSON(errPayload("db_error", "failed to check group membership"))
-				continue
-			}
-			if !isMember {
-				_ = client.SendJSON(errPayload("not_member", "You are not a member of this group"))
-				continue
-			}
+	out := DMOut{
+		ID:          msgID,
+		From:        userID,
+		To:          in.To,
+		Text:        in.Text,
+		TS:          sentAt.Format(time.RFC3339),
+		Attachments: attachments,
+		ReplyTo:     s.replySnippet("dm", in.ReplyTo),
+		ClientMsgID: in.ClientMsgID,
+	}
 
-			// insert group msg
-			msgID, sentAt, err := insertGroupMessage(s.DB, userID, in.GroupID, in.Text)
-			if err != nil {
-				_ = client.SendJSON(errPayload("db_error", err.Error()))
-				continue
-			}
+	frame := newFrame("dm", out)
+	_ = client.SendJSON(frame)      // sender echo
+	s.Hub.SendToUser(userID, frame) // other tabs
+	s.Hub.SendToUser(in.To, frame)  // recipient
+	content := map[string]any{
+		"from":      out.From,
+		"text":      out.Text,
+		"messageId": out.ID,
+		"ts":        out.TS,
+	}
+	nid, _ := s.insertNotification(context.Background(), in.To, "dm", content)
+	uc, _ := s.unreadCount(context.Background(), in.To)
+	s.Hub.SendToUser(in.To, newFrame("notification.created", map[string]any{
+		"id":      nid,
+		"type":    "dm",
+		"content": content,
+	}))
+	s.Hub.SendToUser(in.To, newFrame("badge.unread", CountData{Count: uc}))
+	return out, nil
+}
 
-			// to send to others
-			out := GroupMsgOut{
-				ID:      msgID,
-				From:    userID,
-				GroupID: in.GroupID,
-				Text:    in.Text,
-				TS:      sentAt.Format(time.RFC3339),
-			}
+type TypingIn struct {
+	To string `json:"to"`
+}
 
-			// send to sender
-			_ = client.SendJSON(map[string]any{"type": "group_message", "data": out})
-			s.Hub.SendToUser(userID, map[string]any{"type": "group_message", "data": out})
-
-			// send to all group members
-			members, err := getGroupMembers(s.DB, in.GroupID)
-			if err == nil {
-				for _, memberID := range members {
-					if memberID != userID {
-						s.Hub.SendToUser(memberID, map[string]any{"type": "group_message", "data": out})
-					}
-				}
-			}
+type TypingData struct {
+	From string `json:"from"`
+	To   string `json:"to"` // lets the client verify it's for them
+	TS   string `json:"ts"`
+}
 
-		default:
-			_ = client.SendJSON(errPayload("unsupported_type", env.Type))
-		}
+func (s *Server) handleTyping(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in TypingIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
+	}
+	if in.To == "" || in.To == userID {
+		// ignore bad/self targets
+		return nil, nil
+	}
+	allowed, _ := s.canDM(userID, in.To)
+	if !allowed {
+		return nil, nil
 	}
+	// forward to the recipient (all their tabs)
+	s.Hub.SendToUser(in.To, newFrame("typing", TypingData{
+		From: userID,
+		To:   in.To,
+		TS:   time.Now().Format(time.RFC3339),
+	}))
+	return nil, nil
 }
 
-func insertMessage(db *sql.DB, from, to, text string) (id string, sentAt time.Time, err error) {
-	if db == nil {
-		return "", time.Time{}, errors.New("nil DB")
+func (s *Server) handleGroupMessage(client *wsConn, userID string, data json.RawMessage) (any, error) {
+	var in GroupMsgIn
+	if err := decodeFrame(data, &in); err != nil {
+		return nil, err
 	}
-	res, err := db.Exec(`INSERT INTO messages (sender_id, receiver_id, content) VALUES (?,?,?)`, from, to, text)
-	if err != nil {
-		return "", time.Time{}, err
+	if in.GroupID == "" || (in.Text == "" && len(in.Attachments) == 0) {
+		return nil, frameErr("bad_group_message", "missing group_id/text")
 	}
-	lastID, err := res.LastInsertId()
+	if len(in.ClientMsgID) > maxClientMsgIDLen {
+		return nil, frameErr("bad_group_message", "client_msg_id too long")
+	}
+
+	// check user is member of group
+	isMember, err := s.isGroupMember(userID, in.GroupID)
 	if err != nil {
-		return "", time.Time{}, err
+		return nil, frameErr("db_error", "failed to check group membership")
 	}
-	// Read back the timestamp as stored by SQLite
-	if err := db.QueryRow(`SELECT sent_at FROM messages WHERE id = ?`, lastID).Scan(&sentAt); err != nil {
-		sentAt = time.Now()
+	if !isMember {
+		return nil, frameErr("not_member", "You are not a member of this group")
 	}
-	return fmt.Sprint(lastID), sentAt, nil
-}
 
-func isGroupMember(userID string, groupID string) (bool, error) {
-	var isMember bool
-	err := sqlite.DB.QueryRow(`
-		SELECT EXISTS (
-		SELECT 1 FROM group_members WHERE group_id = ? AND user_id = ? AND status = 'accepted'
-		)
-	`, groupID, userID).Scan(&isMember)
+	if prev, err := s.groupMessageByClientID(userID, in.ClientMsgID); err != nil {
+		return nil, frameErr("db_error", err.Error())
+	} else if prev != nil {
+		return retriedGroupMessage(prev, in)
+	}
 
-	return isMember, err
-}
+	if err := checkAttachments(s.DB, userID, in.Attachments); err != nil {
+		return nil, frameErr("bad_attachment", err.Error())
+	}
+	if err := checkReplyTo(s.DB, "group", in.ReplyTo, userID, in.GroupID); err != nil {
+		return nil, frameErr("bad_reply", err.Error())
+	}
 
-func insertGroupMessage(db *sql.DB, senderID, groupID, text string) (id string, sentAt time.Time, err error) {
-	res, err := db.Exec(`INSERT INTO group_chat (group_id, sender_id, content)
-	VALUES (?, ?, ?)`,
-		groupID, senderID, text)
+	// insert group msg
+	msgID, sentAt, err := s.insertGroupMessage(userID, in)
+	if errors.Is(err, store.ErrAttachmentUnavailable) {
+		return nil, frameErr("bad_attachment", "attachment already sent")
+	}
 	if err != nil {
-		return "", time.Time{}, err
+		if prev, _ := s.groupMessageByClientID(userID, in.ClientMsgID); prev != nil {
+			return retriedGroupMessage(prev, in)
+		}
+		return nil, frameErr("db_error", err.Error())
 	}
-
-	lastID, err := res.LastInsertId()
+	attachments, err := s.sentAttachments("group", msgID, in.Attachments)
 	if err != nil {
-		return "", time.Time{}, err
+		return nil, frameErr("db_error", err.Error())
 	}
 
-	if err := db.QueryRow(`SELECT sent_at FROM group_chat WHERE id = ?`, lastID).Scan(&sentAt); err != nil {
-		sentAt = time.Now()
+	// to send to others
+	out := GroupMsgOut{
+		ID:          msgID,
+		From:        userID,
+		GroupID:     in.GroupID,
+		Text:        in.Text,
+		TS:          sentAt.Format(time.RFC3339),
+		Attachments: attachments,
+		ReplyTo:     s.replySnippet("group", in.ReplyTo),
+		ClientMsgID: in.ClientMsgID,
 	}
 
-	return fmt.Sprint(lastID), sentAt, nil
-}
+	// the sender has obviously read their own message
+	_ = s.markGroupRead(context.Background(), userID, in.GroupID, msgID)
 
-func getGroupMembers(db *sql.DB, groupID string) ([]string, error) {
-	rows, err := db.Query(`
-        SELECT user_id FROM group_members 
-        WHERE group_id = ? AND status = 'accepted'
-    `, groupID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	// send to sender
+	frame := newFrame("group_message", out)
+	_ = client.SendJSON(frame)
+	s.Hub.SendToUser(userID, frame)
 
-	var members []string
-	for rows.Next() {
-		var userID string
-		if err := rows.Scan(&userID); err != nil {
-			continue
+	// send to all group members
+	members, err := s.getGroupMembers(in.GroupID)
+	if err == nil {
+		for _, memberID := range members {
+			if memberID != userID {
+				s.Hub.SendToUser(memberID, frame)
+			}
 		}
-		members = append(members, userID)
 	}
-	return members, nil
+	return out, nil
+}
+func (s *Server) insertMessage(from string, in DMIn) (id string, sentAt time.Time, err error) {
+	return s.Store.Messages.CreateDM(context.Background(), store.Message{
+		SenderID:    from,
+		ReceiverID:  in.To,
+		Content:     in.Text,
+		ReplyTo:     in.ReplyTo,
+		ClientMsgID: in.ClientMsgID,
+		Attachments: in.Attachments,
+	})
+}
+
+func (s *Server) isGroupMember(userID string, groupID string) (bool, error) {
+	status, err := s.Store.Groups.MemberStatus(context.Background(), groupID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return status == store.MemberAccepted, err
+}
+
+func (s *Server) insertGroupMessage(senderID string, in GroupMsgIn) (id string, sentAt time.Time, err error) {
+	return s.Store.Messages.CreateGroupMessage(context.Background(), store.Message{
+		SenderID:    senderID,
+		GroupID:     in.GroupID,
+		Content:     in.Text,
+		ReplyTo:     in.ReplyTo,
+		ClientMsgID: in.ClientMsgID,
+		Attachments: in.Attachments,
+	})
+}
+
+// getGroupMembers returns the accepted members of the group.
+func (s *Server) getGroupMembers(groupID string) ([]string, error) {
+	return s.Store.Groups.MemberIDs(context.Background(), groupID)
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	db "backend/pkg/db/sqlite"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"net/http"
 	"time"
@@ -10,15 +11,30 @@ import (
 
 const sessionCookieName = "session_token"
 
-// setSession creates a session row and sets the cookie.
-func setSession(w http.ResponseWriter, userID string) error {
+// newToken returns 32 random bytes, hex encoded.
+func newToken() (string, error) {
 	raw := make([]byte, 32)
 	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// hashToken is what gets stored for emailed tokens, so a leaked table can't be
+// used to redeem them.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// setSession creates a session row and sets the cookie.
+func setSession(w http.ResponseWriter, userID string) error {
+	token, err := newToken()
+	if err != nil {
 		return err
 	}
-	token := hex.EncodeToString(raw)
 
-	exp := time.Now().Add(1 * time.Hour)
+	exp := time.Now().Add(settings.SessionTTL)
 
 	if err := db.CreateSession(userID, token, exp); err != nil {
 		return err
@@ -32,7 +48,7 @@ func setSession(w http.ResponseWriter, userID string) error {
 		MaxAge:   int(time.Until(exp).Seconds()),
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		Secure:   false, // true in HTTPS
+		Secure:   settings.SecureCookies,
 	})
 
 	return nil
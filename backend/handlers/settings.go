@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"backend/pkg/config"
+)
+
+// settings is the configuration handlers run with; main installs the loaded
+// one with Configure before serving.
+var settings = config.Defaults()
+
+// Configure applies c to the handlers: session cookies, upload folders,
+// trusted origins, email links and the password policy.
+func Configure(c *config.Config) error {
+	policy := DefaultPasswordPolicy()
+	if c.PasswordPolicy != "" {
+		p, err := ParsePasswordPolicy(c.PasswordPolicy)
+		if err != nil {
+			return err
+		}
+		policy = p
+	}
+	settings = *c
+	Passwords = policy
+	SetAllowedOrigins(c.AllowedOrigins)
+	return nil
+}
+
+// frontendURL links to a page of the web app, e.g. frontendURL("/reset-password").
+func frontendURL(path string) string {
+	return settings.FrontendURL + path
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Clients told to reconnect wait somewhere in this window, so a restart
+// doesn't bring every socket back in the same instant.
+const (
+	reconnectMin = 1 * time.Second
+	reconnectMax = 5 * time.Second
+)
+
+// ShutdownData is the last frame a connection gets before the server closes
+// it for a restart or deploy.
+type ShutdownData struct {
+	Reason         string `json:"reason"`
+	ReconnectAfter int    `json:"reconnect_after"` // milliseconds to wait before reconnecting
+}
+
+func reconnectHint() int {
+	d := reconnectMin + rand.N(reconnectMax-reconnectMin)
+	return int(d / time.Millisecond)
+}
+
+// Shutdown refuses new upgrades, sends server.shutdown to every connection on
+// this instance and closes them once their queued frames are written. It
+// waits for the connections to be released until ctx is done, then drops
+// whatever is left.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		// re-scan each round: an upgrade that was already past the closing
+		// check may register after the first pass
+		conns := s.Hub.localConns("")
+		if len(conns) == 0 {
+			return nil
+		}
+		for _, c := range conns {
+			frame := newFrame("server.shutdown", ShutdownData{Reason: "restart", ReconnectAfter: reconnectHint()})
+			if wc, ok := c.(*wsConn); ok {
+				wc.goAway(frame)
+			} else {
+				_ = c.SendJSON(frame)
+				_ = c.Close()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			for _, c := range s.Hub.localConns("") {
+				_ = c.Close()
+			}
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// FlushMail waits for mail still being sent in the background.
+func FlushMail(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		mailing.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
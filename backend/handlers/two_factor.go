@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	db "backend/pkg/db/sqlite"
+	"backend/pkg/totp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	challengeCookieName = "login_challenge"
+	loginChallengeTTL   = 5 * time.Minute
+	loginChallengeTries = 5 // codes per challenge before the password is needed again
+	recoveryCodeCount   = 10
+	totpSkew            = 1 // steps of clock drift accepted either way
+)
+
+// TOTPIssuer is the account label shown in authenticator apps.
+var TOTPIssuer = "Social Network"
+
+var errChallengeExpired = &APIError{Status: http.StatusUnauthorized, Code: "challenge_expired", Message: "Sign in again"}
+
+func twoFactorEnabled(db *sql.DB, userID string) bool {
+	var on bool
+	if err := db.QueryRow(`SELECT totp_enabled FROM users WHERE id = ?`, userID).Scan(&on); err != nil {
+		return false
+	}
+	return on
+}
+
+// checkTOTP accepts a code for the user's secret, enabled or still being
+// enrolled, at most once: a step at or before the last accepted one fails.
+func checkTOTP(userID, code string) (bool, error) {
+	var secret sql.NullString
+	if err := db.DB.QueryRow(`SELECT totp_secret FROM users WHERE id = ?`, userID).Scan(&secret); err != nil {
+		return false, err
+	}
+	if !secret.Valid {
+		return false, nil
+	}
+	step, ok := totp.Validate(secret.String, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+	res, err := db.DB.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes so codes can be typed loosely.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// useRecoveryCode spends one of the user's unused recovery codes.
+func useRecoveryCode(userID, code string) (bool, error) {
+	res, err := db.DB.Exec(`
+		UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// checkSecondFactor takes either an authenticator code or a recovery code.
+func checkSecondFactor(userID, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		return checkTOTP(userID, code)
+	}
+	return useRecoveryCode(userID, code)
+}
+
+// newRecoveryCodes replaces the user's recovery codes; the plain codes are
+// only ever shown in this response.
+func newRecoveryCodes(userID string) ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(enc.EncodeToString(raw))[:10]
+		codes[i] = c[:5] + "-" + c[5:]
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes(user_id, code_hash) VALUES(?, ?)`,
+			userID, hashToken(normalizeRecoveryCode(c))); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// checkPassword compares pw with the user's stored hash.
+func checkPassword(userID, pw string) bool {
+	hashed, err := db.GetHashedPasswordByID(userID)
+	return err == nil && bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pw)) == nil
+}
+
+// startLoginChallenge remembers that userID got their password right and
+// hands the browser a short-lived cookie to finish with a code.
+func startLoginChallenge(w http.ResponseWriter, userID string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec(`
+		INSERT INTO login_challenges(user_id, token_hash, expires_at)
+		VALUES(?, ?, datetime('now', ?))`,
+		userID, hashToken(token), sqliteOffset(loginChallengeTTL)); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    token,
+		Path:     "/api/login",
+		MaxAge:   int(loginChallengeTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   settings.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearLoginChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    "",
+		Path:     "/api/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   settings.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// TwoFactorCodeRequest carries an authenticator or recovery code.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// POST /api/login/2fa
+// Second login step: trades the challenge cookie and a code for a session.
+func (s *Server) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var body TwoFactorCodeRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Code == "" {
+		writeErr(w, http.StatusBadRequest, "Code is required")
+		return
+	}
+
+	c, err := r.Cookie(challengeCookieName)
+	if err != nil || c.Value == "" {
+		writeError(w, errChallengeExpired)
+		return
+	}
+
+	// every try is counted before the code is looked at
+	var userID, email string
+	err = db.DB.QueryRow(`
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token_hash = ? AND expires_at > datetime('now') AND attempts < ?
+		RETURNING user_id, (SELECT email FROM users WHERE id = login_challenges.user_id)
+	`, hashToken(c.Value), loginChallengeTries).Scan(&userID, &email)
+	if err != nil {
+		clearLoginChallenge(w)
+		writeError(w, errChallengeExpired)
+		return
+	}
+
+	if d := lockedFor(db.DB, userID); d > 0 {
+		recordLoginAttempt(db.DB, r, userID, email, false, loginLocked)
+		writeLoginBlocked(w, http.StatusLocked, "account_locked",
+			"This account is temporarily locked after too many failed sign-in attempts", d)
+		return
+	}
+
+	ok, err := checkSecondFactor(userID, body.Code)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !ok {
+		recordLoginAttempt(db.DB, r, userID, email, false, loginBadCode)
+		if failures, _, err := accountFailures(db.DB, userID); err == nil && failures >= loginLockAfter {
+			s.lockAccount(userID, clientIP(r))
+			writeLoginBlocked(w, http.StatusLocked, "account_locked",
+				"This account is temporarily locked after too many failed sign-in attempts", loginLockFor)
+			return
+		}
+		writeErr(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	_, _ = db.DB.Exec(`DELETE FROM login_challenges WHERE user_id = ? OR expires_at <= datetime('now')`, userID)
+	clearLoginChallenge(w)
+	recordLoginAttempt(db.DB, r, userID, email, true, loginOK)
+
+	if err := setSession(w, userID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Logged in successfully"})
+}
+
+// TwoFactorStatus says whether 2FA is on and how many recovery codes are unused.
+type TwoFactorStatus struct {
+	OK                bool `json:"ok"`
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// EnrollResponse carries the new secret, also as an otpauth:// URI for a QR code.
+type EnrollResponse struct {
+	OK     bool   `json:"ok"`
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse lists recovery codes; they are only ever shown once.
+type RecoveryCodesResponse struct {
+	OK            bool     `json:"ok"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// GET /api/users/2fa
+func TwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := currentUserID(r)
+
+	var (
+		enabled bool
+		left    int
+	)
+	if err := db.DB.QueryRow(`
+		SELECT totp_enabled,
+		       (SELECT COUNT(*) FROM recovery_codes WHERE user_id = users.id AND used_at IS NULL)
+		FROM users WHERE id = ?
+	`, userID).Scan(&enabled, &left); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, TwoFactorStatus{OK: true, Enabled: enabled, RecoveryCodesLeft: left})
+}
+
+// PasswordRequest re-confirms the account password before a security change.
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// POST /api/users/2fa/enroll
+// Starts enrollment with a fresh secret; nothing changes for login until
+// /activate proves the app has it.
+func EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := currentUserID(r)
+
+	var body PasswordRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !checkPassword(userID, body.Password) {
+		writeErr(w, http.StatusForbidden, "Password is incorrect")
+		return
+	}
+	if twoFactorEnabled(db.DB, userID) {
+		writeErr(w, http.StatusConflict, "Two-factor authentication is already on")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+	var email string
+	if err := db.DB.QueryRow(`
+		UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ? RETURNING email
+	`, secret, userID).Scan(&email); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EnrollResponse{OK: true, Secret: secret, URI: totp.URI(TOTPIssuer, email, secret)})
+}
+
+// POST /api/users/2fa/activate
+// Turns 2FA on once a code from the enrolled secret checks out, and returns
+// the recovery codes.
+func ActivateTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := currentUserID(r)
+
+	var body TwoFactorCodeRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if twoFactorEnabled(db.DB, userID) {
+		writeErr(w, http.StatusConflict, "Two-factor authentication is already on")
+		return
+	}
+	ok, err := checkTOTP(userID, body.Code)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !ok {
+		writeErr(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, err := newRecoveryCodes(userID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Failed to create recovery codes")
+		return
+	}
+	if _, err := db.DB.Exec(`UPDATE users SET totp_enabled = 1 WHERE id = ?`, userID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RecoveryCodesResponse{OK: true, RecoveryCodes: codes})
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// POST /api/users/2fa/disable
+// Needs the password and a current code (or a recovery code).
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := currentUserID(r)
+
+	var body DisableTwoFactorRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !twoFactorEnabled(db.DB, userID) {
+		writeErr(w, http.StatusBadRequest, "Two-factor authentication is not on")
+		return
+	}
+	if !checkPassword(userID, body.Password) {
+		writeErr(w, http.StatusForbidden, "Password is incorrect")
+		return
+	}
+	if ok, err := checkSecondFactor(userID, body.Code); err != nil || !ok {
+		writeErr(w, http.StatusForbidden, "Invalid code")
+		return
+	}
+
+	if _, err := db.DB.Exec(`UPDATE users SET totp_enabled = 0, totp_secret = NULL WHERE id = ?`, userID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	_, _ = db.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Two-factor authentication turned off"})
+}
+
+// POST /api/users/2fa/recovery-codes
+// Replaces the recovery codes; needs a current authenticator code.
+func RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := currentUserID(r)
+
+	var body TwoFactorCodeRequest
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !twoFactorEnabled(db.DB, userID) {
+		writeErr(w, http.StatusBadRequest, "Two-factor authentication is not on")
+		return
+	}
+	if ok, err := checkTOTP(userID, body.Code); err != nil || !ok {
+		writeErr(w, http.StatusForbidden, "Invalid code")
+		return
+	}
+
+	codes, err := newRecoveryCodes(userID)
+	if err != nil {
+		reqLog(r).Error("recovery codes", "err", err)
+		writeErr(w, http.StatusInternalServerError, "Failed to create recovery codes")
+		return
+	}
+	writeJSON(w, http.StatusOK, RecoveryCodesResponse{OK: true, RecoveryCodes: codes})
+}
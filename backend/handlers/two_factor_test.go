@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	db "backend/pkg/db/sqlite"
+	"backend/pkg/totp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useSQLite points db.DB at a fresh, fully migrated database for the test
+// and returns a server backed by it.
+func useSQLite(t *testing.T) *Server {
+	t.Helper()
+	prev := db.DB
+	db.InitDB(filepath.Join(t.TempDir(), "test.db"), "../database/migrations/sqlite")
+	t.Cleanup(func() {
+		db.DB.Close()
+		db.DB = prev
+	})
+	return &Server{Hub: NewHub(), DB: db.DB, Store: db.NewStore(db.DB)}
+}
+
+// browser is a client with its own cookie jar, so each one is a separate sign-in.
+type browser struct {
+	t   *testing.T
+	srv *httptest.Server
+	c   *http.Client
+}
+
+func newBrowser(t *testing.T, srv *httptest.Server) *browser {
+	jar, _ := cookiejar.New(nil)
+	return &browser{t: t, srv: srv, c: &http.Client{Jar: jar}}
+}
+
+// do sends v as JSON (or nothing when v is nil) and returns the status and error code.
+func (b *browser) do(method, path string, v any) (int, string) {
+	b.t.Helper()
+	var body bytes.Buffer
+	if v != nil {
+		json.NewEncoder(&body).Encode(v)
+	}
+	req, _ := http.NewRequest(method, b.srv.URL+path, &body)
+	if v != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := b.c.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var out struct {
+		Code string `json:"code"`
+	}
+	json.NewDecoder(res.Body).Decode(&out)
+	return res.StatusCode, out.Code
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := useSQLite(t)
+
+	const email, password = "alice@example.com", "correct horse battery"
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err := db.InsertUser(email, string(hash), "Alice", "A", "1990-01-01", "", "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	uid, _ := db.GetUserID(email)
+	secret, _ := totp.GenerateSecret()
+	if _, err := db.DB.Exec(`UPDATE users SET totp_secret = ?, totp_enabled = 1 WHERE id = ?`, secret, uid); err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := newRecoveryCodes(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", s.LoginHandler)
+	mux.HandleFunc("POST /api/login/2fa", s.LoginTwoFactorHandler)
+	mux.HandleFunc("GET /api/users/2fa", RequireAuth(TwoFactorStatusHandler))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// signIn gets a browser past the password, holding only the challenge.
+	signIn := func() *browser {
+		t.Helper()
+		b := newBrowser(t, srv)
+		if status, code := b.do(http.MethodPost, "/api/login", LoginRequest{Email: email, Password: password}); status != http.StatusUnauthorized || code != "two_factor_required" {
+			t.Fatalf("password login: %d %q", status, code)
+		}
+		if status, _ := b.do(http.MethodGet, "/api/users/2fa", nil); status != http.StatusUnauthorized {
+			t.Fatalf("pending login reached an authenticated route: %d", status)
+		}
+		return b
+	}
+	finish := func(b *browser, code string, want int) {
+		t.Helper()
+		if status, _ := b.do(http.MethodPost, "/api/login/2fa", TwoFactorCodeRequest{Code: code}); status != want {
+			t.Fatalf("code %q: status %d, want %d", code, status, want)
+		}
+		authed := http.StatusUnauthorized
+		if want == http.StatusOK {
+			authed = http.StatusOK
+		}
+		if status, _ := b.do(http.MethodGet, "/api/users/2fa", nil); status != authed {
+			t.Fatalf("after code %q the authenticated route gave %d, want %d", code, status, authed)
+		}
+	}
+
+	now := totp.Step(time.Now())
+	current, _ := totp.CodeAt(secret, now)
+	wrong := "000000"
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		if c, _ := totp.CodeAt(secret, now+d); c == wrong {
+			wrong = "999999"
+		}
+	}
+
+	b := signIn()
+	finish(b, wrong, http.StatusUnauthorized)
+	finish(b, current, http.StatusOK)
+
+	// the same code again is a replay of the last used step
+	finish(signIn(), current, http.StatusUnauthorized)
+
+	b = signIn()
+	finish(b, recovery[0], http.StatusOK)
+	finish(signIn(), recovery[0], http.StatusUnauthorized)
+	finish(signIn(), recovery[1], http.StatusOK)
+
+	if status, code := newBrowser(t, srv).do(http.MethodPost, "/api/login/2fa", TwoFactorCodeRequest{Code: recovery[2]}); status != http.StatusUnauthorized || code != "challenge_expired" {
+		t.Errorf("code without a challenge: %d %q", status, code)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// saveUpload stores an already validated image in the public uploads folder
+// and returns the file name to keep in the database.
+func saveUpload(file multipart.File, header *multipart.FileHeader) (string, error) {
+	if err := os.MkdirAll(settings.UploadsDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("prepare upload folder: %w", err)
+	}
+
+	// Simple unique filename to avoid collisions
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), path.Base(header.Filename))
+	dst, err := os.Create(filepath.Join(settings.UploadsDir, filename))
+	if err != nil {
+		return "", err
+	}
+
+	n, err := io.Copy(dst, file)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst.Name())
+		return "", err
+	}
+	uploadBytes.Add(float64(n), "image")
+	return filename, nil
+}
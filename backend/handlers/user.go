@@ -1,265 +1,179 @@
 package handlers
 
 import (
-	"backend/pkg/db/sqlite"
-	"database/sql"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
-	 "time" 
+	"time"
+
+	"backend/pkg/store"
 )
 
-func ToggleProfilePrivacyHandler(w http.ResponseWriter, r *http.Request) {
+// PrivacyToggled is the profile's visibility after the toggle.
+type PrivacyToggled struct {
+	OK       bool `json:"ok"`
+	IsPublic bool `json:"isPublic"`
+}
+
+// PrivacyStatus is whether a profile is public.
+type PrivacyStatus struct {
+	OK       bool `json:"ok"`
+	IsPublic bool `json:"is_public"`
+}
+
+type FollowersResponse struct {
+	OK        bool          `json:"ok"`
+	Followers []UserSummary `json:"followers"`
+}
+
+type FollowingsResponse struct {
+	OK         bool          `json:"ok"`
+	Followings []UserSummary `json:"followings"`
+}
+
+// FollowResponse is where a follow stands after a request or an answer to one.
+type FollowResponse struct {
+	OK      bool   `json:"ok"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// FollowStatusQuery names the user the caller may be following.
+type FollowStatusQuery struct {
+	FollowingID string `query:"following_id,required"`
+}
+
+type FollowCounts struct {
+	OK             bool `json:"ok"`
+	FollowingCount int  `json:"following_count"`
+	FollowerCount  int  `json:"follower_count"`
+}
+
+type PendingFollowsResponse struct {
+	OK       bool                  `json:"ok"`
+	Requests []store.PendingFollow `json:"requests"`
+}
+
+func (s *Server) ToggleProfilePrivacyHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	ctx := r.Context()
+	userID := currentUserID(r)
 
-	var isPublic bool
-	if err := sqlite.DB.QueryRow(`SELECT is_public FROM users WHERE id = ?`, userID).Scan(&isPublic); err != nil {
+	isPublic, err := s.Store.Users.IsPublic(ctx, userID)
+	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	newIsPublic := !isPublic
 
-	if _, err := sqlite.DB.Exec(`UPDATE users SET is_public = ? WHERE id = ?`, newIsPublic, userID); err != nil {
+	if err := s.Store.Users.SetPublic(ctx, userID, newIsPublic); err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	if newIsPublic {
-		var pendingFollowerIDs []string
-		rows, err := sqlite.DB.Query(`
-			SELECT follower_id
-			FROM followers
-			WHERE following_id = ? AND status = 'pending'
-		`, userID)
-		if err == nil {
-			defer rows.Close()
-			for rows.Next() {
-				var fid string
-				if err := rows.Scan(&fid); err == nil {
-					pendingFollowerIDs = append(pendingFollowerIDs, fid)
-				}
-			}
+		// a public profile has nothing to approve: everyone waiting gets in
+		accepted, err := s.Store.Follows.AcceptPending(ctx, userID)
+		if err != nil {
+			reqLog(r).Error("accept pending follows", "err", err)
 		}
 
-		_, _ = sqlite.DB.Exec(`
-			UPDATE followers
-			SET status = 'accepted', created_at = CURRENT_TIMESTAMP
-			WHERE following_id = ? AND status = 'pending'
-		`, userID)
-
-		var fn2, ln2, nn2, av2 string
-		_ = sqlite.DB.QueryRow(`
-			SELECT firstName, lastName, nickname, avatar
-			FROM users WHERE id = ?
-		`, userID).Scan(&fn2, &ln2, &nn2, &av2)
-
-		for _, fid := range pendingFollowerIDs {
+		me, _ := s.Store.Users.Summary(ctx, userID)
+		content := map[string]any{
+			"status":      store.FollowAccepted,
+			"followingId": userID,
+			"firstName":   me.FirstName,
+			"lastName":    me.LastName,
+			"nickname":    me.Nickname,
+			"avatar":      me.Avatar,
+		}
+		for _, fid := range accepted {
 			PushToUser(fid, map[string]any{
 				"type": "notification.created",
 				"data": map[string]any{
-					"type": "follow_request.update",
-					"content": map[string]any{
-						"status":      "accepted",
-						"followingId": userID,
-						"firstName":  fn2,
-						"lastName":   ln2,
-						"nickname":    nn2,
-						"avatar":      av2,
-					},
+					"type":    "follow_request.update",
+					"content": content,
 				},
 			})
-			_, _ = insertNotification(sqlite.DB, fid, "follow_request.update", map[string]any{
-				"status":      "accepted",
-				"followingId": userID,
-				"firstName":  fn2,
-				"lastName":   ln2,
-				"nickname":    nn2,
-				"avatar":      av2,
-			})
-			if uc, err := unreadCount(sqlite.DB, fid); err == nil {
-				PushToUser(fid, map[string]any{
-					"type": "badge.unread",
-					"data": map[string]any{"count": uc},
-				})
-			}
+			_, _ = s.insertNotification(ctx, fid, "follow_request.update", content)
+			s.pushUnread(ctx, fid)
 		}
 
-		var pendingCount int
-		_ = sqlite.DB.QueryRow(`
-			SELECT COUNT(*) FROM followers
-			WHERE following_id = ? AND status = 'pending'
-		`, userID).Scan(&pendingCount)
-		PushToUser(userID, map[string]any{
-			"type": "badge.follow_requests",
-			"data": map[string]any{"count": pendingCount},
-		})
+		s.pushPendingFollows(ctx, userID)
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":       true,
-		"isPublic": newIsPublic,
-	})
+	writeJSON(w, http.StatusOK, PrivacyToggled{OK: true, IsPublic: newIsPublic})
 }
 
+// pushPendingFollows sends the user's count of follow requests waiting for an answer.
+func (s *Server) pushPendingFollows(ctx context.Context, userID string) {
+	n, _ := s.Store.Follows.PendingCount(ctx, userID)
+	PushToUser(userID, map[string]any{
+		"type": "badge.follow_requests",
+		"data": map[string]any{"count": n},
+	})
+}
 
-func GetUserFollowers(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetUserFollowers(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if userID == "" || err != nil {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	ProfileUserID := strings.TrimPrefix(r.URL.Path, "/api/users/")
-	ProfileUserID = strings.TrimSuffix(ProfileUserID, "/followers")
-
-	rows, err := sqlite.DB.Query(
-		`SELECT u.id, u.first_name, u.last_name, u.avatar
-		FROM followers f
-		JOIN users u ON f.follower_id = u.id
-		WHERE f.following_id = ? AND f.status = 'accepted'
-	`, ProfileUserID)
-
+	followers, err := s.Store.Follows.Followers(r.Context(), r.PathValue("id"))
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	defer rows.Close()
-
-	type Follower struct {
-		ID        string `json:"id"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Avatar    string `json:"avatar"`
-	}
-
-	var followers []Follower
-
-	for rows.Next() {
-		var f Follower
-
-		err := rows.Scan(&f.ID, &f.FirstName, &f.LastName, &f.Avatar)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "Database error")
-			return
-		}
-
-		followers = append(followers, f)
-	}
-
-	if err := rows.Err(); err != nil {
-		writeErr(w, http.StatusInternalServerError, "Database error")
-		return
-	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":        true,
-		"followers": followers,
-	})
+	writeJSON(w, http.StatusOK, FollowersResponse{OK: true, Followers: followers})
 }
 
-func GetUserFollowing(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetUserFollowing(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-	ProfileUserID := strings.TrimPrefix(r.URL.Path, "/api/users/")
-	ProfileUserID = strings.TrimSuffix(ProfileUserID, "/followings")
-
-	rows, err := sqlite.DB.Query(`
-	SELECT u.id, u.first_name, u.last_name, u.avatar
-	FROM followers f
-	JOIN users u ON u.id=f.following_id
-	WHERE f.follower_id = ? AND f.status = 'accepted'
-	`, ProfileUserID)
-
+	followings, err := s.Store.Follows.Following(r.Context(), r.PathValue("id"))
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	defer rows.Close()
-
-	type Following struct {
-		ID        string `json:"id"`
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Avatar    string `json:"avatar"`
-	}
-
-	var followings []Following
-
-	for rows.Next() {
-
-		var f Following
-
-		err := rows.Scan(&f.ID, &f.FirstName, &f.LastName, &f.Avatar)
-		if err != nil {
-			writeErr(w, http.StatusInternalServerError, "Database error")
-			return
-		}
-
-		followings = append(followings, f)
-	}
-
-	if err := rows.Err(); err != nil {
-		writeErr(w, http.StatusInternalServerError, "Database error")
-		return
-	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":        true,
-		"followings": followings,
-	})
+	writeJSON(w, http.StatusOK, FollowingsResponse{OK: true, Followings: followings})
+}
 
+// FollowRequest names the user to follow or unfollow.
+type FollowRequest struct {
+	FollowingID string `json:"following_id"`
 }
 
-func FollowUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FollowUser(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
+	ctx := r.Context()
 	//user who wants to follow another user
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := currentUserID(r)
 
 	//get user to follow
-	var req struct {
-		FollowingID string `json:"following_id"`
-	}
+	var req FollowRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -276,188 +190,107 @@ func FollowUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//check if already following that user
-	var existingStatus string
-	err = sqlite.DB.QueryRow(`
-		SELECT status FROM followers
-		WHERE follower_id = ? AND following_id = ?
-		`, userID, req.FollowingID).Scan(&existingStatus)
-
-	if err == nil {
-
-		if existingStatus == "pending" {
-			writeErr(w, http.StatusConflict, "Follow request already pending")
-			return
-		} else if existingStatus == "accepted" {
-			writeErr(w, http.StatusConflict, "Already following this user")
-			return
-		} else if existingStatus == "declined" {
-
-			_, err = sqlite.DB.Exec(`
-				UPDATE followers SET status = 'pending' , created_at = CURRENT_TIMESTAMP
-				WHERE follower_id = ? AND following_id = ? 
-				`, userID, req.FollowingID)
-
-			if err != nil {
-				writeErr(w, http.StatusInternalServerError, "Database error")
-				return
-			}
-			var fn, ln, nn, av string
-			_ = sqlite.DB.QueryRow(`
-				SELECT first_name, last_name, nickname, avatar
-				FROM users WHERE id = ?
-			`, userID).Scan(&fn, &ln, &nn, &av)
-
-			PushToUser(req.FollowingID, map[string]any{
-				"type": "notification.created",
-				"data": map[string]any{
-					"type": "follow_request",
-					"content": map[string]any{
-						"followerId": userID,
-						"firstName": fn,
-						"lastName":  ln,
-						"nickname":   nn,
-						"avatar":     av,
-						"createdAt":  time.Now().Format(time.RFC3339),
-					},
-				},
-			})
-
-			var pendingCount int
-			_ = sqlite.DB.QueryRow(`
-				SELECT COUNT(*) FROM followers
-				WHERE following_id = ? AND status = 'pending'
-			`, req.FollowingID).Scan(&pendingCount)
-
-			PushToUser(req.FollowingID, map[string]any{
-				"type": "badge.follow_requests",
-				"data": map[string]any{"count": pendingCount},
-			})
-
-			writeJSON(w, http.StatusOK, map[string]any{
-				"ok":      true,
-				"status":  "pending",
-				"message": "Follow request sent", //if it was declined then for sure account is private, should i do accepted here immediately if account is public, or from frontend? WHAT IF account status changed from prev declined to now, became public now, if became public remove all existing statuses????
-			})
+	existingStatus, err := s.Store.Follows.Status(ctx, userID, req.FollowingID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	case existingStatus == store.FollowPending:
+		writeErr(w, http.StatusConflict, "Follow request already pending")
+		return
+	case existingStatus == store.FollowAccepted:
+		writeErr(w, http.StatusConflict, "Already following this user")
+		return
+	case existingStatus == store.FollowDeclined:
+		// a declined request can only have come from a private account, so ask again
+		if err := s.Store.Follows.Follow(ctx, userID, req.FollowingID, store.FollowPending); err != nil {
+			writeErr(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-	}
+		me, _ := s.Store.Users.Summary(ctx, userID)
+		PushToUser(req.FollowingID, map[string]any{
+			"type": "notification.created",
+			"data": map[string]any{
+				"type":    "follow_request",
+				"content": followRequestContent(me),
+			},
+		})
+		s.pushPendingFollows(ctx, req.FollowingID)
 
-	var isPublic bool
-	err = sqlite.DB.QueryRow(`
-		SELECT is_public FROM users WHERE id = ?
-	`, req.FollowingID).Scan(&isPublic)
+		writeJSON(w, http.StatusOK, FollowResponse{OK: true, Status: store.FollowPending, Message: "Follow request sent"})
+		return
+	}
 
-	if err != nil {
+	isPublic, err := s.Store.Users.IsPublic(ctx, req.FollowingID)
+	if errors.Is(err, store.ErrNotFound) {
 		writeErr(w, http.StatusNotFound, "User not found")
 		return
 	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
 
-	status := "accepted"
+	status := store.FollowAccepted
 	if !isPublic {
-		status = "pending"
-	}
-
-	//try to update status, use update cuz if i follow then get declined, then i follow again, its not 2 records !
-	result, err := sqlite.DB.Exec(`
-        UPDATE followers 
-        SET status = ?, created_at = CURRENT_TIMESTAMP
-        WHERE follower_id = ? AND following_id = ?
-    `, status, userID, req.FollowingID)
-
-    if err != nil {
-        fmt.Printf("Update error: %v\n", err)
-        writeErr(w, http.StatusInternalServerError, "Database error")
-        return
-    }
-
-    rowsAffected, _ := result.RowsAffected()
-	
-	// If no rows updated, insert new record
-    if rowsAffected == 0 {
-        _, err = sqlite.DB.Exec(`
-            INSERT INTO followers (follower_id, following_id, status)
-            VALUES (?, ?, ?)
-        `, userID, req.FollowingID, status)
-
-        if err != nil {
-            fmt.Printf("Insert error: %v\n", err)
-            writeErr(w, http.StatusInternalServerError, "Database error")
-            return
-        }
-    }
-if status == "pending" {
-		// notify the private account owner
-		var fn, ln, nn, av string
-		_ = sqlite.DB.QueryRow(`
-			SELECT first_name, last_name, nickname, avatar
-			FROM users WHERE id = ?
-		`, userID).Scan(&fn, &ln, &nn, &av)
+		status = store.FollowPending
+	}
+
+	if err := s.Store.Follows.Follow(ctx, userID, req.FollowingID, status); err != nil {
+		reqLog(r).Error("follow", "err", err)
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
 
+	if status == store.FollowPending {
+		// notify the private account owner
+		me, _ := s.Store.Users.Summary(ctx, userID)
+		content := followRequestContent(me)
 		PushToUser(req.FollowingID, map[string]any{
 			"type": "notification.created",
 			"data": map[string]any{
-				"type": "follow_request",
-				"content": map[string]any{
-					"followerId": userID,
-					"firstName": fn,
-					"lastName":  ln,
-					"nickname":   nn,
-					"avatar":     av,
-					"createdAt":  time.Now().Format(time.RFC3339),
-				},
+				"type":    "follow_request",
+				"content": content,
 			},
 		})
-		_, _ = insertNotification(sqlite.DB, req.FollowingID, "follow_request", map[string]any{
-			"followerId": userID,
-			"firstName": fn,
-			"lastName":  ln,
-			"nickname":   nn,
-			"avatar":     av,
-			"createdAt":  time.Now().Format(time.RFC3339),
-		})
-		var pendingCount int
-		_ = sqlite.DB.QueryRow(`
-			SELECT COUNT(*) FROM followers
-			WHERE following_id = ? AND status = 'pending'
-		`, req.FollowingID).Scan(&pendingCount)
-
-		PushToUser(req.FollowingID, map[string]any{
-			"type": "badge.follow_requests",
-			"data": map[string]any{"count": pendingCount},
-		})
+		_, _ = s.insertNotification(ctx, req.FollowingID, "follow_request", content)
+		s.pushPendingFollows(ctx, req.FollowingID)
 	}
 
-	if status == "accepted" {
+	if status == store.FollowAccepted {
+		content := map[string]any{
+			"status":      store.FollowAccepted,
+			"followingId": req.FollowingID,
+		}
 		PushToUser(userID, map[string]any{
 			"type": "notification.created",
 			"data": map[string]any{
-				"type": "follow_request.update",
-				"content": map[string]any{
-					"status":      "accepted",
-					"followingId": req.FollowingID,
-				},
+				"type":    "follow_request.update",
+				"content": content,
 			},
 		})
+		_, _ = s.insertNotification(ctx, userID, "follow_request.update", content)
+		s.pushUnread(ctx, userID)
 	}
-			_, _ = insertNotification(sqlite.DB, userID, "follow_request.update", map[string]any{
-			"status":      "accepted",
-			"followingId": req.FollowingID,
-		})
-		if uc, err := unreadCount(sqlite.DB, userID); err == nil {
-			PushToUser(userID, map[string]any{
-				"type": "badge.unread",
-				"data": map[string]any{"count": uc},
-			})
-		}
-	
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":      true,
-		"status":  status,
-		"message": fmt.Sprintf("Follow %s", status),
-	})
+
+	writeJSON(w, http.StatusOK, FollowResponse{OK: true, Status: status, Message: fmt.Sprintf("Follow %s", status)})
 }
 
-func UnFollowAUser(w http.ResponseWriter, r *http.Request) {
+// followRequestContent is the notification a private account gets when
+// follower asks to follow it.
+func followRequestContent(follower store.UserSummary) map[string]any {
+	return map[string]any{
+		"followerId": follower.ID,
+		"firstName":  follower.FirstName,
+		"lastName":   follower.LastName,
+		"nickname":   follower.Nickname,
+		"avatar":     follower.Avatar,
+		"createdAt":  time.Now().Format(time.RFC3339),
+	}
+}
+
+func (s *Server) UnFollowAUser(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -465,19 +298,13 @@ func UnFollowAUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//user that wants to unfollow someone
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := currentUserID(r)
 
 	// user that will get unfollowed
-	var req struct {
-		FollowingID string `json:"following_id"`
-	}
+	var req FollowRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -486,32 +313,20 @@ func UnFollowAUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//delete the follow
-	result, err := sqlite.DB.Exec(`
-	DELETE FROM followers
-	WHERE follower_id = ? AND following_id = ?
-	`, userID, req.FollowingID)
-
+	removed, err := s.Store.Follows.Unfollow(r.Context(), userID, req.FollowingID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	if !removed {
 		writeErr(w, http.StatusNotFound, "Not following this user")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":      true,
-		"message": "Unfollowed successfully",
-	})
-
-	return
+	writeJSON(w, http.StatusOK, MessageResponse{OK: true, Message: "Unfollowed successfully"})
 }
 
-func GetFollowStatus(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetFollowStatus(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -519,268 +334,146 @@ func GetFollowStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//user who sent a follow req
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := currentUserID(r)
 
 	//user who received a follow req
-	followingID := r.URL.Query().Get("following_id")
-	if followingID == "" {
-		writeErr(w, http.StatusBadRequest, "Following ID is required")
+	var q FollowStatusQuery
+	if err := decodeQuery(r, &q); err != nil {
+		writeError(w, err)
 		return
 	}
+	followingID := q.FollowingID
 
-	var status string
-	err = sqlite.DB.QueryRow(`
-	SELECT status FROM followers WHERE follower_id = ? AND following_id = ?
-	`, userID, followingID).Scan(&status)
-
+	status, err := s.Store.Follows.Status(r.Context(), userID, followingID)
+	if errors.Is(err, store.ErrNotFound) {
+		status, err = "not_following", nil
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			writeJSON(w, http.StatusOK, map[string]any{
-				"ok":     true,
-				"status": "not_following",
-			})
-			return
-		}
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":     true,
-		"status": status,
-	})
-
+	writeJSON(w, http.StatusOK, StatusResponse{OK: true, Status: status})
 }
 
-func GetUserFollowCounts(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetUserFollowCounts(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodGet {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID := strings.TrimPrefix(r.URL.Path, "/api/users/")
-	userID = strings.TrimSuffix(userID, "/follow-counts")
-
-	var followingCount, followersCount int
-
-	err := sqlite.DB.QueryRow(`
-	SELECT 
-		(SELECT COUNT(*) FROM followers WHERE follower_id = ? AND status = 'accepted') as following_count,
-		(SELECT COUNT(*) FROM followers WHERE following_id = ? AND status = 'accepted') as follower_count
-	`, userID, userID).Scan(&followingCount, &followersCount)
-
+	followersCount, followingCount, err := s.Store.Follows.Counts(r.Context(), r.PathValue("id"))
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":              true,
-		"following_count": followingCount,
-		"follower_count":  followersCount,
-	})
+	writeJSON(w, http.StatusOK, FollowCounts{OK: true, FollowingCount: followingCount, FollowerCount: followersCount})
+}
+
+type RespondFollowRequest struct {
+	FollowerID string `json:"follower_id"`
+	Action     string `json:"action"` //action either accept or decline
 }
 
-func RespondToFollowRequest(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RespondToFollowRequest(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, err := GetUserIDFromRequest(r)
-	if err != nil || userID == "" {
-		writeErr(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	ctx := r.Context()
+	userID := currentUserID(r)
 
-	var req struct {
-		FollowerID string `json:"follower_id"`
-		Action     string `json:"action"` //action either accept or decline
-	}
+	var req RespondFollowRequest
 
-	if err:= json.NewDecoder(r.Body).Decode(&req); err !=nil{
-		writeErr(w, http.StatusBadRequest, "Invalid request body")
-        return
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, err)
+		return
 	}
 
 	if req.FollowerID == "" || (req.Action != "accept" && req.Action != "decline") {
-        writeErr(w, http.StatusBadRequest, "Invalid parameters")
-        return
-    }
+		writeErr(w, http.StatusBadRequest, "Invalid parameters")
+		return
+	}
 
 	//get current status to check that its pending
-	var currentStatus string
-	err = sqlite.DB.QueryRow(`
-	SELECT status FROM followers
-	WHERE follower_id = ? AND following_id = ?
-	`, req.FollowerID, userID).Scan(&currentStatus)
-
-	if err!=nil{
+	currentStatus, err := s.Store.Follows.Status(ctx, req.FollowerID, userID)
+	if err != nil {
 		writeErr(w, http.StatusNotFound, "Follow request not found")
-        return
+		return
 	}
 
-	if currentStatus != "pending"{
+	if currentStatus != store.FollowPending {
 		writeErr(w, http.StatusConflict, "Request is not pending")
-        return
+		return
 	}
 
-
-	newStatus:= "accepted"
-
-	if req.Action == "decline"{
-		newStatus = "declined"
+	newStatus := store.FollowAccepted
+	if req.Action == "decline" {
+		newStatus = store.FollowDeclined
 	}
 
-	//update status to the action
-	_, err = sqlite.DB.Exec(`
-	UPDATE followers SET status = ?
-	WHERE follower_id = ? AND following_id = ?
-	`, newStatus, req.FollowerID, userID)
+	if err := s.Store.Follows.Answer(ctx, req.FollowerID, userID, newStatus); err != nil {
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
+	}
 
-	if err != nil {
-        writeErr(w, http.StatusInternalServerError, "Database error")
-        return
-    }
-	// info about the account owner (who accepted/declined)
-	var fn2, ln2, nn2, av2 string
-	_ = sqlite.DB.QueryRow(`
-		SELECT first_name, last_name, nickname, avatar
-		FROM users WHERE id = ?
-	`, userID).Scan(&fn2, &ln2, &nn2, &av2)
-
-	// notify the requester (the follower)
+	// notify the requester (the follower) about the account owner's answer
+	me, _ := s.Store.Users.Summary(ctx, userID)
+	content := map[string]any{
+		"status":      newStatus, // "accepted" or "declined"
+		"followingId": userID,
+		"firstName":   me.FirstName,
+		"lastName":    me.LastName,
+		"nickname":    me.Nickname,
+		"avatar":      me.Avatar,
+	}
 	PushToUser(req.FollowerID, map[string]any{
 		"type": "notification.created",
 		"data": map[string]any{
-			"type": "follow_request.update",
-			"content": map[string]any{
-				"status":      newStatus, // "accepted" or "declined"
-				"followingId": userID,
-				"firstName":  fn2,
-				"lastName":   ln2,
-				"nickname":    nn2,
-				"avatar":      av2,
-			},
+			"type":    "follow_request.update",
+			"content": content,
 		},
 	})
-		// DB persist for requester
-	_, _ = insertNotification(sqlite.DB, req.FollowerID, "follow_request.update", map[string]any{
-		"status":      newStatus,
-		"followingId": userID,
-		"firstName":  fn2,
-		"lastName":   ln2,
-		"nickname":    nn2,
-		"avatar":      av2,
-	})
-	if uc, err := unreadCount(sqlite.DB, req.FollowerID); err == nil {
-		PushToUser(req.FollowerID, map[string]any{
-			"type": "badge.unread",
-			"data": map[string]any{"count": uc},
-		})
-	}
+	_, _ = s.insertNotification(ctx, req.FollowerID, "follow_request.update", content)
+	s.pushUnread(ctx, req.FollowerID)
 
 	// refresh the pending badge for the account owner
-	var pendingCount2 int
-	_ = sqlite.DB.QueryRow(`
-		SELECT COUNT(*) FROM followers
-		WHERE following_id = ? AND status = 'pending'
-	`, userID).Scan(&pendingCount2)
+	s.pushPendingFollows(ctx, userID)
 
-	PushToUser(userID, map[string]any{
-		"type": "badge.follow_requests",
-		"data": map[string]any{"count": pendingCount2},
-	})
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok": true,
-		"status": newStatus,
-		"message": fmt.Sprintf("Request %sed", req.Action),
-	})
+	writeJSON(w, http.StatusOK, FollowResponse{OK: true, Status: newStatus, Message: fmt.Sprintf("Request %sed", req.Action)})
 }
 
-func GetPendingFollowRequests(w http.ResponseWriter, r *http.Request){
+func (s *Server) GetPendingFollowRequests(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-        writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
-        return
-    }
-
-    userID, err := GetUserIDFromRequest(r)
-    if err != nil || userID == "" {
-        writeErr(w, http.StatusUnauthorized, "Unauthorized")
-        return
-    }
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
 
 	//get all pending requests sent to user
-	rows, err := sqlite.DB.Query(`
-	SELECT u.id, u.first_name, u.last_name, u.avatar, f.created_at
-	FROM followers f
-	JOIN users u ON f.follower_id = u.id
-	WHERE f.following_id = ? AND f.status = 'pending'
-	ORDER BY f.created_at DESC
-	`, userID)
-
+	reqs, err := s.Store.Follows.Pending(r.Context(), currentUserID(r))
 	if err != nil {
-        writeErr(w, http.StatusInternalServerError, "Database error")
-        return
-    }
-    defer rows.Close()
-
-	type PendingRequest struct{
-		ID        string `json:"id"`
-        FirstName string `json:"firstName"`
-        LastName  string `json:"lastName"`
-        Avatar    string `json:"avatar"`
-        CreatedAt string `json:"createdAt"`
+		writeErr(w, http.StatusInternalServerError, "Database error")
+		return
 	}
 
+	writeJSON(w, http.StatusOK, PendingFollowsResponse{OK: true, Requests: reqs})
+}
 
-	var reqs []PendingRequest
-
-	for rows.Next(){
-
-		var req PendingRequest
-		err := rows.Scan(&req.ID, &req.FirstName, &req.LastName, &req.Avatar, &req.CreatedAt)
+func (s *Server) GetUserPrivacyStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
 
-		if err != nil {
-            writeErr(w, http.StatusInternalServerError, "Database error")
-            return
-        }
-		reqs = append(reqs, req)
+	isPublic, err := s.Store.Users.IsPublic(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "User not found")
+		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"ok":       true,
-       "requests": reqs,
-	})
+	writeJSON(w, http.StatusOK, PrivacyStatus{OK: true, IsPublic: isPublic})
 }
-
-func GetUserPrivacyStatus(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet {
-        writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
-        return
-    }
-
-    userID := strings.TrimPrefix(r.URL.Path, "/api/users/")
-    userID = strings.TrimSuffix(userID, "/privacy-status")
-
-    var isPublic bool
-    err := sqlite.DB.QueryRow(`
-        SELECT is_public FROM users WHERE id = ?
-    `, userID).Scan(&isPublic)
-
-    if err != nil {
-        writeErr(w, http.StatusNotFound, "User not found")
-        return
-    }
-
-    writeJSON(w, http.StatusOK, map[string]any{
-        "ok":       true,
-        "is_public": isPublic,
-    })
-}
\ No newline at end of file
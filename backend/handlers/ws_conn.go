@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second    // max time for a single frame write
+	wsPongWait       = 60 * time.Second    // client must answer a ping (or send anything) within this window
+	wsPingPeriod     = wsPongWait * 9 / 10 // must be shorter than wsPongWait
+	wsMaxMessageSize = 64 << 10            // largest inbound frame we accept
+	wsSendQueueSize  = 256                 // outbound frames buffered per connection before eviction
+)
+
+var (
+	errConnClosed   = errors.New("websocket connection closed")
+	errSlowConsumer = errors.New("websocket send queue full")
+)
+
+// WSStats counts connection lifecycle events since startup.
+type WSStats struct {
+	Connected     int64 `json:"connected"`       // currently open connections
+	Accepted      int64 `json:"accepted"`        // total upgrades
+	DroppedSlow   int64 `json:"dropped_slow"`    // evicted because their send queue overflowed
+	DroppedWrite  int64 `json:"dropped_write"`   // closed after a failed or timed out write
+	DroppedNoPong int64 `json:"dropped_no_pong"` // closed after missing the read deadline
+	FramesDropped int64 `json:"frames_dropped"`  // frames discarded for evicted connections
+}
+
+var wsStats struct {
+	connected, accepted, droppedSlow, droppedWrite, droppedNoPong, framesDropped atomic.Int64
+}
+
+// CurrentWSStats returns a snapshot of the websocket counters.
+func CurrentWSStats() WSStats {
+	return WSStats{
+		Connected:     wsStats.connected.Load(),
+		Accepted:      wsStats.accepted.Load(),
+		DroppedSlow:   wsStats.droppedSlow.Load(),
+		DroppedWrite:  wsStats.droppedWrite.Load(),
+		DroppedNoPong: wsStats.droppedNoPong.Load(),
+		FramesDropped: wsStats.framesDropped.Load(),
+	}
+}
+
+// GET /api/ws/stats
+func WSStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, CurrentWSStats())
+}
+
+// wsConn is one websocket connection. Frames are queued by SendJSON and
+// written by a dedicated writer goroutine, so a stalled client never blocks
+// the goroutine that is delivering to it.
+type wsConn struct {
+	conn   *websocket.Conn
+	userID string
+	srv    *Server
+	log    *slog.Logger // tagged with the upgrade's request id and the user
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	away     chan struct{} // closed to flush the queue and say goodbye
+	awayOnce sync.Once
+}
+
+func newWSConn(conn *websocket.Conn, userID string, srv *Server, log *slog.Logger) *wsConn {
+	return &wsConn{
+		conn:   conn,
+		userID: userID,
+		srv:    srv,
+		log:    log,
+		send:   make(chan []byte, wsSendQueueSize),
+		done:   make(chan struct{}),
+		away:   make(chan struct{}),
+	}
+}
+
+func (c *wsConn) UserID() string { return c.userID }
+
+// SendJSON queues v for delivery. It never blocks: if the queue is full the
+// client is too slow to keep up and the connection is closed.
+func (c *wsConn) SendJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-c.done:
+		wsStats.framesDropped.Add(1)
+		return errConnClosed
+	default:
+	}
+	select {
+	case c.send <- b:
+		wsFrames.Inc("out", outFrameType(v, b))
+		return nil
+	default:
+		wsStats.framesDropped.Add(1)
+		if c.shutdown() {
+			wsStats.droppedSlow.Add(1)
+			c.log.Warn("ws: evicting slow consumer", "reason", "queue full")
+		}
+		return errSlowConsumer
+	}
+}
+
+// shutdown stops the writer and closes the socket; it reports whether this
+// call was the one that closed it.
+func (c *wsConn) shutdown() bool {
+	closed := false
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.conn.Close()
+		closed = true
+	})
+	return closed
+}
+
+func (c *wsConn) Close() error {
+	c.shutdown()
+	return nil
+}
+
+// goAway queues frame as the last one, then has the writer flush the queue
+// and close the socket with 1001 Going Away.
+func (c *wsConn) goAway(frame any) {
+	c.awayOnce.Do(func() {
+		_ = c.SendJSON(frame)
+		close(c.away)
+	})
+}
+
+// flushAndClose writes whatever is still queued and a close frame. Called by
+// the writer only.
+func (c *wsConn) flushAndClose() {
+	for {
+		select {
+		case b := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				c.shutdown()
+				return
+			}
+		default:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+			c.shutdown()
+			return
+		}
+	}
+}
+
+// writePump owns all writes to the socket: queued frames and keepalive pings.
+func (c *wsConn) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case b := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				if c.shutdown() {
+					wsStats.droppedWrite.Add(1)
+				}
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				if c.shutdown() {
+					wsStats.droppedWrite.Add(1)
+				}
+				return
+			}
+		case <-c.away:
+			c.flushAndClose()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// prepareRead sets the inbound size limit and arms the read deadline, which
+// every pong (and every frame read by the main loop) pushes forward.
+func (c *wsConn) prepareRead() {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+}
+
+// readEnvelope reads the next frame and extends the read deadline. A frame
+// that isn't a valid envelope comes back as a bad_json *FrameError.
+func (c *wsConn) readEnvelope() (Envelope, error) {
+	var env Envelope
+	_, b, err := c.conn.ReadMessage()
+	if err != nil {
+		var ne interface{ Timeout() bool }
+		if errors.As(err, &ne) && ne.Timeout() {
+			wsStats.droppedNoPong.Add(1)
+		}
+		return env, err
+	}
+	if err := c.conn.SetReadDeadline(time.Now().Add(wsPongWait)); err != nil {
+		return env, err
+	}
+	if err := json.Unmarshal(b, &env); err != nil {
+		return env, frameErr("bad_json", err.Error())
+	}
+	return env, nil
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsProtocolVersion is bumped on incompatible frame changes. Clients that
+// omit "v" are treated as speaking version 1.
+const wsProtocolVersion = 1
+
+// Envelope is a client frame. ID is an optional client request id; when set
+// the server answers with an ack or nack carrying the same id.
+type Envelope struct {
+	V    int             `json:"v,omitempty"`
+	ID   string          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Frame is a server frame.
+type Frame struct {
+	V      int    `json:"v"`
+	Type   string `json:"type"`
+	Data   any    `json:"data,omitempty"`
+	Replay bool   `json:"replay,omitempty"` // set on frames re-sent by resume
+}
+
+func newFrame(typ string, data any) Frame {
+	return Frame{V: wsProtocolVersion, Type: typ, Data: data}
+}
+
+type AckData struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Result any    `json:"result,omitempty"`
+}
+
+type NackData struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds, set with rate_limited
+}
+
+type ErrorData struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// FrameError is returned by frame handlers to reject a request with one of
+// the protocol error codes (bad_dm, dm_denied, not_member, ...). It is the
+// same type the HTTP API answers with; only Code, Message and RetryAfter
+// reach the client.
+type FrameError = APIError
+
+func frameErr(code, msg string) *FrameError {
+	return &FrameError{Code: code, Message: msg}
+}
+
+// errPayload is the legacy error frame, still sent for requests without an id.
+func errPayload(code, msg string) Frame {
+	return newFrame("error", ErrorData{Code: code, Message: msg})
+}
+
+// frameHandler handles one client frame. The result is returned to the
+// client in the ack; a non-nil error becomes a nack.
+type frameHandler func(s *Server, client *wsConn, userID string, data json.RawMessage) (any, error)
+
+type clientFrame struct {
+	Doc    string
+	In     any // zero value of the data payload, used for the schema
+	Result any // zero value of the ack result, nil if the ack carries none
+	handle frameHandler
+
+	ownLimit bool // the handler applies its own rate limit instead of one per user
+	verified bool // only accounts with a confirmed email may send it
+}
+
+// clientFrames is the registry of every frame type a client may send.
+var clientFrames = map[string]clientFrame{
+	"dm": {
+		Doc: "Send a direct message. Requires a follow relationship and a confirmed email.",
+		In:  DMIn{}, Result: DMOut{}, handle: (*Server).handleDM, verified: true,
+	},
+	"typing": {
+		Doc: "Tell a DM peer you are typing.",
+		In:  TypingIn{}, handle: (*Server).handleTyping,
+	},
+	"group_message": {
+		Doc: "Send a message to a group you are an accepted member of. Requires a confirmed email.",
+		In:  GroupMsgIn{}, Result: GroupMsgOut{}, handle: (*Server).handleGroupMessage, verified: true,
+	},
+	"reaction": {
+		Doc: "Toggle an emoji reaction on a DM or group message.",
+		In:  ReactionIn{}, Result: ReactionEvent{}, handle: (*Server).handleReaction,
+	},
+	"group_typing": {
+		Doc: "Tell the other online members of a group you are typing.",
+		In:  GroupTypingIn{}, handle: (*Server).handleGroupTyping, ownLimit: true,
+	},
+	"group_read": {
+		Doc: "Move your read marker in a group forward.",
+		In:  GroupReadIn{}, Result: GroupUnreadData{}, handle: (*Server).handleGroupRead,
+	},
+	"activity": {
+		Doc: "Report whether this tab is active, idle or away.",
+		In:  ActivityIn{}, handle: (*Server).handleActivity,
+	},
+	"resume": {
+		Doc: "Replay messages missed while disconnected.",
+		In:  ResumeIn{}, Result: ResumeDone{}, handle: (*Server).handleResume,
+	},
+}
+
+// serverFrames documents every frame type the server pushes; nil data means a
+// free-form object.
+var serverFrames = map[string]any{
+	"ack":                   AckData{},
+	"nack":                  NackData{},
+	"error":                 ErrorData{},
+	"hello":                 HelloData{},
+	"presence_snapshot":     PresenceSnapshotData{},
+	"presence":              PresenceData{},
+	"dm":                    DMOut{},
+	"typing":                TypingData{},
+	"group_message":         GroupMsgOut{},
+	"group_typing":          GroupTypingData{},
+	"group_unread":          GroupUnreadData{},
+	"reaction":              ReactionEvent{},
+	"resume.done":           ResumeDone{},
+	"server.shutdown":       ShutdownData{},
+	"badge.unread":          CountData{},
+	"badge.follow_requests": CountData{},
+	"notification.created":  nil,
+	"group_invite":          nil,
+	"group_invite.accepted": nil,
+	"group_invite.declined": nil,
+	"group_request.created": nil,
+	"group_request.update":  nil,
+	"group_deleted":         nil,
+	"user_left_group":       nil,
+	"request_to_join_group": nil,
+	"invite_users_to_group": nil,
+
+	"initial_group_invite_list":        nil,
+	"remove_initial_group_invite_list": nil,
+}
+
+type HelloData struct {
+	UserID  string `json:"userId"`
+	Version int    `json:"version"`
+}
+
+// PresenceSnapshotData covers the user's contacts only; Online is kept for
+// older clients and lists the contacts whose status isn't offline.
+type PresenceSnapshotData struct {
+	Online   []string       `json:"online"`
+	Contacts []PresenceData `json:"contacts"`
+}
+
+type PresenceData struct {
+	UserID     string `json:"userId"`
+	Online     bool   `json:"online"`
+	Status     string `json:"status"` // online, idle, away or offline
+	LastSeenAt string `json:"last_seen_at,omitempty"`
+}
+
+type CountData struct {
+	Count int `json:"count"`
+}
+
+// dispatch runs the registered handler for env and answers with ack/nack
+// when the client supplied a request id.
+func (s *Server) dispatch(client *wsConn, userID string, env Envelope) {
+	reply := func(err error) {
+		var fe *FrameError
+		if !errors.As(err, &fe) {
+			fe = frameErr("internal", err.Error())
+		}
+		retry := 0
+		if fe.RetryAfter > 0 {
+			retry = retryAfterSeconds(fe.RetryAfter)
+		}
+		if env.ID == "" {
+			_ = client.SendJSON(newFrame("error", ErrorData{Code: fe.Code, Message: fe.Message, RetryAfter: retry}))
+			return
+		}
+		_ = client.SendJSON(newFrame("nack", NackData{ID: env.ID, Type: env.Type, Code: fe.Code, Message: fe.Message, RetryAfter: retry}))
+	}
+
+	if env.V > wsProtocolVersion {
+		reply(frameErr("unsupported_version", fmt.Sprintf("server speaks protocol version %d", wsProtocolVersion)))
+		return
+	}
+	spec, ok := clientFrames[env.Type]
+	if !ok {
+		wsFrames.Inc("in", "unknown")
+		reply(frameErr("unsupported_type", env.Type))
+		return
+	}
+	wsFrames.Inc("in", env.Type)
+
+	if !spec.ownLimit {
+		if ok, wait := s.Limits.Allow(env.Type, userID); !ok {
+			reply(&FrameError{Code: CodeRateLimited, Message: "Too many " + env.Type + " frames, slow down", RetryAfter: wait})
+			return
+		}
+	}
+
+	if spec.verified && !emailVerified(s.DB, userID) {
+		reply(frameErr("email_unverified", "Confirm your email address first"))
+		return
+	}
+
+	result, err := spec.handle(s, client, userID, env.Data)
+	if err != nil {
+		reply(err)
+		return
+	}
+	if env.ID != "" {
+		_ = client.SendJSON(newFrame("ack", AckData{ID: env.ID, Type: env.Type, Result: result}))
+	}
+}
+
+// decodeFrame unmarshals a frame payload, mapping failures to bad_json.
+func decodeFrame(data json.RawMessage, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return frameErr(CodeBadJSON, err.Error())
+	}
+	return nil
+}
+
+var (
+	wsSchemaOnce sync.Once
+	wsSchemaJSON []byte
+)
+
+// GET /api/ws/schema.json
+func WSSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	wsSchemaOnce.Do(func() {
+		wsSchemaJSON, _ = json.MarshalIndent(wsSchema(), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(wsSchemaJSON)
+}
+
+// wsSchema builds a JSON Schema document describing the envelope and the
+// payload of every client and server frame, generated from the Go types.
+func wsSchema() map[string]any {
+	g := schemaGen{defs: map[string]any{}, refPrefix: "#/$defs/"}
+
+	client := map[string]any{}
+	for _, name := range sortedKeys(clientFrames) {
+		spec := clientFrames[name]
+		entry := map[string]any{
+			"description": spec.Doc,
+			"data":        g.schemaFor(reflect.TypeOf(spec.In)),
+		}
+		if spec.Result != nil {
+			entry["ack_result"] = g.schemaFor(reflect.TypeOf(spec.Result))
+		}
+		client[name] = entry
+	}
+
+	server := map[string]any{}
+	for _, name := range sortedKeys(serverFrames) {
+		data := map[string]any{"type": "object"}
+		if v := serverFrames[name]; v != nil {
+			data = g.schemaFor(reflect.TypeOf(v))
+		}
+		server[name] = map[string]any{"data": data}
+	}
+
+	return map[string]any{
+		"$schema":       "https://json-schema.org/draft/2020-12/schema",
+		"title":         "WebSocket protocol",
+		"version":       wsProtocolVersion,
+		"envelope":      g.schemaFor(reflect.TypeOf(Envelope{})),
+		"frame":         g.schemaFor(reflect.TypeOf(Frame{})),
+		"client_frames": client,
+		"server_frames": server,
+		"$defs":         g.defs,
+	}
+}
+
+// schemaGen turns Go types into JSON Schema, collecting named structs under
+// refPrefix; the websocket schema and the OpenAPI document both use it.
+type schemaGen struct {
+	defs      map[string]any
+	refPrefix string
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+	fileType       = reflect.TypeOf(&multipart.FileHeader{})
+)
+
+func (g *schemaGen) schemaFor(t reflect.Type) map[string]any {
+	switch t {
+	case rawMessageType:
+		return map[string]any{}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case fileType: // multipart form uploads
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.schemaFor(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // placeholder so recursive types terminate
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": g.refPrefix + t.Name()}
+	default: // interfaces: any JSON value
+		return map[string]any{}
+	}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			// encoding/json promotes the fields of an untagged embedded struct
+			inner := g.structSchema(f.Type)
+			for name, p := range inner["properties"].(map[string]any) {
+				props[name] = p
+			}
+			required = append(required, inner["required"].([]string)...)
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,187 +1,283 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
-	"path/filepath"
-	"strings"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	Handlers "backend/handlers"
+	"backend/pkg/broker"
+	"backend/pkg/config"
 	"backend/pkg/db/sqlite"
+	"backend/pkg/mailer"
+	"backend/pkg/metrics"
+	"backend/pkg/ratelimit"
+	"backend/pkg/router"
 )
 
 func main() {
+	// Config: env vars, optionally on top of the JSON file named by CONFIG_FILE
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("config", err)
+	}
+	slog.SetDefault(cfg.Logger())
+	if err := Handlers.Configure(cfg); err != nil {
+		fatal("config", err)
+	}
+
+	// Upload directories; /healthz/ready fails if they go missing later
+	for _, dir := range []string{cfg.UploadsDir, cfg.AttachmentsDir} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			fatal("uploads", err)
+		}
+	}
+
 	// DB
-	sqlite.InitDB()
+	sqlite.InitDB(cfg.DBPath, cfg.MigrationsPath)
 
-	// WS
+	// Rate limits; RATE_LIMITS overrides them, e.g. "login=10/5m,dm=20/10s:40"
+	limits := Handlers.DefaultRateLimits()
+	overrides, err := ratelimit.ParseLimits(cfg.RateLimits)
+	if err != nil {
+		fatal("rate limits", err)
+	}
+	for action, lim := range overrides {
+		limits[action] = lim
+	}
+	limiter := ratelimit.New(limits)
+
+	// Mail; with MAIL_DIR set messages are written there instead of logged
+	if cfg.MailDir != "" {
+		m, err := mailer.NewFile(cfg.MailDir)
+		if err != nil {
+			fatal("mail", err)
+		}
+		Handlers.Mail = m
+	}
+
+	// WS; set REDIS_ADDR to share delivery and presence with other instances
 	hub := Handlers.NewHub()
+	if addr := cfg.RedisAddr; addr != "" {
+		b, err := broker.DialRedis(addr)
+		if err != nil {
+			fatal("pub/sub", err)
+		}
+		if hub, err = Handlers.NewHubWithBroker(b); err != nil {
+			fatal("pub/sub", err)
+		}
+		slog.Info("WebSocket hub using pub/sub", "addr", addr)
+	}
 	wsServer := &Handlers.Server{
 		Hub:               hub,
 		DB:                sqlite.DB,
+		Store:             sqlite.NewStore(sqlite.DB),
 		UserIDFromRequest: Handlers.GetUserIDFromRequest,
+		Limits:            limiter,
 	}
 	Handlers.WS = wsServer
+	hub.SetPresenceScope(wsServer)
+	Handlers.RegisterHubMetrics(hub)
+
+	routes := newRoutes(cfg, limiter, wsServer)
+	// Every route needs an entry in the OpenAPI docs (main_test.go checks it);
+	// without one /api/openapi.json answers 503 but the API still serves
+	if err := Handlers.LoadOpenAPI(routes.Routes()); err != nil {
+		slog.Error("openapi", "err", err)
+	}
+
+	// Outermost: request ids and one access log line per request
+	srv := &http.Server{Addr: cfg.Addr(), Handler: Handlers.AccessLog(routes.Handler())}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting backend server", "url", "http://localhost:"+cfg.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		fatal("http", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+	slog.Info("Shutting down, draining connections", "timeout", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Shutdown closes the listener and waits for in-flight requests but doesn't
+	// track hijacked websockets, so those are drained alongside it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := wsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("ws: shutdown", "err", err)
+		}
+	}()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http: shutdown", "err", err)
+	}
+	wg.Wait()
+
+	if err := Handlers.FlushMail(shutdownCtx); err != nil {
+		slog.Error("mail: shutdown", "err", err)
+	}
+	if err := hub.Close(); err != nil {
+		slog.Error("hub: close", "err", err)
+	}
+	if err := sqlite.Close(); err != nil {
+		slog.Error("sqlite: close", "err", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// newRoutes is the route table; handlers read path parameters with r.PathValue.
+func newRoutes(cfg *config.Config, limiter *ratelimit.Limiter, wsServer *Handlers.Server) *router.Router {
+	rateLimit := func(action string) router.Middleware {
+		return func(h http.HandlerFunc) http.HandlerFunc { return Handlers.RateLimited(limiter, action, h) }
+	}
+	routes := router.New()
 
 	// WebSocket endpoint (needs special handling for CORS)
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers for WebSocket
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+	routes.Get("/ws", func(w http.ResponseWriter, r *http.Request) {
+		// Set CORS headers for WebSocket; the upgrader checks the origin itself
+		allowOrigin(w, r)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		wsServer.HandleWS(w, r)
 	})
+	// Prometheus scrape target; keep it off the public network
+	routes.Get("/metrics", metrics.Default.Handler())
+	health := &Handlers.Health{
+		MigrationsPath: cfg.MigrationsPath,
+		UploadsDir:     cfg.UploadsDir,
+		AttachmentsDir: cfg.AttachmentsDir,
+		Server:         wsServer,
+	}
+	routes.Get("/healthz", health.Live) // older probes
+	routes.Get("/healthz/live", health.Live)
+	routes.Get("/healthz/ready", health.Ready)
 
-	// Apply CORS to all API endpoints
-	http.HandleFunc("/api/messages", corsHandler(Handlers.HistoryHandler(sqlite.DB, wsServer.UserIDFromRequest)))
-
-	http.HandleFunc("/api/me", corsHandler(Handlers.MeHandler))
-	http.HandleFunc("/api/logout", corsHandler(Handlers.LogoutHandler))
-	http.HandleFunc("/api/register", corsHandler(Handlers.RegisterHandler))
-	http.HandleFunc("/api/login", corsHandler(Handlers.LoginHandler))
-
-	http.HandleFunc("/api/posts", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			Handlers.CreatePostHandler(sqlite.DB)(w, r)
-		case http.MethodGet:
-			Handlers.GetAllPostsHandler(sqlite.DB)(w, r)
-		default:
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		}
-	}))
+	// Static file serving with CORS
+	fs := http.StripPrefix("/uploads/", http.FileServer(http.Dir(cfg.UploadsDir)))
+	routes.Get("/uploads/", fs.ServeHTTP, corsHandler)
 
-	http.HandleFunc("/api/comments", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			Handlers.CommentsHandler(sqlite.DB)(w, r)
-			return
-		}
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-	}))
+	// Apply CORS to all API endpoints; the session is looked up once, here
+	api := routes.Group("/api", corsHandler, Handlers.OptionalAuth)
+	// Everything below "authed" answers 401 without a session
+	authed := api.Group("", Handlers.RequireAuth).Tagged(Handlers.TagAuth)
 
-	http.HandleFunc("/api/likes", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			Handlers.LikesHandler(sqlite.DB)(w, r)
-			return
-		}
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-	}))
+	api.Get("/ws/stats", Handlers.WSStatsHandler)
+	api.Get("/ws/schema.json", Handlers.WSSchemaHandler)
+	api.Get("/openapi.json", Handlers.OpenAPIHandler)
 
-	// Static file serving with CORS
-	uploadsDir := filepath.Join(".", "uploads")
-	fs := http.FileServer(http.Dir(uploadsDir))
-	http.Handle("/uploads/", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		http.StripPrefix("/uploads/", fs).ServeHTTP(w, r)
-	}))
-
-	http.HandleFunc("/api/users/toggle-privacy", corsHandler(Handlers.ToggleProfilePrivacyHandler))
-	http.HandleFunc("/api/users", corsHandler(Handlers.GetAllUsersHandler(sqlite.DB)))
-	http.HandleFunc("/api/users/follow", corsHandler(Handlers.FollowUser))
-	http.HandleFunc("/api/users/unfollow", corsHandler(Handlers.UnFollowAUser))
-	http.HandleFunc("/api/users/follow-status", corsHandler(Handlers.GetFollowStatus))
-	http.HandleFunc("/api/users/pending-requests", corsHandler(Handlers.GetPendingFollowRequests))
-	http.HandleFunc("/api/users/respond-follow-request", corsHandler(Handlers.RespondToFollowRequest))
-	http.HandleFunc("/api/relationships", corsHandler(Handlers.GetRelationships))
-	http.HandleFunc("/api/relationshipsForPost", corsHandler(Handlers.GetRelationshipsForPost))
-	
-	http.HandleFunc("/api/users/", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		switch {
-		case strings.HasSuffix(r.URL.Path, "/posts"):
-			Handlers.GetUserPostsHandler(w, r)
-		case strings.HasSuffix(r.URL.Path, "/followers"):
-			Handlers.GetUserFollowers(w, r)
-		case strings.HasSuffix(r.URL.Path, "/followings"):
-			Handlers.GetUserFollowing(w, r)
-		case strings.HasSuffix(r.URL.Path, "/follow-counts"):
-			Handlers.GetUserFollowCounts(w, r)
-		case strings.HasSuffix(r.URL.Path, "/privacy-status"):
-			Handlers.GetUserPrivacyStatus(w, r)
-		case strings.HasSuffix(r.URL.Path, "/user"):
-			Handlers.GetUser(w, r)
-		case r.Method == http.MethodGet &&
-			!strings.HasSuffix(r.URL.Path, "/posts") &&
-			!strings.HasSuffix(r.URL.Path, "/privacy-status"):
-			Handlers.GetUserProfileHandler(w, r)
-		default:
-			http.NotFound(w, r)
-		}
-	}))
-
-	http.HandleFunc("/api/group/groups", corsHandler(Handlers.GetAllGroups))
-	http.HandleFunc("/api/group/initial-invite", corsHandler(Handlers.GetUsersForInitialInvite))
-	http.HandleFunc("/api/group/create-group", corsHandler(Handlers.CreateGroup))
-	http.HandleFunc("/api/group/panelItems", corsHandler(Handlers.GetUserPanelItems))
-	http.HandleFunc("/api/group/respond-group-invite", corsHandler(Handlers.RespondToInvite))
-	http.HandleFunc("/api/group/leave-group", corsHandler(Handlers.LeaveGroup))
-	http.HandleFunc("/api/group/delete-group", corsHandler(Handlers.DeleteGroup))
-	http.HandleFunc("/api/group/invite-users-list", corsHandler(Handlers.GetUsersForGroupInvite))
-	http.HandleFunc("/api/group/invite-users", corsHandler(Handlers.InviteUsersToGroup))
-	http.HandleFunc("/api/group/request-to-join", corsHandler(Handlers.RequestToJoinGroup))
-	http.HandleFunc("/api/group/respond-group-request", corsHandler(Handlers.RespondToUserRequestToGroup))
-	http.HandleFunc("/api/group/check-join-status", corsHandler(Handlers.CheckUserJoinStatus))
-	http.HandleFunc("/api/group/messages", corsHandler(Handlers.GetGroupMessages))
-	http.HandleFunc("/api/group/members", corsHandler(Handlers.GetGroupMembers))
-
-	http.HandleFunc("/api/group/events", corsHandler(Handlers.GetEventsForGroup))
-	http.HandleFunc("/api/group/create-event", corsHandler(Handlers.CreateEvent))
-	http.HandleFunc("/api/group/event-vote", corsHandler(Handlers.VoteToEvent))
-
-	http.HandleFunc("/api/group/posts", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			Handlers.ListGroupPostsHandler(sqlite.DB)(w, r)
-			return
-		}
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-	}))
-	
-	http.HandleFunc("/api/group/posts/create", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			Handlers.CreateGroupPostHandler(sqlite.DB)(w, r)
-			return
-		}
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-	}))
-
-	http.HandleFunc("/api/group/post/comments", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			Handlers.ListPostCommentsHandler(sqlite.DB)(w, r)
-		case http.MethodPost:
-			Handlers.CreatePostCommentHandler(sqlite.DB)(w, r)
-		default:
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		}
-	}))
-	// --- Notifications API ---
-http.HandleFunc("/api/notifications/unread-count",
-    corsHandler(wsServer.GetUnreadCount))
-
-http.HandleFunc("/api/notifications/read-by-message",
-    corsHandler(wsServer.ReadByMessageID))
-
-http.HandleFunc("/api/notifications/", corsHandler(func(w http.ResponseWriter, r *http.Request) {
-    // Expecting: POST /api/notifications/{id}/read
-    if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/read") {
-        wsServer.ReadNotification(w, r)
-        return
-    }
-    http.NotFound(w, r)
-}))
-
-http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-    w.WriteHeader(http.StatusOK)
-    _, _ = w.Write([]byte("ok"))
-})
-	fmt.Println("Starting backend server on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Account
+	api.Post("/logout", Handlers.LogoutHandler)
+	api.Post("/register", Handlers.RegisterHandler, rateLimit("register"))
+	api.Post("/login", wsServer.LoginHandler, rateLimit("login"))
+	api.Post("/login/2fa", wsServer.LoginTwoFactorHandler, rateLimit("login"))
+	api.Post("/password/forgot", Handlers.ForgotPasswordHandler, rateLimit("reset"))
+	api.Post("/password/reset", Handlers.ResetPasswordHandler, rateLimit("reset"))
+	api.Post("/email/verify", Handlers.VerifyEmailHandler)
+	authed.Post("/email/resend", Handlers.ResendVerificationHandler, rateLimit("email"))
+	authed.Get("/me", Handlers.MeHandler)
+	authed.Post("/me", Handlers.MeHandler)
+
+	// Security settings; everything that needs the password shares its limit
+	security := authed.Group("/users", rateLimit("password"))
+	security.Post("/change-password", Handlers.ChangePasswordHandler)
+	security.Post("/2fa/enroll", Handlers.EnrollTwoFactorHandler)
+	security.Post("/2fa/activate", Handlers.ActivateTwoFactorHandler)
+	security.Post("/2fa/disable", Handlers.DisableTwoFactorHandler)
+	security.Post("/2fa/recovery-codes", Handlers.RegenerateRecoveryCodesHandler)
+	authed.Get("/users/2fa", Handlers.TwoFactorStatusHandler)
+	authed.Get("/users/login-attempts", Handlers.GetLoginAttemptsHandler)
+
+	// Chat
+	authed.Get("/messages", wsServer.HistoryHandler)
+	// Chat attachments (served only to conversation participants, not via /uploads/)
+	authed.Post("/chat/attachments", Handlers.UploadChatAttachmentHandler, rateLimit("attachment"), Handlers.RequireVerified)
+	authed.Get("/chat/attachments/{id}", wsServer.GetChatAttachmentHandler)
+
+	// Posts
+	authed.Get("/posts", wsServer.GetAllPostsHandler)
+	authed.Post("/posts", wsServer.CreatePostHandler, rateLimit("post"), Handlers.RequireVerified)
+	authed.Post("/comments", Handlers.CommentsHandler(sqlite.DB), rateLimit("comment"), Handlers.RequireVerified)
+	authed.Post("/likes", Handlers.LikesHandler(sqlite.DB), rateLimit("like"))
+	authed.Get("/relationships", Handlers.GetRelationships)
+	authed.Get("/relationshipsForPost", Handlers.GetRelationshipsForPost)
+
+	// Users and followers; the public bits of a profile need no session
+	api.Get("/users", wsServer.GetAllUsersHandler)
+	api.Get("/users/{id}/user", Handlers.GetUser)
+	api.Get("/users/{id}/follow-counts", wsServer.GetUserFollowCounts)
+	api.Get("/users/{id}/privacy-status", wsServer.GetUserPrivacyStatus)
+	users := authed.Group("/users")
+	users.Post("/toggle-privacy", wsServer.ToggleProfilePrivacyHandler)
+	users.Post("/toggle-presence", Handlers.ToggleHidePresenceHandler)
+	users.Post("/follow", wsServer.FollowUser)
+	users.Post("/unfollow", wsServer.UnFollowAUser)
+	users.Get("/follow-status", wsServer.GetFollowStatus)
+	users.Get("/pending-requests", wsServer.GetPendingFollowRequests)
+	users.Post("/respond-follow-request", wsServer.RespondToFollowRequest)
+	users.Get("/{id}", wsServer.GetUserProfileHandler)
+	users.Get("/{id}/posts", wsServer.GetUserPostsHandler)
+	users.Get("/{id}/followers", wsServer.GetUserFollowers)
+	users.Get("/{id}/followings", wsServer.GetUserFollowing)
+
+	// Groups
+	group := authed.Group("/group")
+	group.Get("/groups", wsServer.GetAllGroups)
+	group.Get("/initial-invite", wsServer.GetUsersForInitialInvite)
+	group.Post("/create-group", wsServer.CreateGroup)
+	group.Get("/panelItems", wsServer.GetUserPanelItems)
+	group.Post("/respond-group-invite", wsServer.RespondToInvite)
+	group.Delete("/leave-group", wsServer.LeaveGroup)
+	group.Delete("/delete-group", wsServer.DeleteGroup)
+	group.Get("/invite-users-list", wsServer.GetUsersForGroupInvite)
+	group.Post("/invite-users", wsServer.InviteUsersToGroup)
+	group.Post("/request-to-join", wsServer.RequestToJoinGroup)
+	group.Post("/respond-group-request", wsServer.RespondToUserRequestToGroup)
+	group.Get("/check-join-status", wsServer.CheckUserJoinStatus)
+	group.Get("/messages", wsServer.GetGroupMessages)
+	group.Post("/messages/read", wsServer.MarkGroupMessagesRead)
+	group.Get("/members", wsServer.GetGroupMembers)
+
+	group.Get("/events", wsServer.GetEventsForGroup)
+	group.Post("/create-event", wsServer.CreateEvent)
+	group.Post("/event-vote", wsServer.VoteToEvent)
+
+	group.Get("/posts", wsServer.ListGroupPostsHandler(sqlite.DB))
+	group.Post("/posts/create", wsServer.CreateGroupPostHandler(sqlite.DB), rateLimit("post"), Handlers.RequireVerified)
+	group.Get("/post/comments", Handlers.ListPostCommentsHandler(sqlite.DB))
+	group.Post("/post/comments", Handlers.CreatePostCommentHandler(sqlite.DB), rateLimit("comment"), Handlers.RequireVerified)
+
+	// Notifications
+	authed.Get("/notifications/unread-count", wsServer.GetUnreadCount)
+	authed.Post("/notifications/read-by-message", wsServer.ReadByMessageID)
+	authed.Post("/notifications/{id}/read", wsServer.ReadNotification)
+
+	return routes
+}
+
+// fatal logs err and exits; for setup failures before the server runs.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
 // CORS middleware
 func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+		allowOrigin(w, r)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Content-Length, Accept-Language, Accept-Encoding, Connection, Access-Control-Allow-Origin, Authorization")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
@@ -198,6 +294,16 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// allowOrigin lets a trusted frontend read the response; anyone else gets no CORS headers.
+func allowOrigin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	if origin := r.Header.Get("Origin"); Handlers.OriginAllowed(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// corsHandler wraps every API route: CORS headers, then the CSRF origin check
+// for anything that isn't a read.
 func corsHandler(handler http.HandlerFunc) http.HandlerFunc {
-	return enableCORS(handler)
+	return enableCORS(Handlers.CSRFProtect(handler))
 }
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	Handlers "backend/handlers"
+	"backend/pkg/config"
+	"backend/pkg/db/sqlite"
+	"backend/pkg/store"
+)
+
+// Every route main serves must be an operation of the OpenAPI document.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	routes := newRoutes(&config.Config{}, nil, &Handlers.Server{})
+	if err := Handlers.LoadOpenAPI(routes.Routes()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	Handlers.OpenAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	ops := 0
+	for _, p := range doc.Paths {
+		ops += len(p)
+	}
+	if ops != len(routes.Routes()) {
+		t.Errorf("%d operations for %d routes", ops, len(routes.Routes()))
+	}
+	for _, rt := range routes.Routes() {
+		path := rt.Pattern
+		if strings.HasSuffix(path, "/") {
+			path += "{path}"
+		}
+		if _, ok := doc.Paths[path][strings.ToLower(rt.Method)]; !ok {
+			t.Errorf("no operation for %s %s", rt.Method, rt.Pattern)
+		}
+	}
+}
+
+// specFixture serves the real routes over a fresh database, signed in as a
+// verified user who has a follower, a post, a group with an event, a group
+// post and chat messages, so list responses aren't empty.
+type specFixture struct {
+	handler http.Handler
+	doc     map[string]any
+	me      string
+	samples url.Values // a working value for each query parameter
+}
+
+func newSpecFixture(t *testing.T) *specFixture {
+	t.Helper()
+	prevDB, prevWS := sqlite.DB, Handlers.WS
+	sqlite.InitDB(filepath.Join(t.TempDir(), "spec.db"), "database/migrations/sqlite")
+	t.Cleanup(func() {
+		sqlite.DB.Close()
+		sqlite.DB, Handlers.WS = prevDB, prevWS
+	})
+	repos := sqlite.NewStore(sqlite.DB)
+	ctx := context.Background()
+
+	user := func(email, nickname string) string {
+		t.Helper()
+		if err := sqlite.InsertUser(email, "x", nickname, "Test", "1990-01-01", "", nickname, ""); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := sqlite.GetUserID(email)
+		if _, err := sqlite.DB.Exec(`UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice, bob := user("alice@example.com", "alice"), user("bob@example.com", "bob")
+	must(repos.Follows.Follow(ctx, bob, alice, store.FollowAccepted))
+	must(repos.Follows.Follow(ctx, alice, bob, store.FollowAccepted))
+	_, err := repos.Posts.Create(ctx, store.NewPost{UserID: alice, Content: "hello", Privacy: "public"})
+	must(err)
+	groupID, _, err := repos.Groups.Create(ctx, store.Group{Title: "Walks", Description: "Sunday walks", CreatorID: alice}, nil)
+	must(err)
+	gid := strconv.FormatInt(groupID, 10)
+	must(repos.Groups.AddMember(ctx, gid, bob, store.MemberAccepted))
+	_, err = repos.Events.Create(ctx, store.Event{GroupID: gid, Title: "Walk", Description: "By the river", Datetime: time.Now().Add(24 * time.Hour), CreatedBy: alice})
+	must(err)
+	dmID, _, err := repos.Messages.CreateDM(ctx, store.Message{SenderID: bob, ReceiverID: alice, Content: "hi"})
+	must(err)
+	_, _, err = repos.Messages.CreateDM(ctx, store.Message{SenderID: alice, ReceiverID: bob, Content: "hello", ReplyTo: dmID})
+	must(err)
+	_, err = repos.Messages.ToggleReaction(ctx, "dm", dmID, alice, "👋")
+	must(err)
+	_, _, err = repos.Messages.CreateGroupMessage(ctx, store.Message{SenderID: bob, GroupID: gid, Content: "hi all"})
+	must(err)
+	res, err := sqlite.DB.Exec(`INSERT INTO group_posts (group_id, user_id, content, image) VALUES (?, ?, 'see you', '')`, gid, alice)
+	must(err)
+	groupPostID, _ := res.LastInsertId()
+	_, err = sqlite.DB.Exec(`INSERT INTO post_Comments (post_id, user_id, content) VALUES (?, ?, 'me too')`, groupPostID, bob)
+	must(err)
+	_, err = repos.Notifications.Create(ctx, alice, "follow_request", json.RawMessage(`{}`))
+	must(err)
+
+	wsServer := &Handlers.Server{Hub: Handlers.NewHub(), DB: sqlite.DB, Store: repos}
+	Handlers.WS = wsServer
+	routes := newRoutes(&config.Config{UploadsDir: t.TempDir(), AttachmentsDir: t.TempDir()}, nil, wsServer)
+	must(Handlers.LoadOpenAPI(routes.Routes()))
+	rec := httptest.NewRecorder()
+	Handlers.OpenAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	var doc map[string]any
+	must(json.Unmarshal(rec.Body.Bytes(), &doc))
+
+	h := routes.Handler()
+	return &specFixture{
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(Handlers.WithPrincipal(r.Context(), Handlers.Principal{UserID: alice})))
+		}),
+		doc: doc,
+		me:  alice,
+		samples: url.Values{
+			"group_id":     {gid},
+			"post_id":      {strconv.FormatInt(groupPostID, 10)},
+			"peer_id":      {bob},
+			"following_id": {bob},
+			"timezone":     {"UTC"},
+		},
+	}
+}
+
+// specOp is one operation of the document with what it takes as input.
+type specOp struct {
+	method, path string
+	op           map[string]any
+	query        map[string]map[string]any // name -> parameter
+	form         map[string]any            // form body schema
+	body         map[string]any            // JSON body schema
+}
+
+func (f *specFixture) ops() []specOp {
+	var ops []specOp
+	for path, item := range f.doc["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			op := op.(map[string]any)
+			o := specOp{method: strings.ToUpper(method), path: path, op: op, query: map[string]map[string]any{}}
+			params, _ := op["parameters"].([]any)
+			for _, p := range params {
+				p := p.(map[string]any)
+				if p["in"] == "query" {
+					o.query[p["name"].(string)] = p
+				}
+			}
+			if rb, ok := op["requestBody"].(map[string]any); ok {
+				content := rb["content"].(map[string]any)
+				if c, ok := content["multipart/form-data"].(map[string]any); ok {
+					o.form = c["schema"].(map[string]any)
+				}
+				if c, ok := content["application/json"].(map[string]any); ok {
+					o.body = f.resolve(c["schema"].(map[string]any))
+				}
+			}
+			ops = append(ops, o)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].path+ops[i].method < ops[j].path+ops[j].method })
+	return ops
+}
+
+// url fills the path parameters with the signed-in user's id.
+func (f *specFixture) url(path string, query url.Values) string {
+	path = strings.NewReplacer("{id}", f.me, "{path}", "x").Replace(path)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+func (f *specFixture) resolve(schema map[string]any) map[string]any {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		return f.resolve(f.doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any))
+	}
+	return schema
+}
+
+func (f *specFixture) do(method, target string, form url.Values, body any) *httptest.ResponseRecorder {
+	var req *http.Request
+	switch {
+	case body != nil:
+		b, _ := json.Marshal(body)
+		req = httptest.NewRequest(method, target, strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+	case form != nil:
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	default:
+		req = httptest.NewRequest(method, target, nil)
+	}
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// fieldErrors returns the field -> code pairs of a validation_failed answer.
+func fieldErrors(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	var e Handlers.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil || e.Code != Handlers.CodeValidation {
+		return nil
+	}
+	out := map[string]string{}
+	for _, fe := range e.Fields {
+		out[fe.Field] = fe.Code
+	}
+	return out
+}
+
+// The query and form parameters the document marks required are the ones the
+// handlers report missing, and the integer ones are parsed as integers.
+func TestOpenAPIParametersMatchHandlers(t *testing.T) {
+	f := newSpecFixture(t)
+	for _, o := range f.ops() {
+		if len(o.query) == 0 && o.form == nil {
+			continue
+		}
+		required := map[string]bool{}
+		integers := map[string]string{} // name -> "query" or "form"
+		for name, p := range o.query {
+			if p["required"] == true {
+				required[name] = true
+			}
+			if p["schema"].(map[string]any)["type"] == "integer" {
+				integers[name] = "query"
+			}
+		}
+		if o.form != nil {
+			for _, name := range o.form["required"].([]any) {
+				required[name.(string)] = true
+			}
+			for name, s := range o.form["properties"].(map[string]any) {
+				if s.(map[string]any)["type"] == "integer" {
+					integers[name] = "form"
+				}
+			}
+		}
+		var emptyForm url.Values
+		if o.form != nil {
+			emptyForm = url.Values{}
+		}
+
+		if len(required) > 0 {
+			rec := f.do(o.method, f.url(o.path, nil), emptyForm, nil)
+			got := fieldErrors(t, rec)
+			if got == nil {
+				t.Errorf("%s %s without parameters: %d %s", o.method, o.path, rec.Code, rec.Body)
+				continue
+			}
+			for name := range required {
+				if got[name] != "required" {
+					t.Errorf("%s %s: %s is required in the document but the handler says %q", o.method, o.path, name, got[name])
+				}
+			}
+			for name, code := range got {
+				if !required[name] {
+					t.Errorf("%s %s: the handler reports %s %s, the document has it optional", o.method, o.path, name, code)
+				}
+			}
+		}
+
+		for name, in := range integers {
+			query, form := url.Values{}, emptyForm
+			if in == "query" {
+				query.Set(name, "x")
+			} else {
+				form = url.Values{name: {"x"}}
+			}
+			rec := f.do(o.method, f.url(o.path, query), form, nil)
+			if got := fieldErrors(t, rec); got[name] != "invalid" {
+				t.Errorf("%s %s: %s=x gave %d %s, want it invalid", o.method, o.path, name, rec.Code, rec.Body)
+			}
+		}
+	}
+}
+
+// JSON bodies with every documented field are accepted by the decoder, which
+// refuses unknown fields.
+func TestOpenAPIBodiesMatchHandlers(t *testing.T) {
+	f := newSpecFixture(t)
+	for _, o := range f.ops() {
+		if o.body == nil {
+			continue
+		}
+		body := map[string]any{}
+		for name, s := range o.body["properties"].(map[string]any) {
+			body[name] = zeroFor(f.resolve(s.(map[string]any)))
+		}
+		rec := f.do(o.method, f.url(o.path, nil), nil, body)
+		var e Handlers.ErrorBody
+		_ = json.Unmarshal(rec.Body.Bytes(), &e)
+		if e.Code == Handlers.CodeBadJSON {
+			t.Errorf("%s %s rejects its documented body %v: %s", o.method, o.path, body, rec.Body)
+		}
+	}
+}
+
+func zeroFor(schema map[string]any) any {
+	switch schema["type"] {
+	case "string":
+		return ""
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	case "array":
+		return []any{}
+	}
+	return map[string]any{}
+}
+
+// What the read routes answer has the documented fields, no more and no fewer.
+func TestOpenAPIResponsesMatchHandlers(t *testing.T) {
+	f := newSpecFixture(t)
+	generic := map[string]bool{"/api/openapi.json": true, "/api/ws/schema.json": true}
+	for _, o := range f.ops() {
+		responses := o.op["responses"].(map[string]any)
+		for status, r := range responses {
+			content, _ := r.(map[string]any)["content"].(map[string]any)
+			c, ok := content["application/json"].(map[string]any)
+			if !ok || status == "default" || generic[o.path] {
+				continue
+			}
+			s := f.resolve(c["schema"].(map[string]any))
+			if s["type"] == "object" && s["properties"] == nil && s["additionalProperties"] == nil {
+				t.Errorf("%s %s: %s response is an undocumented object", o.method, o.path, status)
+			}
+		}
+
+		ok, isJSON := responses["200"].(map[string]any)
+		if o.method != http.MethodGet || !isJSON || generic[o.path] || strings.HasPrefix(o.path, "/healthz") {
+			continue
+		}
+		content, _ := ok["content"].(map[string]any)
+		c, isJSON := content["application/json"].(map[string]any)
+		if !isJSON {
+			continue
+		}
+		query := url.Values{}
+		for name, p := range o.query {
+			if p["required"] != true {
+				continue
+			}
+			v := f.samples.Get(name)
+			if v == "" {
+				t.Errorf("%s %s: no sample value for %s", o.method, o.path, name)
+			}
+			query.Set(name, v)
+		}
+		rec := f.do(o.method, f.url(o.path, query), nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s %s: %d %s", o.method, o.path, rec.Code, rec.Body)
+			continue
+		}
+		var got any
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("%s %s: %v", o.method, o.path, err)
+			continue
+		}
+		f.matchSchema(t, o.method+" "+o.path, got, c["schema"].(map[string]any))
+	}
+}
+
+// matchSchema reports where v doesn't have the shape schema describes.
+func (f *specFixture) matchSchema(t *testing.T, at string, v any, schema map[string]any) {
+	t.Helper()
+	schema = f.resolve(schema)
+	if alts, ok := schema["anyOf"].([]any); ok {
+		if v == nil {
+			return
+		}
+		schema = f.resolve(alts[0].(map[string]any))
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			t.Errorf("%s: got %T, want an object", at, v)
+			return
+		}
+		if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+			for k, val := range obj {
+				f.matchSchema(t, at+"."+k, val, extra)
+			}
+			return
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for k, val := range obj {
+			p, ok := props[k]
+			if !ok {
+				t.Errorf("%s: field %q is not documented", at, k)
+				continue
+			}
+			f.matchSchema(t, at+"."+k, val, p.(map[string]any))
+		}
+		required, _ := schema["required"].([]any)
+		for _, k := range required {
+			if _, ok := obj[k.(string)]; !ok {
+				t.Errorf("%s: required field %q is missing", at, k)
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			t.Errorf("%s: got %v, want an array", at, v)
+			return
+		}
+		for i, val := range arr {
+			f.matchSchema(t, at+"["+strconv.Itoa(i)+"]", val, schema["items"].(map[string]any))
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			t.Errorf("%s: got %v, want a string", at, v)
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			t.Errorf("%s: got %v, want a number", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%s: got %v, want a boolean", at, v)
+		}
+	}
+}
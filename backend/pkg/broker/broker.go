@@ -0,0 +1,54 @@
+// Package broker is the pub/sub backplane that lets several backend
+// instances share websocket delivery and presence.
+package broker
+
+import "sync"
+
+// Broker publishes opaque payloads on named topics. Every subscriber of a
+// topic, on every instance (including the publisher's), receives each
+// payload in publish order.
+type Broker interface {
+	Publish(topic string, payload []byte) error
+	// Subscribe registers fn for topic. fn runs on the broker's delivery
+	// goroutine and must not block.
+	Subscribe(topic string, fn func(payload []byte)) error
+	Close() error
+}
+
+// Memory is an in-process Broker. It is what a single instance uses and
+// behaves exactly like having no backplane at all.
+type Memory struct {
+	mu   sync.RWMutex
+	subs map[string][]func([]byte)
+}
+
+func NewMemory() *Memory {
+	return &Memory{subs: make(map[string][]func([]byte))}
+}
+
+func (m *Memory) Publish(topic string, payload []byte) error {
+	m.mu.RLock()
+	fns := m.subs[topic]
+	m.mu.RUnlock()
+	for _, fn := range fns {
+		fn(payload)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, fn func([]byte)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// copy on write so Publish can range over its snapshot without the lock
+	fns := make([]func([]byte), 0, len(m.subs[topic])+1)
+	fns = append(fns, m.subs[topic]...)
+	m.subs[topic] = append(fns, fn)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = make(map[string][]func([]byte))
+	return nil
+}
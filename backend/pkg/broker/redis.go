@@ -0,0 +1,196 @@
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+	redisMaxBackoff  = 5 * time.Second
+)
+
+// Redis is a Broker over Redis pub/sub (or anything speaking the same RESP
+// subset, such as the stand-in in this package). It keeps one connection for
+// PUBLISH and one for SUBSCRIBE and redials either when it breaks.
+type Redis struct {
+	addr string
+
+	pubMu sync.Mutex
+	pub   net.Conn
+	pubR  *bufio.Reader
+	pubW  *bufio.Writer
+
+	subMu   sync.Mutex
+	sub     net.Conn
+	subW    *bufio.Writer
+	handler map[string][]func([]byte)
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// DialRedis connects to addr and starts the subscription reader.
+func DialRedis(addr string) (*Redis, error) {
+	b := &Redis{
+		addr:    addr,
+		handler: make(map[string][]func([]byte)),
+		closed:  make(chan struct{}),
+	}
+	if err := b.dialPub(); err != nil {
+		return nil, err
+	}
+	if err := b.dialSub(); err != nil {
+		b.pub.Close()
+		return nil, err
+	}
+	go b.readLoop()
+	return b, nil
+}
+
+func (b *Redis) dialPub() error {
+	c, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+	if err != nil {
+		return fmt.Errorf("broker: dial %s: %w", b.addr, err)
+	}
+	b.pub, b.pubR, b.pubW = c, bufio.NewReader(c), bufio.NewWriter(c)
+	return nil
+}
+
+// dialSub (re)opens the subscriber connection and re-subscribes every topic.
+func (b *Redis) dialSub() error {
+	c, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+	if err != nil {
+		return fmt.Errorf("broker: dial %s: %w", b.addr, err)
+	}
+	w := bufio.NewWriter(c)
+
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+	for topic := range b.handler {
+		if err := writeCommand(w, []byte("SUBSCRIBE"), []byte(topic)); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	b.sub, b.subW = c, w
+	return nil
+}
+
+func (b *Redis) Publish(topic string, payload []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	err := b.publishLocked(topic, payload)
+	if err == nil {
+		return nil
+	}
+	var re respError
+	if errors.As(err, &re) {
+		return err
+	}
+	// connection trouble: redial once and retry
+	b.pub.Close()
+	if derr := b.dialPub(); derr != nil {
+		return derr
+	}
+	return b.publishLocked(topic, payload)
+}
+
+func (b *Redis) publishLocked(topic string, payload []byte) error {
+	_ = b.pub.SetDeadline(time.Now().Add(redisIOTimeout))
+	if err := writeCommand(b.pubW, []byte("PUBLISH"), []byte(topic), payload); err != nil {
+		return err
+	}
+	v, err := readValue(b.pubR)
+	if err != nil {
+		return err
+	}
+	if re, ok := v.(respError); ok {
+		return re
+	}
+	return nil
+}
+
+func (b *Redis) Subscribe(topic string, fn func([]byte)) error {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+
+	first := len(b.handler[topic]) == 0
+	b.handler[topic] = append(b.handler[topic], fn)
+	if !first {
+		return nil
+	}
+	return writeCommand(b.subW, []byte("SUBSCRIBE"), []byte(topic))
+}
+
+// readLoop delivers incoming messages and redials with backoff when the
+// subscriber connection drops. Messages published while disconnected are lost.
+func (b *Redis) readLoop() {
+	backoff := 100 * time.Millisecond
+	for {
+		b.subMu.Lock()
+		r := bufio.NewReader(b.sub)
+		b.subMu.Unlock()
+
+		for {
+			v, err := readValue(r)
+			if err != nil {
+				break
+			}
+			backoff = 100 * time.Millisecond
+			b.deliver(v)
+		}
+
+		for {
+			select {
+			case <-b.closed:
+				return
+			case <-time.After(backoff):
+			}
+			if err := b.dialSub(); err == nil {
+				break
+			}
+			backoff = min(backoff*2, redisMaxBackoff)
+		}
+	}
+}
+
+// deliver handles a ["message", topic, payload] push; subscribe confirmations are ignored.
+func (b *Redis) deliver(v any) {
+	arr, ok := v.([]any)
+	if !ok || len(arr) != 3 {
+		return
+	}
+	kind, _ := asString(arr[0])
+	topic, _ := asString(arr[1])
+	payload, _ := arr[2].([]byte)
+	if kind != "message" {
+		return
+	}
+
+	b.subMu.Lock()
+	fns := b.handler[topic]
+	b.subMu.Unlock()
+	for _, fn := range fns {
+		fn(payload)
+	}
+}
+
+func (b *Redis) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+		b.pubMu.Lock()
+		b.pub.Close()
+		b.pubMu.Unlock()
+		b.subMu.Lock()
+		b.sub.Close()
+		b.subMu.Unlock()
+	})
+	return nil
+}
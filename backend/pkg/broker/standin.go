@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StandIn is a tiny pub/sub server speaking the RESP subset Redis uses for
+// PING, PUBLISH, SUBSCRIBE and UNSUBSCRIBE. It exists so several backend
+// instances can be run against a shared backplane without installing Redis.
+type StandIn struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	subs  map[string]map[*standInConn]struct{}
+	conns map[*standInConn]struct{}
+}
+
+type standInConn struct {
+	c   net.Conn
+	wMu sync.Mutex
+	w   *bufio.Writer
+}
+
+func (c *standInConn) write(args ...[]byte) error {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	return writeCommand(c.w, args...)
+}
+
+func (c *standInConn) writeRaw(s string) error {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	c.w.WriteString(s)
+	return c.w.Flush()
+}
+
+// ListenStandIn starts serving on addr ("127.0.0.1:0" picks a free port).
+func ListenStandIn(addr string) (*StandIn, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StandIn{
+		ln:    ln,
+		subs:  make(map[string]map[*standInConn]struct{}),
+		conns: make(map[*standInConn]struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *StandIn) Addr() string { return s.ln.Addr().String() }
+
+// Close stops listening and drops every client, like a restarting Redis.
+func (s *StandIn) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.c.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (s *StandIn) accept() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		sc := &standInConn{c: c, w: bufio.NewWriter(c)}
+		s.mu.Lock()
+		s.conns[sc] = struct{}{}
+		s.mu.Unlock()
+		go s.serve(sc)
+	}
+}
+
+func (s *StandIn) serve(c *standInConn) {
+	defer func() {
+		s.mu.Lock()
+		for _, set := range s.subs {
+			delete(set, c)
+		}
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.c.Close()
+	}()
+
+	r := bufio.NewReader(c.c)
+	for {
+		v, err := readValue(r)
+		if err != nil {
+			return
+		}
+		arr, ok := v.([]any)
+		if !ok || len(arr) == 0 {
+			_ = c.writeRaw("-ERR expected command array\r\n")
+			continue
+		}
+		args := make([][]byte, len(arr))
+		for i, a := range arr {
+			str, _ := asString(a)
+			args[i] = []byte(str)
+		}
+
+		switch strings.ToUpper(string(args[0])) {
+		case "PING":
+			err = c.writeRaw("+PONG\r\n")
+		case "PUBLISH":
+			if len(args) != 3 {
+				err = c.writeRaw("-ERR wrong number of arguments for 'publish'\r\n")
+				break
+			}
+			n := s.publish(string(args[1]), args[2])
+			err = c.writeRaw(":" + strconv.Itoa(n) + "\r\n")
+		case "SUBSCRIBE", "UNSUBSCRIBE":
+			sub := strings.EqualFold(string(args[0]), "SUBSCRIBE")
+			for _, topic := range args[1:] {
+				s.mu.Lock()
+				if sub {
+					if s.subs[string(topic)] == nil {
+						s.subs[string(topic)] = make(map[*standInConn]struct{})
+					}
+					s.subs[string(topic)][c] = struct{}{}
+				} else {
+					delete(s.subs[string(topic)], c)
+				}
+				s.mu.Unlock()
+				if err = c.writeRaw(confirmation(strings.ToLower(string(args[0])), topic)); err != nil {
+					break
+				}
+			}
+		default:
+			err = c.writeRaw("-ERR unknown command\r\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// publish fans payload out to every subscriber and returns how many got it.
+func (s *StandIn) publish(topic string, payload []byte) int {
+	s.mu.Lock()
+	conns := make([]*standInConn, 0, len(s.subs[topic]))
+	for c := range s.subs[topic] {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	n := 0
+	for _, c := range conns {
+		if c.write([]byte("message"), []byte(topic), payload) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// confirmation encodes the [kind, topic, 1] reply to (UN)SUBSCRIBE.
+func confirmation(kind string, topic []byte) string {
+	return "*3\r\n$" + strconv.Itoa(len(kind)) + "\r\n" + kind + "\r\n$" + strconv.Itoa(len(topic)) + "\r\n" + string(topic) + "\r\n:1\r\n"
+}
@@ -0,0 +1,226 @@
+// Package config loads the server settings from environment variables and,
+// optionally, a JSON file. The file holds the same keys as the environment
+// ({"PORT": "9000", "SESSION_TTL": "2h"}); a variable that is set in the
+// environment wins over the file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/pkg/logging"
+	"backend/pkg/ratelimit"
+)
+
+type Config struct {
+	Port           string        // PORT
+	DBPath         string        // DB_PATH
+	MigrationsPath string        // MIGRATIONS_PATH, a directory
+	UploadsDir     string        // UPLOADS_DIR, served publicly under /uploads/
+	AttachmentsDir string        // ATTACHMENTS_DIR, chat files, never served directly
+	FrontendURL    string        // FRONTEND_URL, used for links in emails
+	AllowedOrigins []string      // ALLOWED_ORIGINS, comma separated; defaults to FRONTEND_URL's origin
+	SessionTTL     time.Duration // SESSION_TTL
+	SecureCookies  bool          // SECURE_COOKIES, set when served over HTTPS
+
+	// SHUTDOWN_TIMEOUT, how long a stopping server waits for requests and
+	// websockets to finish
+	ShutdownTimeout time.Duration
+
+	RedisAddr      string // REDIS_ADDR, empty for a single instance
+	RateLimits     string // RATE_LIMITS, overrides like "login=10/5m,dm=20/10s:40"
+	PasswordPolicy string // PASSWORD_POLICY, like "min=12,letter,digit,symbol"
+	MailDir        string // MAIL_DIR, write mail there instead of logging it
+
+	LogLevel  string // LOG_LEVEL: debug, info, warn or error
+	LogFormat string // LOG_FORMAT: text or json
+}
+
+// Defaults is the configuration used for local development.
+func Defaults() Config {
+	const frontend = "http://localhost:3000"
+	return Config{
+		Port:           "8080",
+		DBPath:         "./social_network.db",
+		MigrationsPath: "database/migrations/sqlite",
+		UploadsDir:     "uploads",
+		AttachmentsDir: "attachments",
+		FrontendURL:    frontend,
+		AllowedOrigins: []string{origin(frontend)},
+		SessionTTL:     time.Hour,
+
+		ShutdownTimeout: 10 * time.Second,
+
+		LogLevel:  "info",
+		LogFormat: "text",
+	}
+}
+
+// origin is the scheme://host part of an URL.
+func origin(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Load reads the file named by CONFIG_FILE, if any, then the environment,
+// and validates the result.
+func Load() (*Config, error) {
+	file := map[string]any{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		if err := json.Unmarshal(b, &file); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+	return load(func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := file[key]
+		if !ok || v == nil {
+			return "", false
+		}
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case []any:
+			parts := make([]string, len(v))
+			for i, p := range v {
+				parts[i] = fmt.Sprint(p)
+			}
+			return strings.Join(parts, ","), true
+		default:
+			return fmt.Sprint(v), true
+		}
+	})
+}
+
+func load(lookup func(string) (string, bool)) (*Config, error) {
+	c := Defaults()
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := lookup(key); ok {
+			*dst = strings.TrimSpace(v)
+		}
+	}
+	str("PORT", &c.Port)
+	str("DB_PATH", &c.DBPath)
+	str("MIGRATIONS_PATH", &c.MigrationsPath)
+	str("UPLOADS_DIR", &c.UploadsDir)
+	str("ATTACHMENTS_DIR", &c.AttachmentsDir)
+	str("FRONTEND_URL", &c.FrontendURL)
+	str("REDIS_ADDR", &c.RedisAddr)
+	str("RATE_LIMITS", &c.RateLimits)
+	str("PASSWORD_POLICY", &c.PasswordPolicy)
+	str("MAIL_DIR", &c.MailDir)
+	str("LOG_LEVEL", &c.LogLevel)
+	str("LOG_FORMAT", &c.LogFormat)
+	c.FrontendURL = strings.TrimRight(c.FrontendURL, "/")
+
+	dur := func(key string, dst *time.Duration) {
+		if v, ok := lookup(key); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			} else {
+				*dst = d
+			}
+		}
+	}
+	dur("SESSION_TTL", &c.SessionTTL)
+	dur("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	if v, ok := lookup("SECURE_COOKIES"); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("SECURE_COOKIES: %q is not a boolean", v))
+		}
+		c.SecureCookies = b
+	}
+	if v, ok := lookup("ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = nil
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+				c.AllowedOrigins = append(c.AllowedOrigins, o)
+			}
+		}
+	} else {
+		c.AllowedOrigins = []string{origin(c.FrontendURL)}
+	}
+
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return &c, nil
+}
+
+// Validate reports every setting that can't work, not just the first.
+func (c *Config) Validate() error {
+	var errs []error
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: %q is not a port number", c.Port))
+	}
+	for _, f := range []struct{ key, v string }{
+		{"DB_PATH", c.DBPath},
+		{"MIGRATIONS_PATH", c.MigrationsPath},
+		{"UPLOADS_DIR", c.UploadsDir},
+		{"ATTACHMENTS_DIR", c.AttachmentsDir},
+	} {
+		if f.v == "" {
+			errs = append(errs, fmt.Errorf("%s: must not be empty", f.key))
+		}
+	}
+	if c.UploadsDir != "" && c.UploadsDir == c.AttachmentsDir {
+		errs = append(errs, errors.New("ATTACHMENTS_DIR: must differ from UPLOADS_DIR, which is public"))
+	}
+	if u, err := url.Parse(c.FrontendURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("FRONTEND_URL: %q is not an http(s) URL", c.FrontendURL))
+	}
+	for _, o := range c.AllowedOrigins {
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			errs = append(errs, fmt.Errorf("ALLOWED_ORIGINS: %q is not an origin like https://app.example.com", o))
+		}
+	}
+	if _, err := ratelimit.ParseLimits(c.RateLimits); err != nil {
+		errs = append(errs, fmt.Errorf("RATE_LIMITS: %w", err))
+	}
+	if c.SessionTTL < time.Minute {
+		errs = append(errs, fmt.Errorf("SESSION_TTL: %s is shorter than a minute", c.SessionTTL))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT: %s must be positive", c.ShutdownTimeout))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: %q is neither text nor json", c.LogFormat))
+	}
+	return errors.Join(errs...)
+}
+
+// Logger builds the process logger from LOG_LEVEL and LOG_FORMAT.
+func (c *Config) Logger() *slog.Logger {
+	level, _ := logging.ParseLevel(c.LogLevel) // checked by Validate
+	return logging.New(os.Stderr, level, c.LogFormat)
+}
+
+// Addr is the listen address for the HTTP server.
+func (c *Config) Addr() string { return ":" + c.Port }
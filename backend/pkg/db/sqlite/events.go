@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"backend/pkg/store"
+)
+
+type events struct{ db *sql.DB }
+
+var _ store.Events = (*events)(nil)
+
+func (s *events) Create(ctx context.Context, e store.Event) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO events (group_id, title, description, datetime, created_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, e.GroupID, e.Title, e.Description, e.Datetime.UTC().Format("2006-01-02 15:04:05"), e.CreatedBy)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *events) ForGroup(ctx context.Context, groupID, viewerID string) ([]store.Event, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			e.id,
+			e.group_id,
+			e.title,
+			e.description,
+			e.datetime,
+			e.created_by,
+			COALESCE(u.first_name || ' ' || u.last_name, '') AS creator_name,
+			SUM(CASE WHEN er.response = 'I''ll be there' THEN 1 ELSE 0 END) AS going_count,
+			SUM(CASE WHEN er.response = 'Can''t make it' THEN 1 ELSE 0 END) AS not_going_count,
+			SUM(CASE WHEN er.response = 'Might be late' THEN 1 ELSE 0 END) AS might_be_late_count,
+			COALESCE((SELECT response FROM event_responsess WHERE event_id = e.id AND user_id = ?), '') AS user_response
+		FROM events e
+		LEFT JOIN users u ON e.created_by = u.id
+		LEFT JOIN event_responsess er ON e.id = er.event_id
+		WHERE e.group_id = ?
+		GROUP BY e.id
+		ORDER BY e.datetime ASC
+	`, viewerID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]store.Event, 0)
+	for rows.Next() {
+		var e store.Event
+		if err := rows.Scan(&e.ID, &e.GroupID, &e.Title, &e.Description, &e.Datetime, &e.CreatedBy, &e.CreatorName,
+			&e.GoingCount, &e.NotGoingCount, &e.MightBeLateCount, &e.UserResponse); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *events) Vote(ctx context.Context, eventID, userID, response string) (bool, error) {
+	var previous string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT response FROM event_responsess WHERE user_id = ? AND event_id = ?
+	`, userID, eventID).Scan(&previous)
+	switch {
+	case err == nil:
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE event_responsess SET response = ? WHERE user_id = ? AND event_id = ?
+		`, response, userID, eventID)
+		return true, err
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO event_responsess (event_id, user_id, response) VALUES (?, ?, ?)
+		`, eventID, userID, response)
+		return false, err
+	default:
+		return false, err
+	}
+}
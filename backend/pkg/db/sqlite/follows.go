@@ -0,0 +1,156 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"backend/pkg/store"
+)
+
+type follows struct{ db *sql.DB }
+
+var _ store.Follows = (*follows)(nil)
+
+func (s *follows) Status(ctx context.Context, followerID, followingID string) (string, error) {
+	var status string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT status FROM followers
+		WHERE follower_id = ? AND following_id = ?
+	`, followerID, followingID).Scan(&status)
+	return status, notFound(err)
+}
+
+func (s *follows) IsFollowing(ctx context.Context, followerID, followingID string) (bool, error) {
+	var ok bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM followers
+			WHERE follower_id = ? AND following_id = ? AND status = 'accepted'
+		)
+	`, followerID, followingID).Scan(&ok)
+	return ok, err
+}
+
+// Follow updates the existing row rather than adding one, so following again
+// after a decline doesn't leave two records.
+func (s *follows) Follow(ctx context.Context, followerID, followingID, status string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE followers
+		SET status = ?, created_at = CURRENT_TIMESTAMP
+		WHERE follower_id = ? AND following_id = ?
+	`, status, followerID, followingID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO followers (follower_id, following_id, status)
+		VALUES (?, ?, ?)
+	`, followerID, followingID, status)
+	return err
+}
+
+func (s *follows) Answer(ctx context.Context, followerID, followingID, status string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE followers SET status = ?
+		WHERE follower_id = ? AND following_id = ?
+	`, status, followerID, followingID)
+	return err
+}
+
+func (s *follows) Unfollow(ctx context.Context, followerID, followingID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM followers
+		WHERE follower_id = ? AND following_id = ?
+	`, followerID, followingID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *follows) AcceptPending(ctx context.Context, followingID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE followers
+		SET status = 'accepted', created_at = CURRENT_TIMESTAMP
+		WHERE following_id = ? AND status = 'pending'
+		RETURNING follower_id
+	`, followingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *follows) Followers(ctx context.Context, userID string) ([]store.UserSummary, error) {
+	return queryUsers(ctx, s.db, `
+		SELECT `+userColumns+`
+		FROM followers f
+		JOIN users u ON f.follower_id = u.id
+		WHERE f.following_id = ? AND f.status = 'accepted'
+	`, userID)
+}
+
+func (s *follows) Following(ctx context.Context, userID string) ([]store.UserSummary, error) {
+	return queryUsers(ctx, s.db, `
+		SELECT `+userColumns+`
+		FROM followers f
+		JOIN users u ON u.id = f.following_id
+		WHERE f.follower_id = ? AND f.status = 'accepted'
+	`, userID)
+}
+
+func (s *follows) Counts(ctx context.Context, userID string) (followers, following int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM followers WHERE following_id = ? AND status = 'accepted'),
+			(SELECT COUNT(*) FROM followers WHERE follower_id = ? AND status = 'accepted')
+	`, userID, userID).Scan(&followers, &following)
+	return followers, following, err
+}
+
+func (s *follows) Pending(ctx context.Context, userID string) ([]store.PendingFollow, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+userColumns+`, f.created_at
+		FROM followers f
+		JOIN users u ON f.follower_id = u.id
+		WHERE f.following_id = ? AND f.status = 'pending'
+		ORDER BY f.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]store.PendingFollow, 0)
+	for rows.Next() {
+		var p store.PendingFollow
+		if p.UserSummary, err = scanUser(rows, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *follows) PendingCount(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM followers
+		WHERE following_id = ? AND status = 'pending'
+	`, userID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,265 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"backend/pkg/store"
+)
+
+type groups struct{ db *sql.DB }
+
+var _ store.Groups = (*groups)(nil)
+
+func (s *groups) List(ctx context.Context, viewerID string) ([]store.Group, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			g.id,
+			g.title,
+			g.description,
+			g.creator_id,
+			g.created_at,
+			COUNT(gm.user_id) AS member_count,
+			EXISTS(
+				SELECT 1 FROM group_members
+				WHERE group_id = g.id AND user_id = ? AND status = 'accepted'
+			) AS is_member
+		FROM groups g
+		LEFT JOIN group_members gm ON g.id = gm.group_id AND gm.status = 'accepted'
+		GROUP BY g.id
+		ORDER BY g.created_at DESC
+	`, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]store.Group, 0, 32)
+	for rows.Next() {
+		var g store.Group
+		if err := rows.Scan(&g.ID, &g.Title, &g.Description, &g.CreatorID, &g.CreatedAt, &g.MemberCount, &g.IsMember); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func (s *groups) Get(ctx context.Context, groupID string) (store.Group, error) {
+	var g store.Group
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, title, description, creator_id, created_at FROM groups WHERE id = ?
+	`, groupID).Scan(&g.ID, &g.Title, &g.Description, &g.CreatorID, &g.CreatedAt)
+	return g, notFound(err)
+}
+
+func (s *groups) Create(ctx context.Context, g store.Group, invitees []string) (int64, []string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO groups (title, description, creator_id) VALUES (?, ?, ?)
+	`, g.Title, g.Description, g.CreatorID)
+	if err != nil {
+		return 0, nil, err
+	}
+	groupID, err := res.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO group_members (group_id, user_id, status) VALUES (?, ?, 'accepted')
+	`, groupID, g.CreatorID); err != nil {
+		return 0, nil, err
+	}
+	invited, err := invite(ctx, tx, groupID, invitees)
+	if err != nil {
+		return 0, nil, err
+	}
+	return groupID, invited, tx.Commit()
+}
+
+func (s *groups) Invite(ctx context.Context, groupID string, userIDs []string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invited, err := invite(ctx, tx, groupID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	return invited, tx.Commit()
+}
+
+// invite adds the users that have no link to the group yet as invited.
+func invite(ctx context.Context, tx *sql.Tx, groupID any, userIDs []string) ([]string, error) {
+	var invited []string
+	for _, userID := range userIDs {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO group_members (group_id, user_id, status)
+			SELECT ?1, ?2, 'invited'
+			WHERE EXISTS (SELECT 1 FROM users WHERE id = ?2)
+			  AND NOT EXISTS (SELECT 1 FROM group_members WHERE group_id = ?1 AND user_id = ?2)
+		`, groupID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			invited = append(invited, userID)
+		}
+	}
+	return invited, nil
+}
+
+func (s *groups) Delete(ctx context.Context, groupID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{
+		`DELETE FROM group_chat_reads WHERE group_id = ?`,
+		`DELETE FROM chat_reactions
+		 WHERE message_kind = 'group' AND message_id IN (SELECT id FROM group_chat WHERE group_id = ?)`,
+		`DELETE FROM group_chat WHERE group_id = ?`,
+		`DELETE FROM group_members WHERE group_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, groupID); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM groups WHERE id = ?`, groupID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return store.ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *groups) MemberStatus(ctx context.Context, groupID, userID string) (string, error) {
+	var status string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT status FROM group_members WHERE group_id = ? AND user_id = ?
+	`, groupID, userID).Scan(&status)
+	return status, notFound(err)
+}
+
+func (s *groups) AddMember(ctx context.Context, groupID, userID, status string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO group_members (group_id, user_id, status) VALUES (?, ?, ?)
+	`, groupID, userID, status)
+	return err
+}
+
+func (s *groups) SetMemberStatus(ctx context.Context, groupID, userID, status string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE group_members SET status = ? WHERE group_id = ? AND user_id = ?
+	`, status, groupID, userID)
+	return err
+}
+
+func (s *groups) RemoveMember(ctx context.Context, groupID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM group_members WHERE group_id = ? AND user_id = ?
+	`, groupID, userID)
+	return err
+}
+
+func (s *groups) MemberIDs(ctx context.Context, groupID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id FROM group_members
+		WHERE group_id = ? AND status = 'accepted'
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *groups) Members(ctx context.Context, groupID string) ([]store.UserSummary, error) {
+	return queryUsers(ctx, s.db, `
+		SELECT `+userColumns+`
+		FROM group_members gm
+		JOIN users u ON gm.user_id = u.id
+		WHERE gm.group_id = ? AND gm.status = 'accepted'
+	`, groupID)
+}
+
+func (s *groups) InviteCandidates(ctx context.Context, groupID, userID string) ([]store.UserSummary, error) {
+	return queryUsers(ctx, s.db, `
+		SELECT `+userColumns+`
+		FROM users u
+		WHERE u.id != ?
+		AND u.id NOT IN (SELECT user_id FROM group_members WHERE group_id = ?)
+		ORDER BY u.nickname
+	`, userID, groupID)
+}
+
+func (s *groups) Invites(ctx context.Context, userID string) ([]store.GroupInvite, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT g.id, g.title, g.description, g.creator_id, g.created_at,
+		       COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')
+		FROM group_members gm
+		JOIN groups g ON gm.group_id = g.id
+		JOIN users u ON g.creator_id = u.id
+		WHERE gm.user_id = ? AND gm.status = 'invited'
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []store.GroupInvite
+	for rows.Next() {
+		var inv store.GroupInvite
+		g := &inv.Group
+		if err := rows.Scan(&g.ID, &g.Title, &g.Description, &g.CreatorID, &g.CreatedAt, &inv.CreatorName); err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+func (s *groups) JoinRequests(ctx context.Context, creatorID string) ([]store.JoinRequest, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT g.id, g.title, `+userColumns+`
+		FROM groups g
+		JOIN group_members gm ON g.id = gm.group_id
+		JOIN users u ON gm.user_id = u.id
+		WHERE g.creator_id = ? AND gm.status = 'requested'
+	`, creatorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []store.JoinRequest
+	for rows.Next() {
+		var req store.JoinRequest
+		if err := rows.Scan(&req.GroupID, &req.GroupTitle,
+			&req.User.ID, &req.User.Nickname, &req.User.FirstName, &req.User.LastName, &req.User.Avatar); err != nil {
+			return nil, err
+		}
+		out = append(out, req)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,167 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"backend/pkg/store"
+)
+
+// keep each IN (...) list well under SQLite's variable limit
+const inChunkSize = 500
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+// forEachChunk calls fn with consecutive slices of ids, no longer than
+// inChunkSize, and the placeholders and arguments for an IN list of them.
+func forEachChunk(ids []string, fn func(in string, args []any) error) error {
+	for start := 0; start < len(ids); start += inChunkSize {
+		chunk := ids[start:min(start+inChunkSize, len(ids))]
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		if err := fn(placeholders(len(chunk)), args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkKind(kind string) error {
+	if kind != "dm" && kind != "group" {
+		return fmt.Errorf("unknown message kind %q", kind)
+	}
+	return nil
+}
+
+func (s *messages) Parents(ctx context.Context, kind string, ids []string) (map[string]store.Message, error) {
+	if err := checkKind(kind); err != nil {
+		return nil, err
+	}
+	out := make(map[string]store.Message)
+	err := forEachChunk(ids, func(in string, args []any) error {
+		var (
+			msgs []store.Message
+			err  error
+		)
+		if kind == "dm" {
+			msgs, err = s.queryDMs(ctx, `id IN (`+in+`)`, args...)
+		} else {
+			msgs, err = s.queryGroupMessages(ctx, `gc.id IN (`+in+`)`, args...)
+		}
+		for _, m := range msgs {
+			out[m.ID] = m
+		}
+		return err
+	})
+	return out, err
+}
+
+func (s *messages) Attachments(ctx context.Context, kind string, messageIDs []string) (map[string][]store.Attachment, error) {
+	if err := checkKind(kind); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]store.Attachment)
+	err := forEachChunk(messageIDs, func(in string, args []any) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id, message_id, COALESCE(original_name, ''), mime_type, size
+			FROM chat_attachments
+			WHERE message_kind = ? AND message_id IN (`+in+`)
+			ORDER BY created_at ASC
+		`, append([]any{kind}, args...)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				a     store.Attachment
+				msgID int64
+			)
+			if err := rows.Scan(&a.ID, &msgID, &a.Name, &a.MimeType, &a.Size); err != nil {
+				return err
+			}
+			key := fmt.Sprint(msgID)
+			out[key] = append(out[key], a)
+		}
+		return rows.Err()
+	})
+	return out, err
+}
+
+func (s *messages) Reactions(ctx context.Context, kind string, messageIDs []string) (map[string][]store.ReactionSummary, error) {
+	if err := checkKind(kind); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]store.ReactionSummary)
+	err := forEachChunk(messageIDs, func(in string, args []any) error {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT message_id, emoji, user_id FROM chat_reactions
+			WHERE message_kind = ? AND message_id IN (`+in+`)
+			ORDER BY id ASC
+		`, append([]any{kind}, args...)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				msgID         int64
+				emoji, userID string
+			)
+			if err := rows.Scan(&msgID, &emoji, &userID); err != nil {
+				return err
+			}
+			key := fmt.Sprint(msgID)
+			out[key] = addReaction(out[key], emoji, userID)
+		}
+		return rows.Err()
+	})
+	return out, err
+}
+
+// addReaction counts userID under emoji, appending the emoji the first time it is seen.
+func addReaction(list []store.ReactionSummary, emoji, userID string) []store.ReactionSummary {
+	i := slices.IndexFunc(list, func(r store.ReactionSummary) bool { return r.Emoji == emoji })
+	if i < 0 {
+		list = append(list, store.ReactionSummary{Emoji: emoji})
+		i = len(list) - 1
+	}
+	list[i].Count++
+	list[i].Users = append(list[i].Users, userID)
+	return list
+}
+
+func (s *messages) ToggleReaction(ctx context.Context, kind, messageID, userID, emoji string) (bool, error) {
+	if err := checkKind(kind); err != nil {
+		return false, err
+	}
+	// insert first and let the unique index decide, so two identical
+	// toggles racing each other add and then remove instead of failing
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO chat_reactions (message_kind, message_id, user_id, emoji) VALUES (?, ?, ?, ?)
+		ON CONFLICT(message_kind, message_id, user_id, emoji) DO NOTHING
+	`, kind, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err == nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM chat_reactions
+		WHERE message_kind = ? AND message_id = ? AND user_id = ? AND emoji = ?
+	`, kind, messageID, userID, emoji)
+	return false, err
+}
@@ -0,0 +1,266 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"backend/pkg/store"
+)
+
+type messages struct{ db *sql.DB }
+
+var _ store.Messages = (*messages)(nil)
+
+// sqliteTime is how CURRENT_TIMESTAMP stores sent_at, so it compares as text.
+const sqliteTime = "2006-01-02 15:04:05"
+
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (s *messages) CreateDM(ctx context.Context, m store.Message) (string, time.Time, error) {
+	return s.insert(ctx, "dm", `messages`, `
+		INSERT INTO messages (sender_id, receiver_id, content, reply_to, client_msg_id) VALUES (?, ?, ?, ?, ?)
+	`, m, m.SenderID, m.ReceiverID, m.Content, nullable(m.ReplyTo), nullable(m.ClientMsgID))
+}
+
+func (s *messages) CreateGroupMessage(ctx context.Context, m store.Message) (string, time.Time, error) {
+	return s.insert(ctx, "group", `group_chat`, `
+		INSERT INTO group_chat (group_id, sender_id, content, reply_to, client_msg_id) VALUES (?, ?, ?, ?, ?)
+	`, m, m.GroupID, m.SenderID, m.Content, nullable(m.ReplyTo), nullable(m.ClientMsgID))
+}
+
+// insert runs the INSERT, binds m's attachments to the new row and reads back
+// the sent_at SQLite gave it, all in one transaction.
+func (s *messages) insert(ctx context.Context, kind, table, query string, m store.Message, args ...any) (string, time.Time, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	for _, attachmentID := range m.Attachments {
+		// a racing send that bound the upload first leaves nothing to update
+		res, err := tx.ExecContext(ctx, `
+			UPDATE chat_attachments SET message_kind = ?, message_id = ?
+			WHERE id = ? AND uploader_id = ? AND message_id IS NULL
+		`, kind, id, attachmentID, m.SenderID)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return "", time.Time{}, err
+		} else if n != 1 {
+			return "", time.Time{}, store.ErrAttachmentUnavailable
+		}
+	}
+	var sentAt time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT sent_at FROM `+table+` WHERE id = ?`, id).Scan(&sentAt); err != nil {
+		sentAt = time.Now()
+	}
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, err
+	}
+	return fmt.Sprint(id), sentAt, nil
+}
+
+// queryDMs loads messages matching cond, a WHERE fragment that may carry its
+// own ORDER BY and LIMIT.
+func (s *messages) queryDMs(ctx context.Context, cond string, args ...any) ([]store.Message, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, sender_id, receiver_id, COALESCE(content, ''), sent_at,
+		       COALESCE(reply_to, ''), COALESCE(client_msg_id, '')
+		FROM messages
+		WHERE `+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]store.Message, 0)
+	for rows.Next() {
+		var m store.Message
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.SentAt, &m.ReplyTo, &m.ClientMsgID); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// queryGroupMessages is queryDMs for group_chat; cond may refer to the table as gc.
+func (s *messages) queryGroupMessages(ctx context.Context, cond string, args ...any) ([]store.Message, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT gc.id, gc.sender_id, gc.group_id, COALESCE(gc.content, ''), gc.sent_at,
+		       COALESCE(gc.reply_to, ''), COALESCE(gc.client_msg_id, ''), `+userColumns+`
+		FROM group_chat gc
+		JOIN users u ON u.id = gc.sender_id
+		WHERE `+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]store.Message, 0)
+	for rows.Next() {
+		var m store.Message
+		u := &m.Sender
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.GroupID, &m.Content, &m.SentAt, &m.ReplyTo, &m.ClientMsgID,
+			&u.ID, &u.Nickname, &u.FirstName, &u.LastName, &u.Avatar); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func first(msgs []store.Message, err error) (store.Message, error) {
+	if err != nil {
+		return store.Message{}, err
+	}
+	if len(msgs) == 0 {
+		return store.Message{}, store.ErrNotFound
+	}
+	return msgs[0], nil
+}
+
+func (s *messages) DMByClientID(ctx context.Context, senderID, clientMsgID string) (store.Message, error) {
+	if clientMsgID == "" {
+		return store.Message{}, store.ErrNotFound
+	}
+	return first(s.queryDMs(ctx, `sender_id = ? AND client_msg_id = ?`, senderID, clientMsgID))
+}
+
+func (s *messages) GroupMessageByClientID(ctx context.Context, senderID, clientMsgID string) (store.Message, error) {
+	if clientMsgID == "" {
+		return store.Message{}, store.ErrNotFound
+	}
+	return first(s.queryGroupMessages(ctx, `gc.sender_id = ? AND gc.client_msg_id = ?`, senderID, clientMsgID))
+}
+
+func (s *messages) DMHistory(ctx context.Context, userID, peerID string, limit int) ([]store.Message, error) {
+	return s.queryDMs(ctx, `
+		(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)
+		ORDER BY sent_at ASC
+		LIMIT ?
+	`, userID, peerID, peerID, userID, limit)
+}
+
+func (s *messages) GroupHistory(ctx context.Context, groupID string) ([]store.Message, error) {
+	return s.queryGroupMessages(ctx, `gc.group_id = ? ORDER BY gc.sent_at ASC`, groupID)
+}
+
+func (s *messages) MissedDMs(ctx context.Context, userID string, afterID int64, since time.Time, limit int) ([]store.Message, error) {
+	cond := `(sender_id = ? OR receiver_id = ?) AND id > ?`
+	args := []any{userID, userID, afterID}
+	if !since.IsZero() {
+		cond += ` AND sent_at > ?`
+		args = append(args, since.UTC().Format(sqliteTime))
+	}
+	return s.queryDMs(ctx, cond+` ORDER BY id ASC LIMIT ?`, append(args, limit)...)
+}
+
+// MissedGroupMessages only covers groups the user is an accepted member of now.
+func (s *messages) MissedGroupMessages(ctx context.Context, userID string, afterID int64, since time.Time, limit int) ([]store.Message, error) {
+	cond := `gc.id > ? AND gc.group_id IN (
+			SELECT group_id FROM group_members WHERE user_id = ? AND status = 'accepted'
+		)`
+	args := []any{afterID, userID}
+	if !since.IsZero() {
+		cond += ` AND gc.sent_at > ?`
+		args = append(args, since.UTC().Format(sqliteTime))
+	}
+	return s.queryGroupMessages(ctx, cond+` ORDER BY gc.id ASC LIMIT ?`, append(args, limit)...)
+}
+
+func (s *messages) MarkGroupRead(ctx context.Context, groupID, userID, messageID string) error {
+	var id int64
+	if messageID == "" {
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT COALESCE(MAX(id), 0) FROM group_chat WHERE group_id = ?
+		`, groupID).Scan(&id); err != nil {
+			return err
+		}
+	} else {
+		// bound as an integer: text would sort above every id in MAX()
+		var err error
+		if id, err = strconv.ParseInt(messageID, 10, 64); err != nil || id <= 0 {
+			return store.ErrNotFound
+		}
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM group_chat WHERE id = ? AND group_id = ?)
+		`, id, groupID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return store.ErrNotFound
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO group_chat_reads (group_id, user_id, last_read_message_id, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(group_id, user_id) DO UPDATE SET
+			last_read_message_id = MAX(last_read_message_id, excluded.last_read_message_id),
+			updated_at = CURRENT_TIMESTAMP
+	`, groupID, userID, id)
+	return err
+}
+
+// GroupUnread counts messages from other members after the user's read marker.
+func (s *messages) GroupUnread(ctx context.Context, groupID, userID string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM group_chat gc
+		WHERE gc.group_id = ? AND gc.sender_id != ?
+		  AND gc.id > COALESCE((
+			SELECT last_read_message_id FROM group_chat_reads
+			WHERE group_id = gc.group_id AND user_id = ?
+		  ), 0)
+	`, groupID, userID, userID).Scan(&n)
+	return n, err
+}
+
+func (s *messages) GroupUnreadCounts(ctx context.Context, userID string) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT gm.group_id, (
+			SELECT COUNT(*) FROM group_chat gc
+			WHERE gc.group_id = gm.group_id AND gc.sender_id != gm.user_id
+			  AND gc.id > COALESCE(r.last_read_message_id, 0)
+		)
+		FROM group_members gm
+		LEFT JOIN group_chat_reads r ON r.group_id = gm.group_id AND r.user_id = gm.user_id
+		WHERE gm.user_id = ? AND gm.status = 'accepted'
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]int)
+	for rows.Next() {
+		var (
+			groupID string
+			n       int
+		)
+		if err := rows.Scan(&groupID, &n); err != nil {
+			return nil, err
+		}
+		out[groupID] = n
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"backend/pkg/store"
+)
+
+type notifications struct{ db *sql.DB }
+
+var _ store.Notifications = (*notifications)(nil)
+
+func (s *notifications) Create(ctx context.Context, recipientID, typ string, content json.RawMessage) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO notifications (recipient_id, type, content) VALUES (?, ?, ?)
+	`, recipientID, typ, string(content))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *notifications) UnreadCount(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM notifications WHERE recipient_id = ? AND is_read = 0
+	`, userID).Scan(&n)
+	return n, err
+}
+
+func (s *notifications) MarkRead(ctx context.Context, userID, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notifications SET is_read = 1 WHERE id = ? AND recipient_id = ?
+	`, id, userID)
+	return err
+}
+
+func (s *notifications) MarkDMRead(ctx context.Context, userID, messageID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notifications
+		SET is_read = 1
+		WHERE recipient_id = ? AND type = 'dm' AND json_extract(content, '$.messageId') = ?
+	`, userID, messageID)
+	return err
+}
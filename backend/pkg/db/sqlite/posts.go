@@ -1,40 +1,58 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"strings"
-	"time"
+
+	"backend/pkg/store"
 )
 
-type Post struct {
-	UserID         string    `json:"user_id"`
-	PostID         int64     `json:"post_id,omitempty"`
-	Nickname       string    `json:"nickname"`
-	FirstName      string    `json:"firstName,omitempty"`
-	LastName       string    `json:"lastName,omitempty"`
-	Avatar         string    `json:"avatar,omitempty"`
-	Content        string    `json:"content"`
-	Image          string    `json:"image"`
-	Privacy        string    `json:"privacy"`
-	CreatedAt      time.Time `json:"created_at,omitempty"`
-	CommentCount   int       `json:"comment_count,omitempty"`
-	LikeCount      int       `json:"like_count,omitempty"`
-	IsLiked        bool      `json:"is_liked,omitempty"`        // Indicates if the current user liked this post
-	FollowingLikes []string  `json:"following_likes,omitempty"` // Indicates if the current user follows likes on this post
-}
+type posts struct{ db *sql.DB }
+
+var _ store.Posts = (*posts)(nil)
 
-func GetPostsByUser(userID string) ([]Post, error) {
+func (s *posts) Create(ctx context.Context, p store.NewPost) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	rows, err := DB.Query(`
-SELECT 
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO posts (user_id, content, image, privacy) VALUES (?, ?, ?, ?)
+	`, p.UserID, p.Content, p.Image, p.Privacy)
+	if err != nil {
+		return 0, err
+	}
+	postID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if p.Privacy == "custom" {
+		for _, viewerID := range p.Viewers {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO post_visibility (post_id, user_id) VALUES (?, ?)
+			`, postID, viewerID); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return postID, tx.Commit()
+}
+
+// feedQuery is the one query behind the feed and profile pages. Its
+// parameters are the viewer five times, then the author twice ("" for all).
+const feedQuery = `
+SELECT
     p.user_id,
     p.id,
-    u.nickname,
-    u.first_name,
-    u.last_name,
-    u.avatar,
-    p.content,
-    p.image,
+    COALESCE(u.nickname, ''),
+    COALESCE(u.first_name, ''),
+    COALESCE(u.last_name, ''),
+    COALESCE(u.avatar, ''),
+    COALESCE(p.content, ''),
+    COALESCE(p.image, ''),
     p.privacy,
     p.created_at,
     (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comment_count,
@@ -42,9 +60,9 @@ SELECT
     (SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id AND l.user_id = ?) AS is_liked,
     (
       SELECT GROUP_CONCAT(
-               CASE 
-                   WHEN u2.nickname != '' THEN u2.nickname 
-                   ELSE u2.first_name || ' ' || u2.last_name 
+               CASE
+                   WHEN u2.nickname != '' THEN u2.nickname
+                   ELSE u2.first_name || ' ' || u2.last_name
                END, ', '
              )
       FROM likes l2
@@ -56,59 +74,66 @@ SELECT
     ) AS followed_likers
 FROM posts p
 JOIN users u ON u.id = p.user_id
-WHERE p.user_id = ?
-AND (
-      p.privacy != 'custom'                  -- show everything except custom, no extra checks
-   OR  p.user_id = ?                         -- owner always sees their own post
-   OR (
-        p.privacy = 'custom'                 -- for custom posts, enforce post_visibility
+WHERE (
+    -- Public posts: show to everyone
+    p.privacy = 'public'
+
+    -- Follower-only posts: show if the viewer follows the author
+    OR (
+        p.privacy = 'followers'
+        AND EXISTS (
+            SELECT 1 FROM followers f
+            WHERE f.following_id = p.user_id
+            AND f.follower_id = ?
+            AND f.status = 'accepted'
+        )
+    )
+
+    -- Custom posts: show if the viewer is in the visibility list
+    OR (
+        p.privacy = 'custom'
         AND EXISTS (
             SELECT 1 FROM post_visibility pv
-            WHERE pv.post_id = p.id AND pv.user_id = ?
+            WHERE pv.post_id = p.id
+            AND pv.user_id = ?
         )
-      )
+    )
+
+    -- Always show the viewer's own posts
+    OR p.user_id = ?
 )
-ORDER BY p.created_at DESC;
-`, userID, // is_liked
-			userID, // followed_likers (unchanged)
-			userID, // owner sees own posts
-			userID,
-			userID) // custom visibility check (pv.user_id = me)
-		if err != nil {
-			
-			return []Post{},err
-		}
-		defer rows.Close()
+AND (? = '' OR p.user_id = ?)
+ORDER BY p.created_at DESC
+`
 
-		var posts []Post
-		for rows.Next() {
-			var post Post
-			var followedLikers sql.NullString
-			if err := rows.Scan(
-				&post.UserID,
-				&post.PostID,
-				&post.Nickname,
-				&post.FirstName,
-				&post.LastName,
-				&post.Avatar,
-				&post.Content,
-				&post.Image,
-				&post.Privacy,
-				&post.CreatedAt,
-				&post.CommentCount,
-				&post.LikeCount,
-				&post.IsLiked,
-				&followedLikers,
-			); err != nil {
-				return []Post{},err
-			}
-			if followedLikers.Valid && followedLikers.String != "" {
-				post.FollowingLikes = strings.Split(followedLikers.String, ", ")
-			} else {
-				post.FollowingLikes = []string{}
-			}
-			posts = append(posts, post)
-		}
+func (s *posts) Feed(ctx context.Context, q store.FeedQuery) ([]store.Post, error) {
+	v := q.ViewerID
+	rows, err := s.db.QueryContext(ctx, feedQuery, v, v, v, v, v, q.AuthorID, q.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return posts, nil
+	out := make([]store.Post, 0)
+	for rows.Next() {
+		var (
+			p              store.Post
+			followedLikers sql.NullString
+		)
+		if err := rows.Scan(
+			&p.UserID, &p.PostID,
+			&p.Nickname, &p.FirstName, &p.LastName, &p.Avatar,
+			&p.Content, &p.Image, &p.Privacy, &p.CreatedAt,
+			&p.CommentCount, &p.LikeCount, &p.IsLiked,
+			&followedLikers,
+		); err != nil {
+			return nil, err
+		}
+		p.FollowingLikes = []string{}
+		if followedLikers.String != "" {
+			p.FollowingLikes = strings.Split(followedLikers.String, ", ")
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
 }
@@ -37,3 +37,9 @@ func DeleteSessionByToken(token string) error {
 	_, err := DB.Exec(`DELETE FROM sessions WHERE session_token = ?`, token)
 	return err
 }
+
+// DeleteUserSessions signs the user out everywhere.
+func DeleteUserSessions(userID string) error {
+	_, err := DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
@@ -2,7 +2,9 @@ package sqlite
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -16,12 +18,14 @@ import (
 
 var DB *sql.DB
 
-func InitDB() {
+// InitDB opens the database at path and applies the migrations found in the
+// migrations directory.
+func InitDB(path, migrations string) {
     var err error
 
-    DB, err = sql.Open("sqlite3", "./social_network.db?_journal_mode=WAL&_synchronous=NORMAL")
+    DB, err = sql.Open(timedDriverName, path+"?_journal_mode=WAL&_synchronous=NORMAL")
     if err != nil {
-        log.Fatal(" Failed to connect to DB:", err)
+        fatal("sqlite: open", err)
     }
 
     applyPragmaSettings(DB)
@@ -29,25 +33,30 @@ func InitDB() {
     // Prepare migration driver
     driver, err := sqlite3.WithInstance(DB, &sqlite3.Config{})
     if err != nil {
-        log.Fatal(" Failed to initialize SQLite migration driver:", err)
+        fatal("sqlite: migration driver", err)
     }
 
-    migrationsPath :="file://database/migrations/sqlite"
+    migrationsPath := "file://" + filepath.ToSlash(migrations)
 
     m, err := migrate.NewWithDatabaseInstance(
         migrationsPath,
         "sqlite3", driver)
 
     if err != nil {
-        log.Fatal(" Failed to load migrations:", err)
+        fatal("sqlite: load migrations", err)
     }
 
     // Apply migrations
     if err := m.Up(); err != nil && err.Error() != "no change" {
-        log.Fatal("Migration failed:", err)
+        fatal("sqlite: migrate", err)
     }
 
-    log.Println("  SQLite connected and migrations applied")
+    slog.Info("sqlite: connected and migrations applied", "path", path)
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
 func CheckEmailExists(email string) (bool, error) {
@@ -89,6 +98,17 @@ func GetHashedPassword(email string) (string, error) {
     return hashedPassword, nil
 }
 
+func GetHashedPasswordByID(userID string) (string, error) {
+	var hashedPassword string
+	err := DB.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&hashedPassword)
+	return hashedPassword, err
+}
+
+func UpdatePasswordHash(userID, hashedPassword string) error {
+	_, err := DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashedPassword, userID)
+	return err
+}
+
 func CheckNicknameExists(nickname string) (bool, error) {
 	var exists bool
 	err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE nickname = ?)", nickname).Scan(&exists)
@@ -109,7 +129,18 @@ func applyPragmaSettings(db *sql.DB) {
     for _, pragma := range pragmas {
         _, err := db.Exec(pragma)
         if err != nil {
-            log.Printf("Warning: Failed to set %s: %v", pragma, err)
+            slog.Warn("sqlite: pragma", "pragma", pragma, "err", err)
         }
     }
-}
\ No newline at end of file
+}
+// Close folds the WAL back into the database file and closes DB, so a
+// stopped server leaves a single self-contained file behind.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	if _, err := DB.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		slog.Warn("sqlite: wal checkpoint", "err", err)
+	}
+	return DB.Close()
+}
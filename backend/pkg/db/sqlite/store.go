@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"backend/pkg/store"
+)
+
+// NewStore returns the repositories backed by db, normally DB after InitDB.
+func NewStore(db *sql.DB) *store.Store {
+	return &store.Store{
+		Users:         &users{db},
+		Follows:       &follows{db},
+		Posts:         &posts{db},
+		Groups:        &groups{db},
+		Events:        &events{db},
+		Messages:      &messages{db},
+		Notifications: &notifications{db},
+	}
+}
+
+// notFound turns sql.ErrNoRows into store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// userColumns selects a store.UserSummary from the users table aliased u;
+// scan it with scanUser.
+const userColumns = `u.id, COALESCE(u.nickname, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), COALESCE(u.avatar, '')`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner, extra ...any) (store.UserSummary, error) {
+	var u store.UserSummary
+	err := row.Scan(append([]any{&u.ID, &u.Nickname, &u.FirstName, &u.LastName, &u.Avatar}, extra...)...)
+	return u, err
+}
+
+// queryUsers runs a query selecting userColumns; no rows is an empty list.
+func queryUsers(ctx context.Context, db *sql.DB, query string, args ...any) ([]store.UserSummary, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]store.UserSummary, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
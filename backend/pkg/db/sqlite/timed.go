@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"backend/pkg/metrics"
+)
+
+// timedDriverName is go-sqlite3 with every statement timed into
+// db_query_duration_seconds. InitDB opens it instead of "sqlite3".
+const timedDriverName = "sqlite3_timed"
+
+var queryDuration = metrics.NewHistogramVec("db_query_duration_seconds",
+	"Time spent in SQLite statements; for queries, until the first row is ready.",
+	metrics.DefBuckets, "op")
+
+func init() {
+	sql.Register(timedDriverName, timedDriver{&sqlite3.SQLiteDriver{}})
+}
+
+func observe(op string, start time.Time, err error) {
+	// ErrSkip means database/sql retries through a prepared statement, which is timed then
+	if !errors.Is(err, driver.ErrSkip) {
+		queryDuration.Observe(time.Since(start).Seconds(), op)
+	}
+}
+
+type timedDriver struct{ *sqlite3.SQLiteDriver }
+
+func (d timedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{c.(*sqlite3.SQLiteConn)}, nil
+}
+
+type timedConn struct{ *sqlite3.SQLiteConn }
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	res, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	observe("exec", start, err)
+	return res, err
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	observe("query", start, err)
+	return rows, err
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{s.(*sqlite3.SQLiteStmt)}, nil
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+type timedStmt struct{ *sqlite3.SQLiteStmt }
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.SQLiteStmt.ExecContext(ctx, args)
+	observe("exec", start, err)
+	return res, err
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.SQLiteStmt.QueryContext(ctx, args)
+	observe("query", start, err)
+	return rows, err
+}
@@ -0,0 +1,40 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"backend/pkg/store"
+)
+
+type users struct{ db *sql.DB }
+
+var _ store.Users = (*users)(nil)
+
+func (s *users) List(ctx context.Context) ([]store.UserSummary, error) {
+	return queryUsers(ctx, s.db, `SELECT `+userColumns+` FROM users u`)
+}
+
+func (s *users) ListExcept(ctx context.Context, userID string) ([]store.UserSummary, error) {
+	return queryUsers(ctx, s.db, `
+		SELECT `+userColumns+` FROM users u
+		WHERE u.id != ?
+		ORDER BY u.nickname
+	`, userID)
+}
+
+func (s *users) Summary(ctx context.Context, userID string) (store.UserSummary, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users u WHERE u.id = ?`, userID))
+	return u, notFound(err)
+}
+
+func (s *users) IsPublic(ctx context.Context, userID string) (bool, error) {
+	var public bool
+	err := s.db.QueryRowContext(ctx, `SELECT is_public FROM users WHERE id = ?`, userID).Scan(&public)
+	return public, notFound(err)
+}
+
+func (s *users) SetPublic(ctx context.Context, userID string, public bool) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET is_public = ? WHERE id = ?`, public, userID)
+	return err
+}
@@ -0,0 +1,72 @@
+// Package mailer sends transactional email. Only development transports
+// live here: Log prints messages and File writes them to a directory.
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+// Mailer delivers one message. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(m Message) error
+}
+
+// Log prints every message to the default logger.
+type Log struct{}
+
+func (Log) Send(m Message) error {
+	slog.Info("mail", "to", m.To, "subject", m.Subject, "body", m.Body)
+	return nil
+}
+
+// File writes each message to its own .eml file in Dir, so links in them can
+// be opened during development.
+type File struct {
+	Dir string
+	seq atomic.Uint64
+}
+
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mailer: %w", err)
+	}
+	return &File{Dir: dir}, nil
+}
+
+func (f *File) Send(m Message) error {
+	now := time.Now()
+	name := fmt.Sprintf("%s-%d-%s.eml", now.UTC().Format("20060102T150405"), f.seq.Add(1), safeName(m.To))
+	var b strings.Builder
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(m.Body)
+	if err := os.WriteFile(filepath.Join(f.Dir, name), []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("mailer: %w", err)
+	}
+	return nil
+}
+
+// safeName keeps an address usable as part of a file name.
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
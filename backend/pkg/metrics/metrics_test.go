@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fresh points Default at an empty registry for the test, so the New
+// functions don't collide with the metrics other packages register.
+func fresh(t *testing.T) *Registry {
+	t.Helper()
+	prev := Default
+	Default = &Registry{}
+	t.Cleanup(func() { Default = prev })
+	return Default
+}
+
+func exposition(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCounterText(t *testing.T) {
+	reg := fresh(t)
+	c := NewCounterVec("requests_total", "Requests by route.\nSecond line with a \\.", "route", "code")
+	c.Inc("/a", "200")
+	c.Add(2, "/a", "200")
+	c.Inc(`/b"q\`+"\n", "500")
+	NewCounterVec("empty_total", "Nothing yet.")
+
+	want := `# HELP empty_total Nothing yet.
+# TYPE empty_total counter
+empty_total 0
+# HELP requests_total Requests by route.\nSecond line with a \\.
+# TYPE requests_total counter
+requests_total{route="/a",code="200"} 3
+requests_total{route="/b\"q\\\n",code="500"} 1
+`
+	if got := exposition(t, reg); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	reg := fresh(t)
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a") // on a bound counts in that bucket
+	h.Observe(0.5, "/a")
+	h.Observe(3, "/a")
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 3
+latency_seconds_bucket{route="/a",le="+Inf"} 4
+latency_seconds_sum{route="/a"} 3.65
+latency_seconds_count{route="/a"} 4
+`
+	if got := exposition(t, reg); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncAndHandler(t *testing.T) {
+	reg := fresh(t)
+	n := 1.0
+	NewGaugeFunc("open_things", "Open things.", func() float64 { return n })
+	n = 7
+
+	rec := httptest.NewRecorder()
+	reg.Handler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "# TYPE open_things gauge\nopen_things 7\n") {
+		t.Errorf("body:\n%s", rec.Body.String())
+	}
+}
+
+func TestDuplicateNamePanics(t *testing.T) {
+	fresh(t)
+	NewCounterVec("dup_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering dup_total twice didn't panic")
+		}
+	}()
+	NewCounterVec("dup_total", "")
+}
@@ -0,0 +1,153 @@
+// Package ratelimit is a keyed token-bucket limiter with per-action limits.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Burst events at once, refilled at N events per Per.
+type Limit struct {
+	N     int
+	Per   time.Duration
+	Burst int
+}
+
+// Every is the common case: n events per period with a burst of n.
+func Every(n int, per time.Duration) Limit {
+	return Limit{N: n, Per: per, Burst: n}
+}
+
+func (l Limit) rate() float64 { return float64(l.N) / l.Per.Seconds() } // tokens per second
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter holds one bucket per (action, key). Actions without a limit are
+// never limited.
+type Limiter struct {
+	mu      sync.Mutex
+	limits  map[string]Limit
+	buckets map[string]*bucket
+	now     func() time.Time
+	sweep   time.Time
+}
+
+// New returns a Limiter enforcing limits; like Set, entries with a zero N are
+// left out so that action is not limited.
+func New(limits map[string]Limit) *Limiter {
+	l := &Limiter{
+		limits:  make(map[string]Limit, len(limits)),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	for action, lim := range limits {
+		if lim.N > 0 {
+			l.limits[action] = lim
+		}
+	}
+	return l
+}
+
+// Set replaces the limit for one action; a zero N removes it.
+func (l *Limiter) Set(action string, lim Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lim.N <= 0 {
+		delete(l.limits, action)
+		return
+	}
+	l.limits[action] = lim
+}
+
+// Allow spends one token from key's bucket for action. When the bucket is
+// empty it returns false and how long until a token is available.
+func (l *Limiter) Allow(action, key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.limits[action]
+	if !ok {
+		return true, 0
+	}
+	now := l.now()
+	l.sweepLocked(now)
+
+	id := action + "\x00" + key
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(lim.Burst), last: now}
+		l.buckets[id] = b
+	}
+	b.tokens = math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*lim.rate())
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / lim.rate() * float64(time.Second))
+	return false, wait
+}
+
+// sweepLocked drops buckets that have refilled completely, at most once a minute.
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+	for id, b := range l.buckets {
+		action, _, _ := strings.Cut(id, "\x00")
+		lim, ok := l.limits[action]
+		if !ok || b.tokens+now.Sub(b.last).Seconds()*lim.rate() >= float64(lim.Burst) {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// ParseLimits reads overrides like "login=10/5m,dm=20/10s:40" (N per
+// duration, optional burst after the colon). An N of 0 turns that action's
+// limit off.
+func ParseLimits(s string) (map[string]Limit, error) {
+	out := make(map[string]Limit)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		action, spec, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("ratelimit: %q: want action=N/duration", part)
+		}
+		spec, burstStr, hasBurst := strings.Cut(spec, ":")
+		nStr, perStr, ok := strings.Cut(spec, "/")
+		if !ok {
+			return nil, fmt.Errorf("ratelimit: %q: want action=N/duration", part)
+		}
+		n, err := strconv.Atoi(nStr)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("ratelimit: %q: bad count", part)
+		}
+		per, err := time.ParseDuration(perStr)
+		if err != nil || per <= 0 {
+			return nil, fmt.Errorf("ratelimit: %q: bad duration", part)
+		}
+		lim := Every(n, per)
+		if hasBurst {
+			if lim.Burst, err = strconv.Atoi(burstStr); err != nil || lim.Burst < 1 {
+				return nil, fmt.Errorf("ratelimit: %q: bad burst", part)
+			}
+		}
+		out[strings.TrimSpace(action)] = lim
+	}
+	return out, nil
+}
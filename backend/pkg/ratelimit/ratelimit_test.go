@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	got, err := ParseLimits(" login=10/5m, dm=20/10s:40 ,,typing=0/1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Limit{
+		"login":  {N: 10, Per: 5 * time.Minute, Burst: 10},
+		"dm":     {N: 20, Per: 10 * time.Second, Burst: 40},
+		"typing": {N: 0, Per: time.Second, Burst: 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for action, lim := range want {
+		if got[action] != lim {
+			t.Errorf("%s: got %+v, want %+v", action, got[action], lim)
+		}
+	}
+
+	for _, bad := range []string{"login", "login=10", "login=x/1m", "login=-1/1m", "login=1/0s", "login=1/1m:0", "login=1/1m:x"} {
+		if _, err := ParseLimits(bad); err == nil {
+			t.Errorf("ParseLimits(%q) accepted", bad)
+		}
+	}
+}
+
+func TestZeroLimitIsOff(t *testing.T) {
+	limits, err := ParseLimits("login=0/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(limits)
+	for i := 0; i < 100; i++ {
+		if ok, wait := l.Allow("login", "k"); !ok || wait != 0 {
+			t.Fatalf("attempt %d: got %v, %v", i, ok, wait)
+		}
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(map[string]Limit{"dm": {N: 1, Per: time.Second, Burst: 2}})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("dm", "a"); !ok {
+			t.Fatalf("burst attempt %d refused", i)
+		}
+	}
+	ok, wait := l.Allow("dm", "a")
+	if ok || wait != time.Second {
+		t.Fatalf("got %v, %v; want refused for 1s", ok, wait)
+	}
+	if ok, _ := l.Allow("dm", "b"); !ok {
+		t.Fatal("other key shares the bucket")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("dm", "a"); !ok {
+		t.Fatal("not refilled after a second")
+	}
+}
+
+func TestSetRemovesLimit(t *testing.T) {
+	l := New(map[string]Limit{"dm": Every(1, time.Hour)})
+	l.Allow("dm", "a")
+	if ok, _ := l.Allow("dm", "a"); ok {
+		t.Fatal("second attempt allowed")
+	}
+	l.Set("dm", Limit{})
+	if ok, _ := l.Allow("dm", "a"); !ok {
+		t.Fatal("still limited after Set with zero N")
+	}
+}
@@ -0,0 +1,170 @@
+// Package router builds an http.ServeMux from method routes with path
+// parameters ("GET /api/users/{id}/posts"), grouped under shared prefixes and
+// middleware. A known path requested with a method it doesn't serve gets a
+// JSON 405 with an Allow header, after the group's middleware has run, so CORS
+// preflights still reach the CORS middleware.
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps a handler, the same shape as the handlers package's
+// CSRFProtect or RequireVerified.
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+// Route is one entry of the route table.
+type Route struct {
+	Method  string // GET, POST, ...
+	Pattern string // full path pattern, e.g. /api/users/{id}/posts
+	Handler http.HandlerFunc
+	Tags    []string // labels from Tagged, e.g. for generated docs
+
+	middleware []Middleware // group middleware, then the route's own
+}
+
+// Methods a path is checked against for the 405 answer. HEAD is left to GET
+// routes, which ServeMux also matches for HEAD.
+var fallbackMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+type table struct {
+	routes []*Route
+	paths  map[string][]Middleware // pattern -> middleware of the group that first used it
+}
+
+// Router collects routes; Handler turns them into an http.Handler.
+type Router struct {
+	prefix     string
+	middleware []Middleware
+	tags       []string
+	t          *table
+}
+
+func New(mw ...Middleware) *Router {
+	return &Router{middleware: mw, t: &table{paths: map[string][]Middleware{}}}
+}
+
+// Group returns a router whose routes live under prefix and run mw inside the
+// parent's middleware.
+func (rt *Router) Group(prefix string, mw ...Middleware) *Router {
+	return &Router{
+		prefix:     rt.prefix + prefix,
+		middleware: append(append([]Middleware{}, rt.middleware...), mw...),
+		tags:       rt.tags,
+		t:          rt.t,
+	}
+}
+
+// Tagged returns a router like rt whose routes also carry tags. The tags
+// don't change how requests are served; they describe the routes, e.g. to
+// mark the ones behind an auth middleware.
+func (rt *Router) Tagged(tags ...string) *Router {
+	return &Router{
+		prefix:     rt.prefix,
+		middleware: rt.middleware,
+		tags:       append(append([]string{}, rt.tags...), tags...),
+		t:          rt.t,
+	}
+}
+
+// Handle registers h for method and pattern, which is relative to the group.
+// mw applies to this route only, inside the group's middleware.
+func (rt *Router) Handle(method, pattern string, h http.HandlerFunc, mw ...Middleware) {
+	full := rt.prefix + pattern
+	if _, ok := rt.t.paths[full]; !ok {
+		rt.t.paths[full] = rt.middleware
+	}
+	rt.t.routes = append(rt.t.routes, &Route{
+		Method:     method,
+		Pattern:    full,
+		Handler:    h,
+		Tags:       rt.tags,
+		middleware: append(append([]Middleware{}, rt.middleware...), mw...),
+	})
+}
+
+func (rt *Router) Get(pattern string, h http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodGet, pattern, h, mw...)
+}
+
+func (rt *Router) Post(pattern string, h http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodPost, pattern, h, mw...)
+}
+
+func (rt *Router) Delete(pattern string, h http.HandlerFunc, mw ...Middleware) {
+	rt.Handle(http.MethodDelete, pattern, h, mw...)
+}
+
+// Routes returns the route table in registration order.
+func (rt *Router) Routes() []Route {
+	out := make([]Route, len(rt.t.routes))
+	for i, r := range rt.t.routes {
+		out[i] = *r
+	}
+	return out
+}
+
+// Handler builds the mux. Conflicting or duplicate routes panic here, at
+// startup, like they do with ServeMux.
+func (rt *Router) Handler() http.Handler {
+	mux := http.NewServeMux()
+	methods := map[string]map[string]bool{}
+	for _, r := range rt.t.routes {
+		mux.HandleFunc(r.Method+" "+r.Pattern, chain(r.Handler, r.middleware))
+		if methods[r.Pattern] == nil {
+			methods[r.Pattern] = map[string]bool{}
+		}
+		methods[r.Pattern][r.Method] = true
+	}
+
+	for pattern, has := range methods {
+		allow := allowHeader(has)
+		h := chain(methodNotAllowed(allow), rt.t.paths[pattern])
+		for _, m := range fallbackMethods {
+			if !has[m] {
+				mux.HandleFunc(m+" "+pattern, h)
+			}
+		}
+	}
+	return mux
+}
+
+func chain(h http.HandlerFunc, mw []Middleware) http.HandlerFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+func allowHeader(has map[string]bool) string {
+	var allow []string
+	for m := range has {
+		allow = append(allow, m)
+	}
+	if has[http.MethodGet] && !has[http.MethodHead] {
+		allow = append(allow, http.MethodHead)
+	}
+	if !has[http.MethodOptions] {
+		allow = append(allow, http.MethodOptions)
+	}
+	sort.Strings(allow)
+	return strings.Join(allow, ", ")
+}
+
+func methodNotAllowed(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok":      false,
+			"code":    "method_not_allowed",
+			"message": "Method not allowed",
+		})
+	}
+}
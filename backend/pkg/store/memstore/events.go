@@ -0,0 +1,66 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"backend/pkg/store"
+)
+
+type events struct{ *DB }
+
+var _ store.Events = events{}
+
+func (s events) Create(_ context.Context, e store.Event) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = atoi(s.nextID("events"))
+	e.Datetime = e.Datetime.UTC().Truncate(time.Second)
+	e.CreatorName, e.UserResponse = "", ""
+	e.GoingCount, e.NotGoingCount, e.MightBeLateCount = 0, 0, 0
+	s.events = append(s.events, &e)
+	return e.ID, nil
+}
+
+func (s events) ForGroup(_ context.Context, groupID, viewerID string) ([]store.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]store.Event, 0)
+	for _, row := range s.events {
+		if row.GroupID != groupID {
+			continue
+		}
+		e := *row
+		id := itoa(e.ID)
+		if u := s.user(e.CreatedBy); u != nil {
+			e.CreatorName = u.FirstName + " " + u.LastName
+		}
+		for k, response := range s.votes {
+			if k[0] != id {
+				continue
+			}
+			switch response {
+			case store.EventGoing:
+				e.GoingCount++
+			case store.EventNotGoing:
+				e.NotGoingCount++
+			case store.EventMightBeLate:
+				e.MightBeLateCount++
+			}
+		}
+		e.UserResponse = s.votes[pair{id, viewerID}]
+		out = append(out, e)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Datetime.Before(out[j].Datetime) })
+	return out, nil
+}
+
+func (s events) Vote(_ context.Context, eventID, userID, response string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := pair{eventID, userID}
+	_, replaced := s.votes[k]
+	s.votes[k] = response
+	return replaced, nil
+}
@@ -0,0 +1,133 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"backend/pkg/store"
+)
+
+type follow struct {
+	status    string
+	createdAt time.Time
+}
+
+type follows struct{ *DB }
+
+var _ store.Follows = follows{}
+
+func (s follows) Status(_ context.Context, followerID, followingID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.follows[pair{followerID, followingID}]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return f.status, nil
+}
+
+func (s follows) IsFollowing(_ context.Context, followerID, followingID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted(followerID, followingID), nil
+}
+
+func (d *DB) accepted(followerID, followingID string) bool {
+	f, ok := d.follows[pair{followerID, followingID}]
+	return ok && f.status == store.FollowAccepted
+}
+
+func (s follows) Follow(_ context.Context, followerID, followingID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.follows[pair{followerID, followingID}] = &follow{status: status, createdAt: s.now()}
+	return nil
+}
+
+func (s follows) Answer(_ context.Context, followerID, followingID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.follows[pair{followerID, followingID}]; ok {
+		f.status = status
+	}
+	return nil
+}
+
+func (s follows) Unfollow(_ context.Context, followerID, followingID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := pair{followerID, followingID}
+	_, ok := s.follows[k]
+	delete(s.follows, k)
+	return ok, nil
+}
+
+func (s follows) AcceptPending(_ context.Context, followingID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, u := range s.users {
+		if f, ok := s.follows[pair{u.ID, followingID}]; ok && f.status == store.FollowPending {
+			f.status = store.FollowAccepted
+			f.createdAt = s.now()
+			ids = append(ids, u.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s follows) Followers(_ context.Context, userID string) ([]store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usersWhere(func(u *user) bool { return s.accepted(u.ID, userID) }), nil
+}
+
+func (s follows) Following(_ context.Context, userID string) ([]store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usersWhere(func(u *user) bool { return s.accepted(userID, u.ID) }), nil
+}
+
+func (s follows) Counts(_ context.Context, userID string) (followers, following int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, f := range s.follows {
+		if f.status != store.FollowAccepted {
+			continue
+		}
+		if k[1] == userID {
+			followers++
+		}
+		if k[0] == userID {
+			following++
+		}
+	}
+	return followers, following, nil
+}
+
+func (s follows) Pending(_ context.Context, userID string) ([]store.PendingFollow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]store.PendingFollow, 0)
+	for _, u := range s.users {
+		if f, ok := s.follows[pair{u.ID, userID}]; ok && f.status == store.FollowPending {
+			out = append(out, store.PendingFollow{UserSummary: u.UserSummary, CreatedAt: f.createdAt.Format(time.RFC3339)})
+		}
+	}
+	// newest request first; RFC3339 in UTC sorts like the time
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out, nil
+}
+
+func (s follows) PendingCount(_ context.Context, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k, f := range s.follows {
+		if k[1] == userID && f.status == store.FollowPending {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,220 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"backend/pkg/store"
+)
+
+type groups struct{ *DB }
+
+var _ store.Groups = groups{}
+
+func (d *DB) group(id string) *store.Group {
+	for _, g := range d.groups {
+		if g.ID == atoi(id) {
+			return g
+		}
+	}
+	return nil
+}
+
+func (d *DB) memberCount(groupID string) int {
+	n := 0
+	for k, status := range d.members {
+		if k[0] == groupID && status == store.MemberAccepted {
+			n++
+		}
+	}
+	return n
+}
+
+func (s groups) List(_ context.Context, viewerID string) ([]store.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]store.Group, 0, len(s.groups))
+	for i := len(s.groups) - 1; i >= 0; i-- {
+		g := *s.groups[i]
+		id := itoa(g.ID)
+		g.MemberCount = s.memberCount(id)
+		g.IsMember = s.members[pair{id, viewerID}] == store.MemberAccepted
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+func (s groups) Get(_ context.Context, groupID string) (store.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.group(groupID)
+	if g == nil {
+		return store.Group{}, store.ErrNotFound
+	}
+	return *g, nil
+}
+
+func (s groups) Create(_ context.Context, g store.Group, invitees []string) (int64, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID("groups")
+	g.ID = atoi(id)
+	g.CreatedAt = s.now().Format(time.RFC3339)
+	g.MemberCount, g.IsMember = 0, false
+	s.groups = append(s.groups, &g)
+	s.members[pair{id, g.CreatorID}] = store.MemberAccepted
+	return g.ID, s.invite(id, invitees), nil
+}
+
+// invite is Invite with the lock held.
+func (d *DB) invite(groupID string, userIDs []string) []string {
+	var invited []string
+	for _, id := range userIDs {
+		k := pair{groupID, id}
+		if _, linked := d.members[k]; linked || d.user(id) == nil {
+			continue
+		}
+		d.members[k] = store.MemberInvited
+		invited = append(invited, id)
+	}
+	return invited
+}
+
+func (s groups) Invite(_ context.Context, groupID string, userIDs []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.invite(groupID, userIDs), nil
+}
+
+func (s groups) Delete(_ context.Context, groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.group(groupID)
+	if g == nil {
+		return store.ErrNotFound
+	}
+	for i, row := range s.groups {
+		if row == g {
+			s.groups = append(s.groups[:i], s.groups[i+1:]...)
+			break
+		}
+	}
+	for k := range s.members {
+		if k[0] == groupID {
+			delete(s.members, k)
+		}
+	}
+	for k := range s.reads {
+		if k[0] == groupID {
+			delete(s.reads, k)
+		}
+	}
+	kept := s.groupMsgs[:0]
+	for _, m := range s.groupMsgs {
+		if m.GroupID != groupID {
+			kept = append(kept, m)
+		}
+	}
+	s.groupMsgs = kept
+	return nil
+}
+
+func (s groups) MemberStatus(_ context.Context, groupID, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.members[pair{groupID, userID}]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return status, nil
+}
+
+func (s groups) AddMember(_ context.Context, groupID, userID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := pair{groupID, userID}
+	if _, ok := s.members[k]; ok {
+		return errors.New("memstore: user already linked to group")
+	}
+	s.members[k] = status
+	return nil
+}
+
+func (s groups) SetMemberStatus(_ context.Context, groupID, userID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := pair{groupID, userID}
+	if _, ok := s.members[k]; ok {
+		s.members[k] = status
+	}
+	return nil
+}
+
+func (s groups) RemoveMember(_ context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members, pair{groupID, userID})
+	return nil
+}
+
+func (s groups) MemberIDs(ctx context.Context, groupID string) ([]string, error) {
+	members, err := s.Members(ctx, groupID)
+	ids := make([]string, len(members))
+	for i, u := range members {
+		ids[i] = u.ID
+	}
+	return ids, err
+}
+
+func (s groups) Members(_ context.Context, groupID string) ([]store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usersWhere(func(u *user) bool {
+		return s.members[pair{groupID, u.ID}] == store.MemberAccepted
+	}), nil
+}
+
+func (s groups) InviteCandidates(_ context.Context, groupID, userID string) ([]store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.usersWhere(func(u *user) bool {
+		_, linked := s.members[pair{groupID, u.ID}]
+		return u.ID != userID && !linked
+	})
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Nickname < out[j].Nickname })
+	return out, nil
+}
+
+func (s groups) Invites(_ context.Context, userID string) ([]store.GroupInvite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []store.GroupInvite
+	for _, g := range s.groups {
+		creator := s.user(g.CreatorID)
+		if creator == nil || s.members[pair{itoa(g.ID), userID}] != store.MemberInvited {
+			continue
+		}
+		out = append(out, store.GroupInvite{Group: *g, CreatorName: creator.FirstName + " " + creator.LastName})
+	}
+	return out, nil
+}
+
+func (s groups) JoinRequests(_ context.Context, creatorID string) ([]store.JoinRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []store.JoinRequest
+	for _, g := range s.groups {
+		if g.CreatorID != creatorID {
+			continue
+		}
+		id := itoa(g.ID)
+		for _, u := range s.users {
+			if s.members[pair{id, u.ID}] == store.MemberRequested {
+				out = append(out, store.JoinRequest{GroupID: g.ID, GroupTitle: g.Title, User: u.UserSummary})
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,284 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"backend/pkg/store"
+)
+
+type messages struct{ *DB }
+
+var _ store.Messages = messages{}
+
+// errDuplicate mirrors the unique index on (sender_id, client_msg_id).
+var errDuplicate = errors.New("memstore: client_msg_id already used by sender")
+
+func (s messages) CreateDM(_ context.Context, m store.Message) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := byClientID(s.dms, m.SenderID, m.ClientMsgID); ok {
+		return "", time.Time{}, errDuplicate
+	}
+	ups, ok := s.freeUploads(m)
+	if !ok {
+		return "", time.Time{}, store.ErrAttachmentUnavailable
+	}
+	m.ID, m.SentAt, m.GroupID, m.Sender = s.nextID("messages"), s.now(), "", store.UserSummary{}
+	s.dms = append(s.dms, m)
+	for _, u := range ups {
+		u.kind, u.messageID = "dm", m.ID
+	}
+	return m.ID, m.SentAt, nil
+}
+
+func (s messages) CreateGroupMessage(_ context.Context, m store.Message) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := byClientID(s.groupMsgs, m.SenderID, m.ClientMsgID); ok {
+		return "", time.Time{}, errDuplicate
+	}
+	ups, ok := s.freeUploads(m)
+	if !ok {
+		return "", time.Time{}, store.ErrAttachmentUnavailable
+	}
+	m.ID, m.SentAt, m.ReceiverID, m.Sender = s.nextID("group_chat"), s.now(), "", store.UserSummary{}
+	s.groupMsgs = append(s.groupMsgs, m)
+	for _, u := range ups {
+		u.kind, u.messageID = "group", m.ID
+	}
+	return m.ID, m.SentAt, nil
+}
+
+// freeUploads finds m's attachments, each of which must be the sender's
+// and not sent yet; ok is false if one of them isn't.
+func (d *DB) freeUploads(m store.Message) (ups []*upload, ok bool) {
+	for _, id := range m.Attachments {
+		i := slices.IndexFunc(d.uploads, func(u *upload) bool { return u.ID == id })
+		if i < 0 || d.uploads[i].uploaderID != m.SenderID || d.uploads[i].messageID != "" || slices.Contains(ups, d.uploads[i]) {
+			return nil, false
+		}
+		ups = append(ups, d.uploads[i])
+	}
+	return ups, true
+}
+
+func byClientID(msgs []store.Message, senderID, clientMsgID string) (store.Message, bool) {
+	if clientMsgID == "" {
+		return store.Message{}, false
+	}
+	for _, m := range msgs {
+		if m.SenderID == senderID && m.ClientMsgID == clientMsgID {
+			return m, true
+		}
+	}
+	return store.Message{}, false
+}
+
+func (s messages) DMByClientID(_ context.Context, senderID, clientMsgID string) (store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := byClientID(s.dms, senderID, clientMsgID)
+	if !ok {
+		return store.Message{}, store.ErrNotFound
+	}
+	return m, nil
+}
+
+func (s messages) GroupMessageByClientID(_ context.Context, senderID, clientMsgID string) (store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := byClientID(s.groupMsgs, senderID, clientMsgID)
+	if !ok {
+		return store.Message{}, store.ErrNotFound
+	}
+	found := s.groupMessagesWhere(func(row store.Message) bool { return row.ID == m.ID }, 1)
+	if len(found) == 0 {
+		return store.Message{}, store.ErrNotFound
+	}
+	return found[0], nil
+}
+
+// dmsWhere returns up to limit DMs keep accepts, oldest first; limit < 0 means all.
+func (d *DB) dmsWhere(keep func(store.Message) bool, limit int) []store.Message {
+	out := make([]store.Message, 0)
+	for _, m := range d.dms {
+		if len(out) == limit {
+			break
+		}
+		if keep(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// groupMessagesWhere is dmsWhere for group messages, with Sender filled in.
+// Like the SQLite join, messages whose sender is gone are left out.
+func (d *DB) groupMessagesWhere(keep func(store.Message) bool, limit int) []store.Message {
+	out := make([]store.Message, 0)
+	for _, m := range d.groupMsgs {
+		if len(out) == limit {
+			break
+		}
+		u := d.user(m.SenderID)
+		if u == nil || !keep(m) {
+			continue
+		}
+		m.Sender = u.UserSummary
+		out = append(out, m)
+	}
+	return out
+}
+
+func (s messages) DMHistory(_ context.Context, userID, peerID string, limit int) ([]store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dmsWhere(func(m store.Message) bool {
+		return (m.SenderID == userID && m.ReceiverID == peerID) || (m.SenderID == peerID && m.ReceiverID == userID)
+	}, limit), nil
+}
+
+func (s messages) GroupHistory(_ context.Context, groupID string) ([]store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groupMessagesWhere(func(m store.Message) bool { return m.GroupID == groupID }, -1), nil
+}
+
+func (s messages) MissedDMs(_ context.Context, userID string, afterID int64, since time.Time, limit int) ([]store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dmsWhere(func(m store.Message) bool {
+		return (m.SenderID == userID || m.ReceiverID == userID) && atoi(m.ID) > afterID &&
+			(since.IsZero() || m.SentAt.After(since))
+	}, limit), nil
+}
+
+func (s messages) MissedGroupMessages(_ context.Context, userID string, afterID int64, since time.Time, limit int) ([]store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groupMessagesWhere(func(m store.Message) bool {
+		return s.members[pair{m.GroupID, userID}] == store.MemberAccepted && atoi(m.ID) > afterID &&
+			(since.IsZero() || m.SentAt.After(since))
+	}, limit), nil
+}
+
+func (s messages) MarkGroupRead(_ context.Context, groupID, userID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := atoi(messageID)
+	found := false
+	for _, m := range s.groupMsgs {
+		if m.GroupID != groupID {
+			continue
+		}
+		if messageID == "" {
+			id = max(id, atoi(m.ID))
+		} else if id > 0 && atoi(m.ID) == id {
+			found = true
+		}
+	}
+	if messageID != "" && !found {
+		return store.ErrNotFound
+	}
+	k := pair{groupID, userID}
+	s.reads[k] = max(s.reads[k], id)
+	return nil
+}
+
+func (s messages) GroupUnread(_ context.Context, groupID, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unread(groupID, userID), nil
+}
+
+func (d *DB) unread(groupID, userID string) int {
+	n := 0
+	last := d.reads[pair{groupID, userID}]
+	for _, m := range d.groupMsgs {
+		if m.GroupID == groupID && m.SenderID != userID && atoi(m.ID) > last {
+			n++
+		}
+	}
+	return n
+}
+
+func (s messages) GroupUnreadCounts(_ context.Context, userID string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int)
+	for k, status := range s.members {
+		if k[1] == userID && status == store.MemberAccepted {
+			out[k[0]] = s.unread(k[0], userID)
+		}
+	}
+	return out, nil
+}
+
+func (s messages) Parents(_ context.Context, kind string, ids []string) (map[string]store.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := func(m store.Message) bool { return slices.Contains(ids, m.ID) }
+	var msgs []store.Message
+	switch kind {
+	case "dm":
+		msgs = s.dmsWhere(keep, -1)
+	case "group":
+		msgs = s.groupMessagesWhere(keep, -1)
+	default:
+		return nil, fmt.Errorf("unknown message kind %q", kind)
+	}
+	out := make(map[string]store.Message)
+	for _, m := range msgs {
+		out[m.ID] = m
+	}
+	return out, nil
+}
+
+func (s messages) Attachments(_ context.Context, kind string, messageIDs []string) (map[string][]store.Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]store.Attachment)
+	for _, u := range s.uploads {
+		if u.kind == kind && slices.Contains(messageIDs, u.messageID) {
+			out[u.messageID] = append(out[u.messageID], u.Attachment)
+		}
+	}
+	return out, nil
+}
+
+func (s messages) Reactions(_ context.Context, kind string, messageIDs []string) (map[string][]store.ReactionSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]store.ReactionSummary)
+	for _, r := range s.reactions {
+		if r.kind != kind || !slices.Contains(messageIDs, r.messageID) {
+			continue
+		}
+		list := out[r.messageID]
+		i := slices.IndexFunc(list, func(sum store.ReactionSummary) bool { return sum.Emoji == r.emoji })
+		if i < 0 {
+			list = append(list, store.ReactionSummary{Emoji: r.emoji})
+			i = len(list) - 1
+		}
+		list[i].Count++
+		list[i].Users = append(list[i].Users, r.userID)
+		out[r.messageID] = list
+	}
+	return out, nil
+}
+
+func (s messages) ToggleReaction(_ context.Context, kind, messageID, userID, emoji string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := reaction{kind: kind, messageID: messageID, userID: userID, emoji: emoji}
+	if i := slices.Index(s.reactions, r); i >= 0 {
+		s.reactions = slices.Delete(s.reactions, i, i+1)
+		return false, nil
+	}
+	s.reactions = append(s.reactions, r)
+	return true, nil
+}
@@ -0,0 +1,67 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"backend/pkg/store"
+)
+
+type notifications struct{ *DB }
+
+var _ store.Notifications = notifications{}
+
+func (s notifications) Create(_ context.Context, recipientID, typ string, content json.RawMessage) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := &store.Notification{
+		ID:          atoi(s.nextID("notifications")),
+		RecipientID: recipientID,
+		Type:        typ,
+		Content:     content,
+		CreatedAt:   s.now(),
+	}
+	s.notifications = append(s.notifications, n)
+	return n.ID, nil
+}
+
+func (s notifications) UnreadCount(_ context.Context, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, n := range s.notifications {
+		if n.RecipientID == userID && !n.IsRead {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s notifications) MarkRead(_ context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.notifications {
+		if n.RecipientID == userID && n.ID == atoi(id) {
+			n.IsRead = true
+		}
+	}
+	return nil
+}
+
+func (s notifications) MarkDMRead(_ context.Context, userID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.notifications {
+		if n.RecipientID != userID || n.Type != "dm" {
+			continue
+		}
+		var content struct {
+			MessageID any `json:"messageId"`
+		}
+		if json.Unmarshal(n.Content, &content) == nil && fmt.Sprint(content.MessageID) == messageID {
+			n.IsRead = true
+		}
+	}
+	return nil
+}
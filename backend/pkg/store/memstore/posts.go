@@ -0,0 +1,71 @@
+package memstore
+
+import (
+	"context"
+
+	"backend/pkg/store"
+)
+
+type post struct {
+	store.Post
+	viewers map[string]bool // privacy "custom" only
+}
+
+type posts struct{ *DB }
+
+var _ store.Posts = posts{}
+
+func (s posts) Create(_ context.Context, p store.NewPost) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row := &post{Post: store.Post{
+		UserID:    p.UserID,
+		PostID:    atoi(s.nextID("posts")),
+		Content:   p.Content,
+		Image:     p.Image,
+		Privacy:   p.Privacy,
+		CreatedAt: s.now(),
+	}}
+	if p.Privacy == "custom" {
+		row.viewers = make(map[string]bool)
+		for _, id := range p.Viewers {
+			row.viewers[id] = true
+		}
+	}
+	s.posts = append(s.posts, row)
+	return row.PostID, nil
+}
+
+// Feed applies the same visibility rules as the SQLite feed query.
+func (s posts) Feed(_ context.Context, q store.FeedQuery) ([]store.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]store.Post, 0)
+	for i := len(s.posts) - 1; i >= 0; i-- {
+		p := s.posts[i]
+		author := s.user(p.UserID)
+		if author == nil || (q.AuthorID != "" && p.UserID != q.AuthorID) || !s.visible(p, q.ViewerID) {
+			continue
+		}
+		row := p.Post
+		row.Nickname = author.Nickname
+		row.FirstName = author.FirstName
+		row.LastName = author.LastName
+		row.Avatar = author.Avatar
+		row.FollowingLikes = []string{}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+func (d *DB) visible(p *post, viewerID string) bool {
+	switch {
+	case p.UserID == viewerID, p.Privacy == "public":
+		return true
+	case p.Privacy == "followers":
+		return d.accepted(viewerID, p.UserID)
+	case p.Privacy == "custom":
+		return p.viewers[viewerID]
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+// Package memstore is an in-memory store.Store for handler tests. It keeps
+// the rules the SQLite queries encode (feed visibility, accepted-only
+// membership, read markers) but has no comments or likes, and attachments
+// are added with AddUpload instead of being uploaded.
+package memstore
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"backend/pkg/store"
+)
+
+// pair keys the link tables, e.g. {follower, following} or {group, user}.
+type pair [2]string
+
+type user struct {
+	store.UserSummary
+	public bool
+}
+
+// upload is a chat attachment; kind and messageID are set once it is sent.
+type upload struct {
+	store.Attachment
+	uploaderID, kind, messageID string
+}
+
+type reaction struct {
+	kind, messageID, userID, emoji string
+}
+
+// DB holds every table. Rows are kept in insertion order, which is also id
+// and time order, so "newest first" is a reverse walk.
+type DB struct {
+	// Now stamps new rows; tests may replace it to control time.
+	Now func() time.Time
+
+	mu            sync.Mutex
+	ids           map[string]int64 // last id per table
+	users         []*user
+	follows       map[pair]*follow // {follower, following}
+	posts         []*post
+	groups        []*store.Group
+	members       map[pair]string // {group, user} -> status
+	events        []*store.Event
+	votes         map[pair]string // {event, user} -> response
+	dms           []store.Message
+	groupMsgs     []store.Message
+	reads         map[pair]int64 // {group, user} -> last read message id
+	uploads       []*upload
+	reactions     []reaction // oldest first
+	notifications []*store.Notification
+}
+
+func New() *DB {
+	return &DB{
+		Now:     time.Now,
+		ids:     make(map[string]int64),
+		follows: make(map[pair]*follow),
+		members: make(map[pair]string),
+		votes:   make(map[pair]string),
+		reads:   make(map[pair]int64),
+	}
+}
+
+// Store returns the repositories, all backed by d.
+func (d *DB) Store() *store.Store {
+	return &store.Store{
+		Users:         users{d},
+		Follows:       follows{d},
+		Posts:         posts{d},
+		Groups:        groups{d},
+		Events:        events{d},
+		Messages:      messages{d},
+		Notifications: notifications{d},
+	}
+}
+
+// AddUser stores u, giving it the next id when u.ID is empty, and returns its id.
+func (d *DB) AddUser(u store.UserSummary, public bool) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if u.ID == "" {
+		u.ID = d.nextID("users")
+	}
+	d.users = append(d.users, &user{UserSummary: u, public: public})
+	return u.ID
+}
+
+// AddUpload stores a chat attachment the user uploaded but hasn't sent yet,
+// giving it the next id when a.ID is empty, and returns its id.
+func (d *DB) AddUpload(uploaderID string, a store.Attachment) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if a.ID == "" {
+		a.ID = d.nextID("chat_attachments")
+	}
+	d.uploads = append(d.uploads, &upload{Attachment: a, uploaderID: uploaderID})
+	return a.ID
+}
+
+// NotificationsFor returns what was sent to the user, oldest first.
+func (d *DB) NotificationsFor(userID string) []store.Notification {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []store.Notification
+	for _, n := range d.notifications {
+		if n.RecipientID == userID {
+			out = append(out, *n)
+		}
+	}
+	return out
+}
+
+func (d *DB) nextID(table string) string {
+	d.ids[table]++
+	return itoa(d.ids[table])
+}
+
+// now is d.Now at the precision SQLite stores.
+func (d *DB) now() time.Time {
+	return d.Now().UTC().Truncate(time.Second)
+}
+
+func (d *DB) user(id string) *user {
+	for _, u := range d.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// usersWhere returns the users keep accepts, in the order they were added.
+func (d *DB) usersWhere(keep func(u *user) bool) []store.UserSummary {
+	out := make([]store.UserSummary, 0)
+	for _, u := range d.users {
+		if keep(u) {
+			out = append(out, u.UserSummary)
+		}
+	}
+	return out
+}
+
+// atoi parses an id; ids that don't parse are 0 and match nothing.
+func atoi(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+func itoa(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
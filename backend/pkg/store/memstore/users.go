@@ -0,0 +1,55 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+
+	"backend/pkg/store"
+)
+
+type users struct{ *DB }
+
+var _ store.Users = users{}
+
+func (s users) List(context.Context) ([]store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usersWhere(func(*user) bool { return true }), nil
+}
+
+func (s users) ListExcept(_ context.Context, userID string) ([]store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.usersWhere(func(u *user) bool { return u.ID != userID })
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Nickname < out[j].Nickname })
+	return out, nil
+}
+
+func (s users) Summary(_ context.Context, userID string) (store.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(userID)
+	if u == nil {
+		return store.UserSummary{}, store.ErrNotFound
+	}
+	return u.UserSummary, nil
+}
+
+func (s users) IsPublic(_ context.Context, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(userID)
+	if u == nil {
+		return false, store.ErrNotFound
+	}
+	return u.public, nil
+}
+
+func (s users) SetPublic(_ context.Context, userID string, public bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u := s.user(userID); u != nil {
+		u.public = public
+	}
+	return nil
+}
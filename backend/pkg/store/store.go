@@ -0,0 +1,297 @@
+// Package store declares the repositories handlers read and write through,
+// so they don't depend on SQL. pkg/db/sqlite implements them for the server;
+// memstore keeps everything in maps for handler tests.
+//
+// Ids are passed as the strings handlers receive them in forms, queries and
+// frames; numeric ids that don't parse simply match nothing.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when the row a single-row lookup asks for doesn't exist.
+var ErrNotFound = errors.New("store: not found")
+
+// ErrAttachmentUnavailable is returned when a message names an attachment that
+// doesn't exist, belongs to someone else or was already sent.
+var ErrAttachmentUnavailable = errors.New("store: attachment unknown or already sent")
+
+// Store bundles the repositories; handlers get it from main.
+type Store struct {
+	Users         Users
+	Follows       Follows
+	Posts         Posts
+	Groups        Groups
+	Events        Events
+	Messages      Messages
+	Notifications Notifications
+}
+
+// Follow statuses.
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+	FollowDeclined = "declined"
+)
+
+// Group membership statuses.
+const (
+	MemberInvited   = "invited"
+	MemberRequested = "requested"
+	MemberAccepted  = "accepted"
+)
+
+// Event answers, as the client sends and shows them.
+const (
+	EventGoing       = "I'll be there"
+	EventNotGoing    = "Can't make it"
+	EventMightBeLate = "Might be late"
+)
+
+// UserSummary is the public part of a profile shown next to content.
+type UserSummary struct {
+	ID        string `json:"id"`
+	Nickname  string `json:"nickname"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Avatar    string `json:"avatar"`
+}
+
+// PendingFollow is a follow request waiting for the followed user's answer.
+type PendingFollow struct {
+	UserSummary
+	CreatedAt string `json:"createdAt"`
+}
+
+type Users interface {
+	// List returns every user; ListExcept every user but one, by nickname.
+	List(ctx context.Context) ([]UserSummary, error)
+	ListExcept(ctx context.Context, userID string) ([]UserSummary, error)
+	Summary(ctx context.Context, userID string) (UserSummary, error)
+	IsPublic(ctx context.Context, userID string) (bool, error)
+	SetPublic(ctx context.Context, userID string, public bool) error
+}
+
+type Follows interface {
+	// Status is the follower's status towards following, ErrNotFound if
+	// they never asked to follow.
+	Status(ctx context.Context, followerID, followingID string) (string, error)
+	IsFollowing(ctx context.Context, followerID, followingID string) (bool, error)
+	// Follow creates or restarts a follow with the given status.
+	Follow(ctx context.Context, followerID, followingID, status string) error
+	// Answer sets the status of an existing request.
+	Answer(ctx context.Context, followerID, followingID, status string) error
+	// Unfollow reports whether there was a follow to remove.
+	Unfollow(ctx context.Context, followerID, followingID string) (bool, error)
+	// AcceptPending accepts every request to followingID and returns who sent them.
+	AcceptPending(ctx context.Context, followingID string) ([]string, error)
+	Followers(ctx context.Context, userID string) ([]UserSummary, error)
+	Following(ctx context.Context, userID string) ([]UserSummary, error)
+	Counts(ctx context.Context, userID string) (followers, following int, err error)
+	Pending(ctx context.Context, userID string) ([]PendingFollow, error)
+	PendingCount(ctx context.Context, userID string) (int, error)
+}
+
+type Post struct {
+	UserID         string    `json:"user_id"`
+	PostID         int64     `json:"post_id,omitempty"`
+	Nickname       string    `json:"nickname"`
+	FirstName      string    `json:"firstName,omitempty"`
+	LastName       string    `json:"lastName,omitempty"`
+	Avatar         string    `json:"avatar,omitempty"`
+	Content        string    `json:"content"`
+	Image          string    `json:"image"`
+	Privacy        string    `json:"privacy"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+	CommentCount   int       `json:"comment_count,omitempty"`
+	LikeCount      int       `json:"like_count,omitempty"`
+	IsLiked        bool      `json:"is_liked,omitempty"`        // Indicates if the current user liked this post
+	FollowingLikes []string  `json:"following_likes,omitempty"` // Indicates if the current user follows likes on this post
+}
+
+// NewPost is a post to create; Viewers are who may see a custom post.
+type NewPost struct {
+	UserID  string
+	Content string
+	Image   string
+	Privacy string
+	Viewers []string
+}
+
+// FeedQuery selects the posts ViewerID may see, newest first: public ones,
+// follower-only ones of people they follow, custom ones shared with them and
+// their own. AuthorID narrows it to one profile.
+type FeedQuery struct {
+	ViewerID string
+	AuthorID string
+}
+
+type Posts interface {
+	Create(ctx context.Context, p NewPost) (int64, error)
+	Feed(ctx context.Context, q FeedQuery) ([]Post, error)
+}
+
+type Group struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	CreatorID   string `json:"creator_id"`
+	CreatedAt   string `json:"createdAt"`
+	MemberCount int    `json:"member_count"`
+	IsMember    bool   `json:"is_member"`
+}
+
+// GroupInvite is a group the user was invited to.
+type GroupInvite struct {
+	Group       Group
+	CreatorName string
+}
+
+// JoinRequest is a user asking to join one of the creator's groups.
+type JoinRequest struct {
+	GroupID    int64
+	GroupTitle string
+	User       UserSummary
+}
+
+type Groups interface {
+	// List returns every group with counts and IsMember set for viewerID.
+	List(ctx context.Context, viewerID string) ([]Group, error)
+	Get(ctx context.Context, groupID string) (Group, error)
+	// Create makes the group with its creator as first member and invites
+	// the others, all or nothing; it returns who was actually invited.
+	Create(ctx context.Context, g Group, invitees []string) (int64, []string, error)
+	// Delete removes the group with its members and chat.
+	Delete(ctx context.Context, groupID string) error
+
+	// MemberStatus is ErrNotFound when the user has no link to the group.
+	MemberStatus(ctx context.Context, groupID, userID string) (string, error)
+	AddMember(ctx context.Context, groupID, userID, status string) error
+	SetMemberStatus(ctx context.Context, groupID, userID, status string) error
+	RemoveMember(ctx context.Context, groupID, userID string) error
+	// Invite skips users already member, invited or asking to join, and
+	// returns the ones it invited.
+	Invite(ctx context.Context, groupID string, userIDs []string) ([]string, error)
+
+	// MemberIDs and Members are the accepted members.
+	MemberIDs(ctx context.Context, groupID string) ([]string, error)
+	Members(ctx context.Context, groupID string) ([]UserSummary, error)
+	// InviteCandidates is everyone but userID with no link to the group.
+	InviteCandidates(ctx context.Context, groupID, userID string) ([]UserSummary, error)
+	Invites(ctx context.Context, userID string) ([]GroupInvite, error)
+	JoinRequests(ctx context.Context, creatorID string) ([]JoinRequest, error)
+}
+
+// Event is a group event with the answers it got so far. Datetime is UTC.
+type Event struct {
+	ID               int64
+	GroupID          string
+	Title            string
+	Description      string
+	Datetime         time.Time
+	CreatedBy        string
+	CreatorName      string // empty when the creator is gone
+	GoingCount       int
+	NotGoingCount    int
+	MightBeLateCount int
+	UserResponse     string // the viewer's answer, if any
+}
+
+type Events interface {
+	Create(ctx context.Context, e Event) (int64, error)
+	// ForGroup returns the group's events, soonest first, with viewerID's answers.
+	ForGroup(ctx context.Context, groupID, viewerID string) ([]Event, error)
+	// Vote records the user's answer and reports whether it replaced an earlier one.
+	Vote(ctx context.Context, eventID, userID, response string) (bool, error)
+}
+
+// Message is a direct message (ReceiverID set) or a group chat message
+// (GroupID set). ReplyTo is empty when it quotes nothing.
+type Message struct {
+	ID          string
+	SenderID    string
+	ReceiverID  string
+	GroupID     string
+	Content     string
+	SentAt      time.Time
+	ReplyTo     string
+	ClientMsgID string
+	Sender      UserSummary // group messages only
+	Attachments []string    // upload ids bound to the message when it is created
+}
+
+// Attachment is a file sent with a chat message. URL is left for handlers
+// to fill in, since they decide where files are served from.
+type Attachment struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url"`
+}
+
+// ReactionSummary is one emoji on a message with everyone who used it.
+type ReactionSummary struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+type Messages interface {
+	// CreateDM and CreateGroupMessage return the id and send time the database
+	// gave it. The message and its attachments are stored together or not at
+	// all; ErrAttachmentUnavailable if one of them can't be bound.
+	CreateDM(ctx context.Context, m Message) (string, time.Time, error)
+	CreateGroupMessage(ctx context.Context, m Message) (string, time.Time, error)
+	// DMByClientID and GroupMessageByClientID find a retried send; ErrNotFound otherwise.
+	DMByClientID(ctx context.Context, senderID, clientMsgID string) (Message, error)
+	GroupMessageByClientID(ctx context.Context, senderID, clientMsgID string) (Message, error)
+
+	// DMHistory returns up to limit messages between the two users, oldest first.
+	DMHistory(ctx context.Context, userID, peerID string, limit int) ([]Message, error)
+	GroupHistory(ctx context.Context, groupID string) ([]Message, error)
+	// MissedDMs and MissedGroupMessages return up to limit messages for the
+	// user with ids above afterID and, unless since is zero, sent after since.
+	MissedDMs(ctx context.Context, userID string, afterID int64, since time.Time, limit int) ([]Message, error)
+	MissedGroupMessages(ctx context.Context, userID string, afterID int64, since time.Time, limit int) ([]Message, error)
+
+	// MarkGroupRead moves the read marker forward to messageID, or to the
+	// newest message when it is empty; it never moves back. It is
+	// ErrNotFound when messageID isn't a message of the group.
+	MarkGroupRead(ctx context.Context, groupID, userID, messageID string) error
+	GroupUnread(ctx context.Context, groupID, userID string) (int, error)
+	// GroupUnreadCounts is GroupUnread for each of the user's groups, keyed by group id.
+	GroupUnreadCounts(ctx context.Context, userID string) (map[string]int, error)
+
+	// Parents, Attachments and Reactions load what is shown alongside a page
+	// of messages of one kind, "dm" or "group", keyed by message id. Parents
+	// returns the quoted messages themselves; attachments come in upload
+	// order and reactions grouped by emoji in the order they were first used.
+	Parents(ctx context.Context, kind string, ids []string) (map[string]Message, error)
+	Attachments(ctx context.Context, kind string, messageIDs []string) (map[string][]Attachment, error)
+	Reactions(ctx context.Context, kind string, messageIDs []string) (map[string][]ReactionSummary, error)
+	// ToggleReaction adds the user's emoji to the message, or takes it off
+	// if it is already there, and reports whether it was added.
+	ToggleReaction(ctx context.Context, kind, messageID, userID, emoji string) (bool, error)
+}
+
+type Notification struct {
+	ID          int64           `json:"id"`
+	RecipientID string          `json:"recipient_id"`
+	Type        string          `json:"type"`
+	Content     json.RawMessage `json:"content"`
+	IsRead      bool            `json:"is_read"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type Notifications interface {
+	Create(ctx context.Context, recipientID, typ string, content json.RawMessage) (int64, error)
+	UnreadCount(ctx context.Context, userID string) (int, error)
+	MarkRead(ctx context.Context, userID, id string) error
+	// MarkDMRead marks the notification of one direct message read.
+	MarkDMRead(ctx context.Context, userID, messageID string) error
+}
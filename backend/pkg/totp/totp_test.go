@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of RFC 6238 Appendix B, "12345678901234567890".
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAtRFC6238(t *testing.T) {
+	// Appendix B lists 8-digit codes; with 6 digits they keep the last six.
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	} {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("T=%d: got %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("bad secret accepted")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	code := func(d int64) string {
+		c, err := CodeAt(rfcSecret, step+d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for d := int64(-1); d <= 1; d++ {
+		got, ok := Validate(rfcSecret, code(d), now, 1)
+		if !ok || got != step+d {
+			t.Errorf("code for step %+d: got (%d, %v), want (%d, true)", d, got, ok, step+d)
+		}
+	}
+	for _, d := range []int64{-2, 2} {
+		if _, ok := Validate(rfcSecret, code(d), now, 1); ok {
+			t.Errorf("code for step %+d accepted with skew 1", d)
+		}
+	}
+	if _, ok := Validate(rfcSecret, code(1), now, 0); ok {
+		t.Error("next step's code accepted with skew 0")
+	}
+
+	c := code(0)
+	if _, ok := Validate(rfcSecret, c[:3]+" "+c[3:], now, 0); !ok {
+		t.Error("code with a space rejected")
+	}
+	for _, bad := range []string{"", c[:5], c + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now, 1); ok {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	if _, err := CodeAt(a, 0); err != nil {
+		t.Errorf("generated secret unusable: %v", err)
+	}
+}